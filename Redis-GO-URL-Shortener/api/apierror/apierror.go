@@ -0,0 +1,118 @@
+package apierror
+
+import (
+	"errors"
+	"strconv"
+
+	"fiber-url-shortener/logging"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Code is a stable, machine-readable identifier for an error.
+// Clients should branch on the code rather than on the message, which may change.
+type Code string
+
+// Error codes returned by the API.
+const (
+	CodeInvalidJSON         Code = "invalid_json"         // The request body is not valid JSON.
+	CodeInvalidURL          Code = "invalid_url"          // The URL to shorten is not a valid URL.
+	CodeDomainNotAllowed    Code = "domain_not_allowed"   // The URL points at the shortener itself.
+	CodeShortTaken          Code = "short_taken"          // The requested custom short is already in use.
+	CodeShortNotFound       Code = "short_not_found"      // No URL is stored for the short.
+	CodeRateLimited         Code = "rate_limited"         // The client exhausted its quota for the current window.
+	CodeDatabaseUnavailable Code = "database_unavailable" // Redis could not be reached.
+	CodeNotFound            Code = "not_found"            // No route matches the request.
+	CodeMethodNotAllowed    Code = "method_not_allowed"   // The route exists but not for this method.
+	CodeBadRequest          Code = "bad_request"          // Any other malformed request.
+	CodeInternal            Code = "internal_error"       // An unexpected server error.
+)
+
+// Codes lists every error code, in the order they are documented.
+func Codes() []Code {
+	return []Code{
+		CodeInvalidJSON,
+		CodeInvalidURL,
+		CodeDomainNotAllowed,
+		CodeShortTaken,
+		CodeShortNotFound,
+		CodeRateLimited,
+		CodeDatabaseUnavailable,
+		CodeNotFound,
+		CodeMethodNotAllowed,
+		CodeBadRequest,
+		CodeInternal,
+	}
+}
+
+// Error is an API error carrying the HTTP status to respond with.
+// It is returned from handlers and rendered by Handler. The doc and required
+// tags feed the generated OpenAPI document.
+type Error struct {
+	Status  int            `json:"-"` // HTTP status code of the response.
+	Code    Code           `json:"code" required:"true" doc:"Stable, machine-readable error code."`
+	Message string         `json:"message" required:"true" doc:"Human-readable description of the error."`
+	Details map[string]any `json:"details,omitempty" doc:"Optional structured context, e.g. the reset time of a rate limit."`
+}
+
+// Envelope is the JSON body of every error response: {"error": {"code", "message", "details"}}.
+type Envelope struct {
+	Error *Error `json:"error"`
+}
+
+// New creates an error with the given status, code and message.
+func New(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// WithDetails returns a copy of e with details attached.
+func (e *Error) WithDetails(details map[string]any) *Error {
+	cp := *e
+	cp.Details = details
+	return &cp
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+// Handler is the application's fiber.ErrorHandler. It renders *Error values as the error
+// envelope, maps Fiber's own errors (unknown route, wrong method, ...) onto the same shape,
+// and hides the details of any other error behind a generic internal_error.
+func Handler(c *fiber.Ctx, err error) error {
+	var apiErr *Error
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &apiErr):
+	case errors.As(err, &fiberErr):
+		apiErr = fromFiber(fiberErr)
+	default:
+		logging.FromCtx(c).Error("unhandled error", "error", err)
+		apiErr = New(fiber.StatusInternalServerError, CodeInternal, "internal server error")
+	}
+
+	// Tell rate-limited clients when they may retry.
+	if apiErr.Status == fiber.StatusTooManyRequests {
+		if seconds, ok := apiErr.Details["retry_after_seconds"].(int); ok {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+		}
+	}
+
+	return c.Status(apiErr.Status).JSON(Envelope{Error: apiErr})
+}
+
+// fromFiber converts an error raised by Fiber itself into an API error.
+func fromFiber(e *fiber.Error) *Error {
+	switch e.Code {
+	case fiber.StatusNotFound:
+		return New(e.Code, CodeNotFound, "route not found")
+	case fiber.StatusMethodNotAllowed:
+		return New(e.Code, CodeMethodNotAllowed, "method not allowed")
+	default:
+		if e.Code >= fiber.StatusInternalServerError {
+			return New(e.Code, CodeInternal, "internal server error")
+		}
+		return New(e.Code, CodeBadRequest, e.Message)
+	}
+}
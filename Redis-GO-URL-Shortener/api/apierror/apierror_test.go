@@ -0,0 +1,123 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// newTestApp returns an app rendering errors with Handler, whose only route fails with err.
+func newTestApp(err error) *fiber.App {
+	app := fiber.New(fiber.Config{DisableStartupMessage: true, ErrorHandler: Handler})
+	app.Get("/fail", func(c *fiber.Ctx) error { return err })
+	return app
+}
+
+func TestHandler(t *testing.T) {
+	// Unhandled errors are logged; keep the test output clean.
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	tests := []struct {
+		name           string
+		method, path   string
+		err            error
+		wantStatus     int
+		wantCode       Code
+		wantMessage    string // Checked when not empty
+		wantRetryAfter string
+	}{
+		{
+			name:   "api error",
+			method: "GET", path: "/fail",
+			err:        New(fiber.StatusConflict, CodeShortTaken, "URL short already in use"),
+			wantStatus: fiber.StatusConflict, wantCode: CodeShortTaken, wantMessage: "URL short already in use",
+		},
+		{
+			name:   "wrapped api error",
+			method: "GET", path: "/fail",
+			err:        fmt.Errorf("shorten: %w", New(fiber.StatusBadRequest, CodeInvalidURL, "Invalid URL")),
+			wantStatus: fiber.StatusBadRequest, wantCode: CodeInvalidURL,
+		},
+		{
+			name:   "rate limit with retry time",
+			method: "GET", path: "/fail",
+			err:        New(fiber.StatusTooManyRequests, CodeRateLimited, "Rate limit exceeded").WithDetails(map[string]any{"retry_after_seconds": 90}),
+			wantStatus: fiber.StatusTooManyRequests, wantCode: CodeRateLimited, wantRetryAfter: "90",
+		},
+		{
+			name:   "rate limit without retry time",
+			method: "GET", path: "/fail",
+			err:        New(fiber.StatusTooManyRequests, CodeRateLimited, "Rate limit exceeded"),
+			wantStatus: fiber.StatusTooManyRequests, wantCode: CodeRateLimited,
+		},
+		{
+			name:   "retry time on another status",
+			method: "GET", path: "/fail",
+			err:        New(fiber.StatusServiceUnavailable, CodeDatabaseUnavailable, "Unable to connect to server").WithDetails(map[string]any{"retry_after_seconds": 5}),
+			wantStatus: fiber.StatusServiceUnavailable, wantCode: CodeDatabaseUnavailable,
+		},
+		{
+			name:   "fiber not found",
+			method: "GET", path: "/fail",
+			err:        fiber.ErrNotFound,
+			wantStatus: fiber.StatusNotFound, wantCode: CodeNotFound, wantMessage: "route not found",
+		},
+		{
+			name:   "wrong method",
+			method: "POST", path: "/fail",
+			wantStatus: fiber.StatusMethodNotAllowed, wantCode: CodeMethodNotAllowed,
+		},
+		{
+			name:   "other fiber client error",
+			method: "GET", path: "/fail",
+			err:        fiber.NewError(fiber.StatusRequestEntityTooLarge, "body too large"),
+			wantStatus: fiber.StatusRequestEntityTooLarge, wantCode: CodeBadRequest, wantMessage: "body too large",
+		},
+		{
+			name:   "fiber server error",
+			method: "GET", path: "/fail",
+			err:        fiber.NewError(fiber.StatusBadGateway, "upstream said no"),
+			wantStatus: fiber.StatusBadGateway, wantCode: CodeInternal, wantMessage: "internal server error",
+		},
+		{
+			name:   "unexpected error is hidden",
+			method: "GET", path: "/fail",
+			err:        errors.New("redis: connection pool exhausted"),
+			wantStatus: fiber.StatusInternalServerError, wantCode: CodeInternal, wantMessage: "internal server error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := newTestApp(tt.err).Test(httptest.NewRequest(tt.method, tt.path, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status: got %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Header.Get(fiber.HeaderRetryAfter); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After: got %q, want %q", got, tt.wantRetryAfter)
+			}
+
+			var body Envelope
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error == nil {
+				t.Fatalf("body is not an error envelope: %v", err)
+			}
+			if body.Error.Code != tt.wantCode {
+				t.Errorf("code: got %q, want %q", body.Error.Code, tt.wantCode)
+			}
+			if tt.wantMessage != "" && body.Error.Message != tt.wantMessage {
+				t.Errorf("message: got %q, want %q", body.Error.Message, tt.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting the shortener needs. It is loaded once at startup
+// and passed to the components that need it instead of being read at request time.
+type Config struct {
+	// Port is the address the HTTP server listens on, e.g. ":3000". Env: APP_PORT.
+	Port string `yaml:"port" toml:"port"`
+	// Domain is the public host of the service, used to build short links. Env: DOMAIN.
+	Domain string `yaml:"domain" toml:"domain"`
+
+	// DB holds the Redis connection settings.
+	DB Database `yaml:"db" toml:"db"`
+
+	// APIQuota is the number of shorten requests allowed per client IP per window. Env: API_QUOTA.
+	APIQuota int `yaml:"api_quota" toml:"api_quota"`
+	// QuotaWindow is how long a client's quota lasts before it is reset. Env: API_QUOTA_WINDOW.
+	QuotaWindow Duration `yaml:"quota_window" toml:"quota_window"`
+	// DefaultExpiry is the lifetime of a short link when the request does not set one, in whole hours. Env: DEFAULT_EXPIRY.
+	DefaultExpiry Duration `yaml:"default_expiry" toml:"default_expiry"`
+	// ShutdownTimeout is how long in-flight requests may run after a shutdown signal. Env: SHUTDOWN_TIMEOUT.
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+
+	// LogLevel is one of debug, info, warn or error. Env: LOG_LEVEL.
+	LogLevel string `yaml:"log_level" toml:"log_level"`
+	// LogFormat is json or text. Env: LOG_FORMAT.
+	LogFormat string `yaml:"log_format" toml:"log_format"`
+}
+
+// Database holds the Redis connection settings.
+type Database struct {
+	// Addr is the Redis server address (host:port). Env: DB_ADDR.
+	Addr string `yaml:"addr" toml:"addr"`
+	// Password is the Redis password, empty when authentication is disabled. Env: DB_PASS.
+	Password string `yaml:"password" toml:"password"`
+}
+
+// Duration is a time.Duration that is written in config files as a Go duration string, e.g. "30m".
+type Duration time.Duration
+
+// UnmarshalText parses a duration string such as "24h" or "90s".
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Default returns the configuration used for any setting that is not provided.
+func Default() Config {
+	return Config{
+		Port:            ":3000",
+		DB:              Database{Addr: "localhost:6379"},
+		APIQuota:        10,
+		QuotaWindow:     Duration(30 * time.Minute),
+		DefaultExpiry:   Duration(24 * time.Hour),
+		ShutdownTimeout: Duration(10 * time.Second),
+		LogLevel:        "info",
+		LogFormat:       "json",
+	}
+}
+
+// Load builds the configuration from, in increasing order of precedence:
+// built-in defaults, the optional YAML or TOML file at path, the .env file in the
+// working directory, and the process environment. An empty path skips the file.
+// The result is validated and every problem found is reported in the returned error.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	// A missing .env file is normal outside local development; variables set in the
+	// environment take precedence over values from the file.
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("load .env: %w", err)
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// loadFile decodes a YAML (.yaml, .yml) or TOML (.toml) file into cfg.
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("config file %s: unsupported extension %q (use .yaml, .yml or .toml)", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv overrides cfg with every environment variable that is set.
+func applyEnv(cfg *Config) error {
+	var errs []error
+
+	setString := func(key string, dst *string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+	setDuration := func(key string, dst *Duration) {
+		if v, ok := os.LookupEnv(key); ok {
+			if err := dst.UnmarshalText([]byte(v)); err != nil {
+				errs = append(errs, fmt.Errorf("%s: must be a duration such as \"30m\", got %q", key, v))
+			}
+		}
+	}
+
+	setString("APP_PORT", &cfg.Port)
+	setString("DOMAIN", &cfg.Domain)
+	setString("DB_ADDR", &cfg.DB.Addr)
+	setString("DB_PASS", &cfg.DB.Password)
+	setString("LOG_LEVEL", &cfg.LogLevel)
+	setString("LOG_FORMAT", &cfg.LogFormat)
+
+	if v, ok := os.LookupEnv("API_QUOTA"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("API_QUOTA: must be an integer, got %q", v))
+		} else {
+			cfg.APIQuota = n
+		}
+	}
+
+	setDuration("API_QUOTA_WINDOW", &cfg.QuotaWindow)
+	setDuration("DEFAULT_EXPIRY", &cfg.DefaultExpiry)
+	setDuration("SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout)
+
+	return errors.Join(errs...)
+}
+
+// Validate checks that every setting is usable and reports all problems at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(c.Port); err != nil {
+		errs = append(errs, fmt.Errorf("port: must be an address such as \":3000\", got %q", c.Port))
+	}
+	if c.Domain == "" {
+		errs = append(errs, errors.New("domain: must be set (DOMAIN)"))
+	}
+	if _, _, err := net.SplitHostPort(c.DB.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("db.addr: must be host:port, got %q", c.DB.Addr))
+	}
+	if c.APIQuota <= 0 {
+		errs = append(errs, fmt.Errorf("api_quota: must be positive, got %d", c.APIQuota))
+	}
+	if c.QuotaWindow <= 0 {
+		errs = append(errs, fmt.Errorf("quota_window: must be positive, got %s", time.Duration(c.QuotaWindow)))
+	}
+	// Link expiries are counted in whole hours, so anything else would be cut off silently.
+	if c.DefaultExpiry < Duration(time.Hour) || c.DefaultExpiry%Duration(time.Hour) != 0 {
+		errs = append(errs, fmt.Errorf("default_expiry: must be a whole number of hours, at least 1h, got %s", time.Duration(c.DefaultExpiry)))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("shutdown_timeout: must be positive, got %s", time.Duration(c.ShutdownTimeout)))
+	}
+	switch strings.ToLower(c.LogLevel) {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log_level: must be debug, info, warn or error, got %q", c.LogLevel))
+	}
+	switch strings.ToLower(c.LogFormat) {
+	case "json", "text":
+	default:
+		errs = append(errs, fmt.Errorf("log_format: must be json or text, got %q", c.LogFormat))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envKeys are every variable Load reads from the environment.
+var envKeys = []string{
+	"APP_PORT", "DOMAIN", "DB_ADDR", "DB_PASS", "LOG_LEVEL", "LOG_FORMAT",
+	"API_QUOTA", "API_QUOTA_WINDOW", "DEFAULT_EXPIRY", "SHUTDOWN_TIMEOUT",
+}
+
+// clearEnv unsets every variable Load reads for the duration of the test.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, key := range envKeys {
+		if v, ok := os.LookupEnv(key); ok {
+			os.Unsetenv(key)
+			t.Cleanup(func() { os.Setenv(key, v) })
+		}
+	}
+}
+
+// writeFile writes a config file named name into a temporary directory and returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// withDefaults returns the default configuration changed by change.
+func withDefaults(change func(*Config)) Config {
+	cfg := Default()
+	change(&cfg)
+	return cfg
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		file string // Config file name; no file when empty
+		body string // Config file content
+		env  map[string]string
+		want Config
+	}{
+		{
+			name: "defaults with only the required domain",
+			env:  map[string]string{"DOMAIN": "sho.rt"},
+			want: withDefaults(func(c *Config) { c.Domain = "sho.rt" }),
+		},
+		{
+			name: "yaml file over defaults",
+			file: "config.yaml",
+			body: "port: \":4000\"\ndomain: file.test\napi_quota: 5\ndefault_expiry: \"48h\"\n",
+			want: withDefaults(func(c *Config) {
+				c.Port, c.Domain, c.APIQuota, c.DefaultExpiry = ":4000", "file.test", 5, Duration(48*time.Hour)
+			}),
+		},
+		{
+			name: "environment over toml file",
+			file: "config.toml",
+			body: "port = \":4000\"\ndomain = \"file.test\"\napi_quota = 5\n",
+			env:  map[string]string{"DOMAIN": "env.test", "API_QUOTA": "7", "API_QUOTA_WINDOW": "1h"},
+			want: withDefaults(func(c *Config) {
+				c.Port, c.Domain, c.APIQuota, c.QuotaWindow = ":4000", "env.test", 7, Duration(time.Hour)
+			}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			path := ""
+			if tt.file != "" {
+				path = writeFile(t, tt.file, tt.body)
+			}
+
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if *cfg != tt.want {
+				t.Errorf("Load:\n got %+v\nwant %+v", *cfg, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		body    string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "integer not a number", env: map[string]string{"DOMAIN": "sho.rt", "API_QUOTA": "ten"}, wantErr: "API_QUOTA: must be an integer"},
+		{name: "duration not a duration", env: map[string]string{"DOMAIN": "sho.rt", "SHUTDOWN_TIMEOUT": "soon"}, wantErr: "SHUTDOWN_TIMEOUT: must be a duration"},
+		{name: "unsupported file", file: "config.json", body: "{}", wantErr: "unsupported extension"},
+		{name: "invalid after merging", file: "config.yaml", body: "domain: file.test\n", env: map[string]string{"DEFAULT_EXPIRY": "90m"}, wantErr: "default_expiry"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			path := ""
+			if tt.file != "" {
+				path = writeFile(t, tt.file, tt.body)
+			}
+
+			_, err := Load(path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load: got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(*Config)
+		wantErr string // Empty when the configuration is valid
+	}{
+		{name: "valid", change: func(*Config) {}},
+		{name: "port without colon", change: func(c *Config) { c.Port = "3000" }, wantErr: "port:"},
+		{name: "missing domain", change: func(c *Config) { c.Domain = "" }, wantErr: "domain: must be set"},
+		{name: "redis address without port", change: func(c *Config) { c.DB.Addr = "localhost" }, wantErr: "db.addr:"},
+		{name: "zero quota", change: func(c *Config) { c.APIQuota = 0 }, wantErr: "api_quota:"},
+		{name: "negative quota window", change: func(c *Config) { c.QuotaWindow = Duration(-time.Minute) }, wantErr: "quota_window:"},
+		{name: "expiry under an hour", change: func(c *Config) { c.DefaultExpiry = Duration(30 * time.Minute) }, wantErr: "default_expiry:"},
+		{name: "expiry in partial hours", change: func(c *Config) { c.DefaultExpiry = Duration(90 * time.Minute) }, wantErr: "default_expiry:"},
+		{name: "expiry in whole hours", change: func(c *Config) { c.DefaultExpiry = Duration(72 * time.Hour) }},
+		{name: "zero shutdown timeout", change: func(c *Config) { c.ShutdownTimeout = 0 }, wantErr: "shutdown_timeout:"},
+		{name: "unknown log level", change: func(c *Config) { c.LogLevel = "verbose" }, wantErr: "log_level:"},
+		{name: "unknown log format", change: func(c *Config) { c.LogFormat = "xml" }, wantErr: "log_format:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Domain = "sho.rt"
+			tt.change(&cfg)
+
+			err := cfg.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate: unexpected error %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Validate: got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	cfg := Default()
+	cfg.Port = "bad"
+	cfg.APIQuota = -1
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate: no error")
+	}
+	for _, want := range []string{"port:", "domain:", "api_quota:"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate: %q is missing %q", err, want)
+		}
+	}
+}
@@ -2,7 +2,8 @@ package database
 
 import (
 	"context"
-	"os"
+	"strconv"
+	"sync"
 
 	"github.com/go-redis/redis/v8"
 )
@@ -11,16 +12,66 @@ import (
 // It allows Redis commands to be executed with a consistent context.
 var Ctx = context.Background()
 
+var (
+	// addr and password are the Redis connection settings applied to every client.
+	addr, password string
+
+	// clients holds the shared Redis client for each database number, so that
+	// connections are pooled across requests instead of dialled per request.
+	clients   = map[int]*redis.Client{}
+	clientsMu sync.Mutex
+)
+
+// Configure sets the Redis server address and password used by clients created afterwards.
+// It must be called once at startup, before the first call to Client.
+func Configure(dbAddr, dbPassword string) {
+	addr, password = dbAddr, dbPassword
+}
+
 // CreateClient creates and returns a Redis client connected to the specified database number.
-// The Redis connection details are read from environment variables.
+// The Redis connection details are the ones set with Configure.
+// The client is instrumented with Prometheus metrics for command latency and errors.
 func CreateClient(dbNo int) *redis.Client {
 	// Initialize a new Redis client with options.
 	rdb := redis.NewClient(&redis.Options{
-		Addr:     os.Getenv("DB_ADDR"), // Redis server address (host:port), loaded from the environment.
-		Password: os.Getenv("DB_PASS"), // Redis server password, loaded from the environment (if required).
-		DB:       dbNo,                 // The specific Redis database number to connect to.
+		Addr:     addr,     // Redis server address (host:port).
+		Password: password, // Redis server password (if required).
+		DB:       dbNo,     // The specific Redis database number to connect to.
 	})
 
+	// Record latency and errors for every command issued through this client.
+	rdb.AddHook(metricsHook{db: strconv.Itoa(dbNo)})
+
 	// Return the configured Redis client.
 	return rdb
 }
+
+// Client returns the shared Redis client for the specified database number,
+// creating it on first use. Callers must not close the returned client;
+// use CloseClients during shutdown instead.
+func Client(dbNo int) *redis.Client {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	rdb, ok := clients[dbNo]
+	if !ok {
+		rdb = CreateClient(dbNo)
+		clients[dbNo] = rdb
+	}
+	return rdb
+}
+
+// CloseClients closes every shared client and releases its connections.
+func CloseClients() error {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	var firstErr error
+	for dbNo, rdb := range clients {
+		if err := rdb.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(clients, dbNo)
+	}
+	return firstErr
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"fiber-url-shortener/metrics"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// startKey is the context key under which metricsHook stores the command start time.
+type startKey struct{}
+
+// metricsHook is a redis.Hook that records command latency and errors for one database.
+type metricsHook struct {
+	db string // Database number, used as the "db" label.
+}
+
+// BeforeProcess stores the start time of a single command in its context.
+func (h metricsHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+// AfterProcess observes the latency of a single command and counts it if it failed.
+func (h metricsHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.observe(ctx, cmd.Name(), cmd.Err())
+	return nil
+}
+
+// BeforeProcessPipeline stores the start time of a pipeline in its context.
+func (h metricsHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+// AfterProcessPipeline observes the latency of a whole pipeline under the "pipeline" command label.
+func (h metricsHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmd.Err() != nil && cmd.Err() != redis.Nil {
+			err = cmd.Err()
+			break
+		}
+	}
+	h.observe(ctx, "pipeline", err)
+	return nil
+}
+
+// observe records the elapsed time since the start stored in ctx and, unless err is nil
+// or redis.Nil, increments the error counter.
+func (h metricsHook) observe(ctx context.Context, command string, err error) {
+	if start, ok := ctx.Value(startKey{}).(time.Time); ok {
+		metrics.RedisCommandDuration.WithLabelValues(h.db, command).Observe(time.Since(start).Seconds())
+	}
+	if err != nil && err != redis.Nil {
+		metrics.RedisErrorsTotal.WithLabelValues(h.db, command).Inc()
+	}
+}
+
+// poolCollector exports connection pool statistics of the shared clients.
+type poolCollector struct {
+	hits       *prometheus.Desc
+	misses     *prometheus.Desc
+	timeouts   *prometheus.Desc
+	totalConns *prometheus.Desc
+	idleConns  *prometheus.Desc
+	staleConns *prometheus.Desc
+}
+
+func newPoolCollector() *poolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc("shortener_redis_pool_"+name, help, []string{"db"}, nil)
+	}
+	return &poolCollector{
+		hits:       desc("hits_total", "Number of times a free connection was found in the pool."),
+		misses:     desc("misses_total", "Number of times a free connection was not found in the pool."),
+		timeouts:   desc("timeouts_total", "Number of times a wait for a pool connection timed out."),
+		totalConns: desc("connections", "Number of connections currently in the pool."),
+		idleConns:  desc("idle_connections", "Number of idle connections currently in the pool."),
+		staleConns: desc("stale_connections_total", "Number of stale connections removed from the pool."),
+	}
+}
+
+// Describe sends the descriptors of all pool metrics.
+func (p *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.hits
+	ch <- p.misses
+	ch <- p.timeouts
+	ch <- p.totalConns
+	ch <- p.idleConns
+	ch <- p.staleConns
+}
+
+// Collect reads the current pool statistics of every shared client.
+func (p *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	for dbNo, rdb := range clients {
+		db := strconv.Itoa(dbNo)
+		s := rdb.PoolStats()
+		ch <- prometheus.MustNewConstMetric(p.hits, prometheus.CounterValue, float64(s.Hits), db)
+		ch <- prometheus.MustNewConstMetric(p.misses, prometheus.CounterValue, float64(s.Misses), db)
+		ch <- prometheus.MustNewConstMetric(p.timeouts, prometheus.CounterValue, float64(s.Timeouts), db)
+		ch <- prometheus.MustNewConstMetric(p.totalConns, prometheus.GaugeValue, float64(s.TotalConns), db)
+		ch <- prometheus.MustNewConstMetric(p.idleConns, prometheus.GaugeValue, float64(s.IdleConns), db)
+		ch <- prometheus.MustNewConstMetric(p.staleConns, prometheus.CounterValue, float64(s.StaleConns), db)
+	}
+}
+
+func init() {
+	prometheus.MustRegister(newPoolCollector())
+}
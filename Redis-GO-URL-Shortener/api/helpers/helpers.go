@@ -1,7 +1,6 @@
 package helpers
 
 import (
-	"os"
 	"strings"
 )
 
@@ -19,9 +18,9 @@ func EnforceHTTP(url string) string {
 // RemoveDomainError validates that the given URL does not match the application's domain.
 // This is used to prevent users from creating shortened URLs that point to the application's domain itself,
 // which could cause infinite redirect loops.
-func RemoveDomainError(url string) bool {
-	// Check if the provided URL matches the domain specified in the environment variable.
-	if url == os.Getenv("DOMAIN") {
+func RemoveDomainError(url, domain string) bool {
+	// Check if the provided URL matches the application's domain.
+	if url == domain {
 		return false
 	}
 
@@ -34,7 +33,7 @@ func RemoveDomainError(url string) bool {
 	newURL = strings.Split(newURL, "/")[0]
 
 	// If the processed URL matches the application's domain, return false to indicate an error.
-	if newURL == os.Getenv("DOMAIN") {
+	if newURL == domain {
 		return false
 	}
 
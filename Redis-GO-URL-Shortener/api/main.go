@@ -1,47 +1,195 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"fiber-url-shortener/apierror"
+	"fiber-url-shortener/config"
+	"fiber-url-shortener/database"
+	"fiber-url-shortener/logging"
+	"fiber-url-shortener/metrics"
 	"fiber-url-shortener/routes"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/logger"
-	"github.com/joho/godotenv"
+	"github.com/valyala/fasthttp"
 )
 
 // setupRoutes configures the API endpoints for the application.
-// It defines two main routes:
+// It defines the following routes:
+// - GET "/healthz": Liveness probe, reports that the process is up.
+// - GET "/readyz": Readiness probe, reports whether Redis is reachable.
+// - GET "/metrics": Prometheus metrics in the text exposition format.
+// - GET "/api/v1/openapi.json": OpenAPI 3 document describing the API.
 // - GET "/:url": Resolves a shortened URL to the original URL and redirects the user.
 // - POST "/api/v1": Accepts a URL from the client and returns a shortened version.
-func setupRoutes(app *fiber.App) {
+func setupRoutes(app *fiber.App, cfg *config.Config) error {
+	// Health endpoints are registered before the catch-all resolver so they are not
+	// treated as short identifiers; routes.ShortenURL refuses them as custom shorts.
+	// They are not subject to the API rate limit.
+	app.Get("/healthz", routes.Healthz)
+	app.Get("/readyz", routes.Readyz)
+
+	// Metrics endpoint for Prometheus scraping, also registered ahead of the resolver.
+	app.Get("/metrics", metrics.Handler())
+
 	// Route to resolve shortened URLs to their original destinations.
 	app.Get("/:url", routes.ResolveURL)
 
 	// Route to create a shortened URL from the provided original URL.
-	app.Post("/api/v1", routes.ShortenURL)
+	app.Post("/api/v1", routes.ShortenURL(cfg))
+
+	// Route serving the generated OpenAPI document.
+	openAPI, err := routes.OpenAPI(cfg)
+	if err != nil {
+		return err
+	}
+	app.Get("/api/v1/openapi.json", openAPI)
+
+	return nil
+}
+
+// connTracker records the open client connections, so a shutdown that runs out of time
+// can close them instead of leaving them open.
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// newConnTracker creates an empty connTracker.
+func newConnTracker() *connTracker {
+	return &connTracker{conns: make(map[net.Conn]struct{})}
+}
+
+// track is the server's ConnState hook.
+func (t *connTracker) track(c net.Conn, state fasthttp.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch state {
+	case fasthttp.StateNew:
+		t.conns[c] = struct{}{}
+	case fasthttp.StateHijacked, fasthttp.StateClosed:
+		delete(t.conns, c)
+	}
+}
+
+// closeAll closes every open connection and returns how many there were.
+func (t *connTracker) closeAll() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for c := range t.conns {
+		_ = c.Close()
+	}
+	n := len(t.conns)
+	clear(t.conns)
+	return n
+}
+
+// shutdownGrace bounds the wait for the server to stop after its connections were closed.
+const shutdownGrace = time.Second
+
+// shutdown stops the server from accepting new connections and waits up to timeout
+// for in-flight requests to complete. Connections still open after timeout are closed,
+// which ends the server's wait for them.
+func shutdown(app *fiber.App, conns *connTracker, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- app.Shutdown()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+	}
+
+	closed := conns.closeAll()
+	select {
+	case <-done:
+	case <-time.After(shutdownGrace):
+	}
+	return fmt.Errorf("shutdown timed out after %s, closed %d open connections", timeout, closed)
 }
 
 func main() {
-	// Load environment variables from the .env file.
-	err := godotenv.Load()
+	// An optional YAML or TOML config file can be given with -config or CONFIG_FILE.
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	flag.Parse()
+
+	// Load and validate the configuration from defaults, the config file, .env and the environment.
+	// Refuse to start with an invalid configuration rather than failing at request time.
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Create the structured logger with the configured level and output encoding.
+	logger, err := logging.New(os.Stdout, cfg.LogLevel, cfg.LogFormat)
 	if err != nil {
-		// Log an error if the .env file could not be loaded, but continue execution.
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
+	slog.SetDefault(logger)
 
-	// Create a new Fiber app instance.
-	app := fiber.New()
+	// Point the Redis clients at the configured server.
+	database.Configure(cfg.DB.Addr, cfg.DB.Password)
 
-	// Use the logger middleware to log all requests for debugging and monitoring.
-	app.Use(logger.New())
+	// Create a new Fiber app instance. The startup banner is disabled so all output is structured,
+	// and every error is rendered as the API's JSON error envelope.
+	app := fiber.New(fiber.Config{
+		DisableStartupMessage: true,
+		ErrorHandler:          apierror.Handler,
+	})
+
+	// Track client connections so they can be closed if draining them takes too long.
+	conns := newConnTracker()
+	app.Server().ConnState = conns.track
+
+	// Assign request IDs and log every request as structured JSON for debugging and monitoring.
+	app.Use(logging.Middleware(logger))
+
+	// Record request counts and latency for every route.
+	app.Use(metrics.Middleware)
 
 	// Set up the application routes.
-	setupRoutes(app)
+	if err := setupRoutes(app, cfg); err != nil {
+		logger.Error("cannot set up routes", "error", err)
+		os.Exit(1)
+	}
 
-	// Start the Fiber server and listen on the port specified in the environment variable APP_PORT.
+	// Start the Fiber server in the background on the configured port.
 	// If the server fails to start, log the error and exit the program.
-	log.Fatal(app.Listen(os.Getenv("APP_PORT")))
+	go func() {
+		logger.Info("server starting", "addr", cfg.Port)
+		if err := app.Listen(cfg.Port); err != nil {
+			logger.Error("server failed", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	// Block until the process is asked to stop (Ctrl+C locally, SIGTERM from Docker or Kubernetes).
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+
+	// Drain in-flight requests before exiting.
+	logger.Info("shutting down server")
+	if err := shutdown(app, conns, time.Duration(cfg.ShutdownTimeout)); err != nil {
+		logger.Error("shutdown failed", "error", err)
+		os.Exit(1)
+	}
+
+	// Release pooled Redis connections once no handler can use them anymore.
+	if err := database.CloseClients(); err != nil {
+		logger.Warn("cannot close Redis clients", "error", err)
+	}
+	logger.Info("server stopped")
 }
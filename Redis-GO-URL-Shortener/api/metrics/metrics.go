@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+// namespace prefixes every metric exported by the shortener.
+const namespace = "shortener"
+
+var (
+	// RequestsTotal counts HTTP requests by method, route pattern and status code.
+	RequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Total number of HTTP requests processed, by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	// RequestDuration observes HTTP request latency by method, route pattern and status code.
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency in seconds, by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// RedirectsTotal counts short links successfully resolved to their original URL.
+	RedirectsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redirects_total",
+		Help:      "Total number of short links resolved and redirected.",
+	})
+
+	// LinksCreatedTotal counts short links stored, split by whether the short was custom or generated.
+	LinksCreatedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "links_created_total",
+		Help:      "Total number of short links created, by short type (custom or generated).",
+	}, []string{"type"})
+
+	// RateLimitRejectionsTotal counts shorten requests rejected because the client exhausted its quota.
+	RateLimitRejectionsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Total number of requests rejected by the API rate limit.",
+	})
+
+	// RedisCommandDuration observes Redis command latency by database number and command name.
+	RedisCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redis_command_duration_seconds",
+		Help:      "Redis command latency in seconds, by database and command.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"db", "command"})
+
+	// RedisErrorsTotal counts failed Redis commands by database number and command name.
+	// A missing key (redis.Nil) is an expected result and is not counted as an error.
+	RedisErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redis_errors_total",
+		Help:      "Total number of failed Redis commands, by database and command.",
+	}, []string{"db", "command"})
+)
+
+func init() {
+	// Register all collectors with the default registry, which also exposes Go runtime and process metrics.
+	prometheus.MustRegister(
+		RequestsTotal,
+		RequestDuration,
+		RedirectsTotal,
+		LinksCreatedTotal,
+		RateLimitRejectionsTotal,
+		RedisCommandDuration,
+		RedisErrorsTotal,
+	)
+}
+
+// Middleware records the count and latency of every request handled by the app.
+// Requests are labelled by route pattern (e.g. "/:url") rather than the raw path
+// to keep label cardinality bounded.
+func Middleware(c *fiber.Ctx) error {
+	start := time.Now()
+
+	// Render a returned error right away, as Fiber's own logger middleware does, so the
+	// recorded status matches the response the client receives.
+	if err := c.Next(); err != nil {
+		if herr := c.App().ErrorHandler(c, err); herr != nil {
+			_ = c.SendStatus(fiber.StatusInternalServerError)
+		}
+	}
+	status := c.Response().StatusCode()
+
+	// Fiber strings alias buffers that are reused across requests, so copy the
+	// method before it is retained as a label value.
+	labels := prometheus.Labels{
+		"method": strings.Clone(c.Method()),
+		"route":  c.Route().Path,
+		"status": strconv.Itoa(status),
+	}
+	RequestsTotal.With(labels).Inc()
+	RequestDuration.With(labels).Observe(time.Since(start).Seconds())
+
+	return nil
+}
+
+// Handler returns a Fiber handler that serves all registered metrics in the Prometheus text format.
+func Handler() fiber.Handler {
+	h := fasthttpadaptor.NewFastHTTPHandler(promhttp.Handler())
+	return func(c *fiber.Ctx) error {
+		h(c.Context())
+		return nil
+	}
+}
@@ -0,0 +1,49 @@
+package routes
+
+import (
+	"context"
+	"time"
+
+	"fiber-url-shortener/database"
+	"fiber-url-shortener/logging"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// healthStatus is the JSON payload returned by the health endpoints.
+type healthStatus struct {
+	Status string `json:"status" required:"true" doc:"ok, ready or unavailable."`
+	Error  string `json:"error,omitempty" doc:"Why the service is not ready, if it is not."`
+}
+
+// readyTimeout bounds how long the readiness probe waits for Redis to answer a PING.
+const readyTimeout = 2 * time.Second
+
+// Healthz reports that the process is up and able to serve HTTP requests.
+// It does not touch Redis, so orchestrators can use it as a liveness probe
+// without restarting the service when only the database is unavailable.
+func Healthz(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(healthStatus{Status: "ok"})
+}
+
+// Readyz reports whether the service can handle traffic by pinging Redis.
+// It returns 503 Service Unavailable when the database cannot be reached,
+// so load balancers stop routing requests until connectivity is restored.
+func Readyz(c *fiber.Ctx) error {
+	// Use the URL storage database (DB 0), which every request depends on.
+	r := database.Client(0)
+
+	// Bound the ping so a hung Redis does not stall the probe.
+	ctx, cancel := context.WithTimeout(database.Ctx, readyTimeout)
+	defer cancel()
+
+	if err := r.Ping(ctx).Err(); err != nil {
+		logging.FromCtx(c).Warn("readiness check failed", "error", err)
+		return c.Status(fiber.StatusServiceUnavailable).JSON(healthStatus{
+			Status: "unavailable",
+			Error:  "cannot connect to DB",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(healthStatus{Status: "ready"})
+}
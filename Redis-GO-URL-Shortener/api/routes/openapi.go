@@ -0,0 +1,164 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"fiber-url-shortener/apierror"
+	"fiber-url-shortener/config"
+	"fiber-url-shortener/openapi"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// OpenAPI returns a handler serving the OpenAPI 3 document of the API.
+// The document is generated once from the request, response and error types,
+// so it stays in sync with the handlers.
+func OpenAPI(cfg *config.Config) (fiber.Handler, error) {
+	body, err := json.Marshal(Spec(cfg))
+	if err != nil {
+		return nil, err
+	}
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.Status(fiber.StatusOK).Send(body)
+	}, nil
+}
+
+// Spec builds the OpenAPI 3 document describing every endpoint and schema of the API.
+func Spec(cfg *config.Config) *openapi.Document {
+	// Error schema: the code is documented as an enum of every stable error code.
+	errSchema := openapi.SchemaOf(apierror.Error{})
+	for _, code := range apierror.Codes() {
+		errSchema.Properties["code"].Enum = append(errSchema.Properties["code"].Enum, code)
+	}
+
+	errorResponse := func(description string) openapi.Response {
+		return openapi.Response{Description: description, Content: openapi.JSON(openapi.Ref("ErrorEnvelope"))}
+	}
+	healthResponse := func(description string) openapi.Response {
+		return openapi.Response{Description: description, Content: openapi.JSON(openapi.Ref("HealthStatus"))}
+	}
+
+	return &openapi.Document{
+		OpenAPI: openapi.Version,
+		Info: openapi.Info{
+			Title:       "Redis Go URL Shortener",
+			Description: "Shortens URLs, resolves short links and rate limits clients per IP.",
+			Version:     "1.0.0",
+		},
+		Servers: []openapi.Server{{URL: "/"}},
+		Paths: map[string]openapi.PathItem{
+			"/api/v1": {
+				"post": {
+					OperationID: "shortenURL",
+					Summary:     "Shorten a URL",
+					Description: fmt.Sprintf("Stores the URL under a generated or custom short and returns the short link. "+
+						"Each client IP may create %d links per %s.", cfg.APIQuota, time.Duration(cfg.QuotaWindow)),
+					Tags: []string{"links"},
+					RequestBody: &openapi.RequestBody{
+						Required: true,
+						Content:  openapi.JSON(openapi.Ref("ShortenRequest")),
+					},
+					Responses: map[string]openapi.Response{
+						"200": {Description: "The short link was created.", Content: openapi.JSON(openapi.Ref("ShortenResponse"))},
+						"400": errorResponse("The body is not valid JSON (invalid_json), the URL is invalid (invalid_url) or points at this service (domain_not_allowed)."),
+						"409": errorResponse("The custom short is already in use or reserved (short_taken)."),
+						"429": {
+							Description: "The client exhausted its quota for the current window (rate_limited).",
+							Headers: map[string]openapi.Header{
+								"Retry-After": {Description: "Seconds until the quota resets.", Schema: &openapi.Schema{Type: "integer"}},
+							},
+							Content: openapi.JSON(openapi.Ref("ErrorEnvelope")),
+						},
+						"503": errorResponse("Redis could not be reached (database_unavailable)."),
+					},
+				},
+			},
+			"/{url}": {
+				"get": {
+					OperationID: "resolveURL",
+					Summary:     "Resolve a short link",
+					Description: "Redirects to the original URL stored under the short.",
+					Tags:        []string{"links"},
+					Parameters: []openapi.Parameter{{
+						Name:        "url",
+						In:          "path",
+						Description: "The short identifier.",
+						Required:    true,
+						Schema:      &openapi.Schema{Type: "string"},
+					}},
+					Responses: map[string]openapi.Response{
+						"301": {
+							Description: "Redirect to the original URL.",
+							Headers: map[string]openapi.Header{
+								"Location": {Description: "The original URL.", Schema: &openapi.Schema{Type: "string"}},
+							},
+						},
+						"404": errorResponse("No URL is stored for the short (short_not_found)."),
+						"503": errorResponse("Redis could not be reached (database_unavailable)."),
+					},
+				},
+			},
+			"/healthz": {
+				"get": {
+					OperationID: "healthz",
+					Summary:     "Liveness probe",
+					Tags:        []string{"operations"},
+					Responses: map[string]openapi.Response{
+						"200": healthResponse("The process is up."),
+					},
+				},
+			},
+			"/readyz": {
+				"get": {
+					OperationID: "readyz",
+					Summary:     "Readiness probe",
+					Description: "Reports whether Redis answers a PING.",
+					Tags:        []string{"operations"},
+					Responses: map[string]openapi.Response{
+						"200": healthResponse("The service is ready to handle traffic."),
+						"503": healthResponse("Redis could not be reached."),
+					},
+				},
+			},
+			"/metrics": {
+				"get": {
+					OperationID: "metrics",
+					Summary:     "Prometheus metrics",
+					Tags:        []string{"operations"},
+					Responses: map[string]openapi.Response{
+						"200": {
+							Description: "Metrics in the Prometheus text exposition format.",
+							Content:     map[string]openapi.MediaType{"text/plain": {Schema: &openapi.Schema{Type: "string"}}},
+						},
+					},
+				},
+			},
+			"/api/v1/openapi.json": {
+				"get": {
+					OperationID: "openapi",
+					Summary:     "This OpenAPI document",
+					Tags:        []string{"operations"},
+					Responses: map[string]openapi.Response{
+						"200": {Description: "The OpenAPI 3 document.", Content: openapi.JSON(&openapi.Schema{Type: "object"})},
+					},
+				},
+			},
+		},
+		Components: openapi.Components{
+			Schemas: map[string]*openapi.Schema{
+				"ShortenRequest":  openapi.SchemaOf(request{}),
+				"ShortenResponse": openapi.SchemaOf(response{}),
+				"HealthStatus":    openapi.SchemaOf(healthStatus{}),
+				"Error":           errSchema,
+				"ErrorEnvelope": {
+					Type:       "object",
+					Properties: map[string]*openapi.Schema{"error": openapi.Ref("Error")},
+					Required:   []string{"error"},
+				},
+			},
+		},
+	}
+}
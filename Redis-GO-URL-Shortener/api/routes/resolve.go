@@ -1,7 +1,10 @@
 package routes
 
 import (
+	"fiber-url-shortener/apierror"
 	"fiber-url-shortener/database"
+	"fiber-url-shortener/logging"
+	"fiber-url-shortener/metrics"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/gofiber/fiber/v2"
@@ -15,27 +18,23 @@ func ResolveURL(c *fiber.Ctx) error {
 	url := c.Params("url")
 
 	// Query the Redis database (DB 0) for the original URL associated with the short identifier.
-	r := database.CreateClient(0) // Redis client for URL storage.
-	defer r.Close()
+	r := database.Client(0) // Redis client for URL storage.
 
 	// Get the original URL from the database.
 	value, err := r.Get(database.Ctx, url).Result()
 	if err == redis.Nil {
 		// If the short identifier is not found in the database, return a 404 Not Found error.
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "short not found on database",
-		})
+		return apierror.New(fiber.StatusNotFound, apierror.CodeShortNotFound, "short not found on database")
 	} else if err != nil {
-		// If there's an error connecting to the database, return a 500 Internal Server Error.
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "cannot connect to DB",
-		})
+		// If there's an error connecting to the database, return a 503 Service Unavailable error.
+		logging.FromCtx(c).Error("cannot resolve short", "short", url, "error", err)
+		return apierror.New(fiber.StatusServiceUnavailable, apierror.CodeDatabaseUnavailable, "cannot connect to DB")
 	}
 
 	// Increment the redirection counter in Redis (DB 1) for analytics or tracking purposes.
-	rInr := database.CreateClient(1) // Redis client for the counter.
-	defer rInr.Close()
+	rInr := database.Client(1)             // Redis client for the counter.
 	_ = rInr.Incr(database.Ctx, "counter") // Increment the "counter" key.
+	metrics.RedirectsTotal.Inc()
 
 	// Redirect the user to the original URL with a 301 Moved Permanently status.
 	return c.Redirect(value, 301)
@@ -1,12 +1,15 @@
 package routes
 
 import (
-	"os"
 	"strconv"
 	"time"
 
+	"fiber-url-shortener/apierror"
+	"fiber-url-shortener/config"
 	"fiber-url-shortener/database"
 	"fiber-url-shortener/helpers"
+	"fiber-url-shortener/logging"
+	"fiber-url-shortener/metrics"
 
 	"github.com/asaskevich/govalidator"
 	"github.com/go-redis/redis/v8"
@@ -15,121 +18,135 @@ import (
 )
 
 // request represents the structure of the incoming JSON payload for shortening a URL.
+// The doc and required tags feed the generated OpenAPI document.
 type request struct {
-	URL         string        `json:"url"`    // The original URL to be shortened.
-	CustomShort string        `json:"short"`  // Optional custom short identifier for the URL.
-	Expiry      time.Duration `json:"expiry"` // Expiry time for the shortened URL in hours.
+	URL         string        `json:"url" required:"true" doc:"The original URL to be shortened."`
+	CustomShort string        `json:"short" doc:"Optional custom short identifier for the URL."`
+	Expiry      time.Duration `json:"expiry" doc:"Expiry time for the shortened URL in hours. Defaults to DEFAULT_EXPIRY."`
 }
 
 // response represents the structure of the JSON payload returned to the client.
 type response struct {
-	URL             string        `json:"url"`              // The original URL.
-	CustomShort     string        `json:"short"`            // The generated or custom short URL.
-	Expiry          time.Duration `json:"expiry"`           // Expiry time of the shortened URL in hours.
-	XRateRemaining  int           `json:"rate_limit"`       // Remaining requests in the current rate limit window.
-	XRateLimitReset time.Duration `json:"rate_limit_reset"` // Time (in minutes) until the rate limit resets.
+	URL             string        `json:"url" required:"true" doc:"The original URL."`
+	CustomShort     string        `json:"short" required:"true" doc:"The generated or custom short URL."`
+	Expiry          time.Duration `json:"expiry" required:"true" doc:"Expiry time of the shortened URL in hours."`
+	XRateRemaining  int           `json:"rate_limit" required:"true" doc:"Remaining requests in the current rate limit window."`
+	XRateLimitReset time.Duration `json:"rate_limit_reset" required:"true" doc:"Time in minutes until the rate limit resets."`
 }
 
-// ShortenURL handles the creation of shortened URLs.
+// reservedShorts are the paths served ahead of the resolver in main.go. A custom short with
+// one of these names could never be resolved, so they are refused.
+var reservedShorts = map[string]bool{
+	"healthz": true,
+	"readyz":  true,
+	"metrics": true,
+}
+
+// ShortenURL returns the handler for the creation of shortened URLs.
 // It validates the input, applies rate limiting, generates or validates custom short identifiers,
-// and stores the mapping in a Redis database.
-func ShortenURL(c *fiber.Ctx) error {
-	// Parse the incoming JSON request body into the `request` struct.
-	body := new(request)
-	if err := c.BodyParser(&body); err != nil {
-		// Return a 400 Bad Request error if the body cannot be parsed.
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "cannot parse JSON",
-		})
-	}
+// and stores the mapping in a Redis database. Quota, window, default expiry and domain come from cfg.
+func ShortenURL(cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Parse the incoming JSON request body into the `request` struct.
+		body := new(request)
+		if err := c.BodyParser(&body); err != nil {
+			// Return a 400 Bad Request error if the body cannot be parsed.
+			return apierror.New(fiber.StatusBadRequest, apierror.CodeInvalidJSON, "cannot parse JSON")
+		}
 
-	// Rate limiting: Check if the user's IP has remaining quota.
-	r2 := database.CreateClient(1) // Use Redis database 1 for rate limiting.
-	defer r2.Close()
-	val, err := r2.Get(database.Ctx, c.IP()).Result()
-	if err == redis.Nil {
-		// If the IP is not found in the database, initialize it with the API quota and expiry.
-		_ = r2.Set(database.Ctx, c.IP(), os.Getenv("API_QUOTA"), 30*60*time.Second).Err()
-	} else {
-		// If the IP is found, check the remaining quota.
-		val, _ = r2.Get(database.Ctx, c.IP()).Result()
-		valInt, _ := strconv.Atoi(val)
-		if valInt <= 0 {
-			// If the quota is exhausted, return a rate limit exceeded error.
-			limit, _ := r2.TTL(database.Ctx, c.IP()).Result()
-			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
-				"error":            "Rate limit exceeded",
-				"rate_limit_reset": limit / time.Nanosecond / time.Minute,
-			})
+		// Rate limiting: Check if the user's IP has remaining quota.
+		r2 := database.Client(1) // Use Redis database 1 for rate limiting.
+		val, err := r2.Get(database.Ctx, c.IP()).Result()
+		if err == redis.Nil {
+			// If the IP is not found in the database, initialize it with the API quota and expiry.
+			_ = r2.Set(database.Ctx, c.IP(), cfg.APIQuota, time.Duration(cfg.QuotaWindow)).Err()
+		} else if err != nil {
+			// The quota cannot be checked while Redis is unreachable.
+			logging.FromCtx(c).Error("cannot read rate limit", "ip", c.IP(), "error", err)
+			return apierror.New(fiber.StatusServiceUnavailable, apierror.CodeDatabaseUnavailable, "Unable to connect to server")
+		} else {
+			// If the IP is found, check the remaining quota.
+			valInt, _ := strconv.Atoi(val)
+			if valInt <= 0 {
+				// If the quota is exhausted, return a 429 Too Many Requests error with the time until reset.
+				metrics.RateLimitRejectionsTotal.Inc()
+				logging.FromCtx(c).Info("rate limit exceeded", "ip", c.IP())
+				limit, _ := r2.TTL(database.Ctx, c.IP()).Result()
+				return apierror.New(fiber.StatusTooManyRequests, apierror.CodeRateLimited, "Rate limit exceeded").
+					WithDetails(map[string]any{
+						"rate_limit_reset":    int(limit / time.Minute),
+						"retry_after_seconds": int(limit / time.Second),
+					})
+			}
 		}
-	}
 
-	// Validate the provided URL.
-	if !govalidator.IsURL(body.URL) {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid URL",
-		})
-	}
+		// Validate the provided URL.
+		if !govalidator.IsURL(body.URL) {
+			return apierror.New(fiber.StatusBadRequest, apierror.CodeInvalidURL, "Invalid URL")
+		}
 
-	// Prevent shortening of the domain itself to avoid infinite redirect loops.
-	if !helpers.RemoveDomainError(body.URL) {
-		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
-			"error": "haha... nice try",
-		})
-	}
+		// Prevent shortening of the domain itself to avoid infinite redirect loops.
+		if !helpers.RemoveDomainError(body.URL, cfg.Domain) {
+			return apierror.New(fiber.StatusBadRequest, apierror.CodeDomainNotAllowed, "URL must not point at this service")
+		}
 
-	// Enforce HTTPS for the provided URL.
-	body.URL = helpers.EnforceHTTP(body.URL)
+		// Enforce HTTPS for the provided URL.
+		body.URL = helpers.EnforceHTTP(body.URL)
+
+		// Generate a short identifier: Use a custom short ID if provided, else generate a random one.
+		var id, shortType string
+		if body.CustomShort == "" {
+			id = uuid.New().String()[:6] // Generate a 6-character random ID.
+			shortType = "generated"
+		} else {
+			id = body.CustomShort
+			shortType = "custom"
+		}
 
-	// Generate a short identifier: Use a custom short ID if provided, else generate a random one.
-	var id string
-	if body.CustomShort == "" {
-		id = uuid.New().String()[:6] // Generate a 6-character random ID.
-	} else {
-		id = body.CustomShort
-	}
+		// Check for collisions in the database for the short ID.
+		if reservedShorts[id] {
+			// A short named after a fixed route would be shadowed by that route.
+			return apierror.New(fiber.StatusConflict, apierror.CodeShortTaken, "URL short is reserved")
+		}
+		r := database.Client(0) // Use Redis database 0 for URL storage.
+		val, _ = r.Get(database.Ctx, id).Result()
+		if val != "" {
+			// If the short ID is already in use, return a 409 Conflict error.
+			return apierror.New(fiber.StatusConflict, apierror.CodeShortTaken, "URL short already in use")
+		}
 
-	// Check for collisions in the database for the short ID.
-	r := database.CreateClient(0) // Use Redis database 0 for URL storage.
-	defer r.Close()
-	val, _ = r.Get(database.Ctx, id).Result()
-	if val != "" {
-		// If the short ID is already in use, return a conflict error.
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"error": "URL short already in use",
-		})
-	}
+		// Set the expiry for the shortened URL in hours, defaulting to the configured expiry if not provided.
+		if body.Expiry == 0 {
+			body.Expiry = time.Duration(cfg.DefaultExpiry) / time.Hour
+		}
 
-	// Set the expiry for the shortened URL, defaulting to 24 hours if not provided.
-	if body.Expiry == 0 {
-		body.Expiry = 24
-	}
+		// Store the shortened URL in the database with its expiry time.
+		err = r.Set(database.Ctx, id, body.URL, body.Expiry*3600*time.Second).Err()
+		if err != nil {
+			logging.FromCtx(c).Error("cannot store short", "short", id, "error", err)
+			return apierror.New(fiber.StatusServiceUnavailable, apierror.CodeDatabaseUnavailable, "Unable to connect to server")
+		}
+		metrics.LinksCreatedTotal.WithLabelValues(shortType).Inc()
+		logging.FromCtx(c).Info("short created", "short", id, "type", shortType)
+
+		// Construct the response object with URL details and rate limit information.
+		resp := response{
+			URL:             body.URL,
+			CustomShort:     "",
+			Expiry:          body.Expiry,
+			XRateRemaining:  cfg.APIQuota,                                 // Default remaining quota.
+			XRateLimitReset: time.Duration(cfg.QuotaWindow) / time.Minute, // Default reset time in minutes.
+		}
+		r2.Decr(database.Ctx, c.IP()) // Decrease the rate limit quota.
+		val, _ = r2.Get(database.Ctx, c.IP()).Result()
+		resp.XRateRemaining, _ = strconv.Atoi(val)
+		ttl, _ := r2.TTL(database.Ctx, c.IP()).Result()
+		resp.XRateLimitReset = ttl / time.Nanosecond / time.Minute
 
-	// Store the shortened URL in the database with its expiry time.
-	err = r.Set(database.Ctx, id, body.URL, body.Expiry*3600*time.Second).Err()
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Unable to connect to server",
-		})
-	}
+		// Include the shortened URL in the response.
+		resp.CustomShort = cfg.Domain + "/" + id
 
-	// Construct the response object with URL details and rate limit information.
-	resp := response{
-		URL:             body.URL,
-		CustomShort:     "",
-		Expiry:          body.Expiry,
-		XRateRemaining:  10, // Default remaining quota.
-		XRateLimitReset: 30, // Default reset time in minutes.
+		// Return the response as JSON with a 200 OK status.
+		return c.Status(fiber.StatusOK).JSON(resp)
 	}
-	r2.Decr(database.Ctx, c.IP()) // Decrease the rate limit quota.
-	val, _ = r2.Get(database.Ctx, c.IP()).Result()
-	resp.XRateRemaining, _ = strconv.Atoi(val)
-	ttl, _ := r2.TTL(database.Ctx, c.IP()).Result()
-	resp.XRateLimitReset = ttl / time.Nanosecond / time.Minute
-
-	// Include the shortened URL in the response.
-	resp.CustomShort = os.Getenv("DOMAIN") + "/" + id
-
-	// Return the response as JSON with a 200 OK status.
-	return c.Status(fiber.StatusOK).JSON(resp)
 }
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"crypto/sha256" // Tokens are hashed so comparison time does not depend on their length
+	"crypto/subtle" // Constant-time token comparison
+	"encoding/csv"  // Audit export format
+	"encoding/json" // Audit export format
+	"errors"        // Used to build error responses
+	"net/http"      // HTTP status codes
+	"strconv"       // Used to parse the limit query parameter
+	"strings"       // Used to parse the Authorization header
+	"time"          // Used to parse time filters
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bounds of the limit query parameter on admin listing endpoints
+const (
+	defaultAdminLimit = 100
+	maxAdminLimit     = 1000
+)
+
+// adminAuth is middleware admitting only requests carrying the admin token as a bearer token
+func (app *Config) adminAuth() gin.HandlerFunc {
+	want := sha256.Sum256([]byte(app.AdminToken))
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		got := sha256.Sum256([]byte(token))
+		if !ok || subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="admin"`)
+			app.errorJSON(c, errors.New("unauthorized"), http.StatusUnauthorized)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// adminLimit reads the limit query parameter, writing a 422 and returning false when it is invalid
+func (app *Config) adminLimit(c *gin.Context) (int, bool) {
+	raw := c.Query("limit")
+	if raw == "" {
+		return defaultAdminLimit, true
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 || limit > maxAdminLimit {
+		app.writeRequestError(c, invalidField("limit", "range", "must be a number between 1 and "+strconv.Itoa(maxAdminLimit)))
+		return 0, false
+	}
+	return limit, true
+}
+
+// webhookDeliveries handles the admin endpoint listing recent webhook deliveries,
+// optionally filtered by status and event type
+func (app *Config) webhookDeliveries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, ok := app.adminLimit(c)
+		if !ok {
+			return
+		}
+		app.writeJSON(c, http.StatusOK, app.Webhooks.Deliveries(c.Query("status"), c.Query("event"), limit))
+	}
+}
+
+// auditFilter reads the audit filter from the query parameters: from and to (RFC 3339),
+// action, outcome, phoneNumber or email, ip and verificationId. It writes a 422 and returns
+// false when one is invalid
+func (app *Config) auditFilter(c *gin.Context) (AuditFilter, bool) {
+	filter := AuditFilter{
+		Action:         c.Query("action"),
+		Outcome:        c.Query("outcome"),
+		IP:             c.Query("ip"),
+		VerificationID: c.Query("verificationId"),
+	}
+	for field, t := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		raw := c.Query(field)
+		if raw == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			app.writeRequestError(c, invalidField(field, "datetime", "must be an RFC 3339 time, e.g. 2024-01-01T00:00:00Z"))
+			return filter, false
+		}
+		*t = parsed
+	}
+
+	// Recipients are recorded hashed, so search by the hash of the normalized recipient
+	switch {
+	case c.Query("phoneNumber") != "":
+		e164, _, err := app.Phones.Parse(c.Query("phoneNumber"))
+		if err != nil {
+			app.writeRequestError(c, invalidField("phoneNumber", "phone", "is not a valid phone number"))
+			return filter, false
+		}
+		filter.RecipientHash = app.Audit.HashRecipient(e164)
+	case c.Query("email") != "":
+		filter.RecipientHash = app.Audit.HashRecipient(normalizeEmail(c.Query("email")))
+	}
+	return filter, true
+}
+
+// auditRecords handles the admin endpoint listing the newest audit records matching a filter
+func (app *Config) auditRecords() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, ok := app.adminLimit(c)
+		if !ok {
+			return
+		}
+		filter, ok := app.auditFilter(c)
+		if !ok {
+			return
+		}
+		records, err := app.Audit.Query(c.Request.Context(), filter, limit)
+		if err != nil {
+			app.logger(c).Error("cannot read audit trail", "error", err)
+			app.errorJSON(c, errors.New("internal server error"), http.StatusInternalServerError)
+			return
+		}
+		app.writeJSON(c, http.StatusOK, records)
+	}
+}
+
+// auditExport handles the admin endpoint streaming every audit record matching a filter,
+// oldest first, as CSV or JSON lines
+func (app *Config) auditExport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := c.DefaultQuery("format", "csv")
+		if format != "csv" && format != "jsonl" {
+			app.writeRequestError(c, invalidField("format", "oneof", "must be one of: csv jsonl"))
+			return
+		}
+		filter, ok := app.auditFilter(c)
+		if !ok {
+			return
+		}
+
+		name := "audit-" + time.Now().UTC().Format("20060102T150405Z") + "." + format
+		c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+		var write func(AuditRecord) error
+		if format == "csv" {
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			w := csv.NewWriter(c.Writer)
+			defer w.Flush()
+			_ = w.Write([]string{"time", "action", "recipient_hash", "ip", "channel", "outcome", "verification_id", "detail"})
+			write = func(r AuditRecord) error {
+				return w.Write([]string{r.Time.Format(time.RFC3339Nano), r.Action, r.RecipientHash, r.IP, r.Channel, r.Outcome, r.VerificationID, r.Detail})
+			}
+		} else {
+			c.Header("Content-Type", "application/x-ndjson")
+			enc := json.NewEncoder(c.Writer)
+			write = func(r AuditRecord) error { return enc.Encode(r) }
+		}
+
+		// The status is already sent, so a failure part way can only be logged
+		c.Status(http.StatusOK)
+		if err := app.Audit.Export(c.Request.Context(), filter, write); err != nil {
+			app.logger(c).Error("audit export failed", "error", err)
+		}
+	}
+}
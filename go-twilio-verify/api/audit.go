@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"  // Carries request-scoped values to the audit sink
+	"errors"   // Used to combine the errors of several sinks
+	"log/slog" // Default audit sink writes structured log lines
+	"net/http" // Used to derive outcomes from response statuses
+	"time"     // Event timestamps
+
+	"github.com/gin-gonic/gin" // Gin framework for HTTP handling
+)
+
+// Audit actions recorded by the service
+const (
+	AuditOTPSend   = "otp.send"   // A POST /otp call
+	AuditOTPVerify = "otp.verify" // A POST /verifyOTP call
+	AuditOTPLocked = "otp.locked" // Verification was locked after too many failed attempts
+
+	AuditTOTPEnroll  = "totp.enroll"  // A POST /admin/totp/enroll call
+	AuditTOTPConfirm = "totp.confirm" // A POST /totp/confirm call
+	AuditTOTPVerify  = "totp.verify"  // A POST /totp/verify call
+
+	AuditRecoveryGenerate = "recovery.generate" // A POST /admin/recovery-codes call
+	AuditRecoveryVerify   = "recovery.verify"   // A POST /recovery-codes/verify call
+
+	AuditEmailSend   = "email.send"   // A POST /email-verifications call
+	AuditEmailVerify = "email.verify" // A POST /email-verifications/confirm or /email-verifications/callback call
+)
+
+// Audit outcomes
+const (
+	AuditOutcomeSent                = "sent"                 // A code was sent
+	AuditOutcomeApproved            = "approved"             // The code was correct
+	AuditOutcomeInvalidCode         = "invalid_code"         // The code was wrong
+	AuditOutcomeInvalidRequest      = "invalid_request"      // The request was malformed or the recipient refused
+	AuditOutcomeThrottled           = "throttled"            // A rate limit or lockout refused the call
+	AuditOutcomeNotFound            = "not_found"            // No such verification
+	AuditOutcomeNotPending          = "not_pending"          // The verification was already approved, canceled or expired
+	AuditOutcomeProviderError       = "provider_error"       // The provider failed or refused the call
+	AuditOutcomeProviderUnavailable = "provider_unavailable" // The provider could not be reached
+	AuditOutcomeLocked              = "locked"               // The recipient or session was locked out
+	AuditOutcomeEnrollmentStarted   = "enrollment_started"   // A new authenticator secret awaits confirmation
+	AuditOutcomeEnrolled            = "enrolled"             // The authenticator was confirmed
+	AuditOutcomeAlreadyEnrolled     = "already_enrolled"     // The user already has a confirmed authenticator
+	AuditOutcomeReplayed            = "replayed"             // The code was correct but had already been used
+	AuditOutcomeGenerated           = "generated"            // A new set of recovery codes replaced the old one
+	AuditOutcomeError               = "error"                // The service failed
+)
+
+// AuditEvent is a security-relevant event worth keeping a record of
+type AuditEvent struct {
+	Time      time.Time // When the event happened
+	Action    string    // What happened, one of the Audit* constants
+	Recipient string    // Phone number, email address or user ID concerned
+	IP        string    // Client IP of the request that triggered the event
+	Detail    string    // Action-specific detail, e.g. the lockout scope
+
+	Channel        string // Delivery channel, when known
+	Outcome        string // How the call ended, one of the AuditOutcome* constants
+	VerificationID string // Provider SID of the verification, when known
+}
+
+// AuditLog records audit events
+type AuditLog interface {
+	Record(ctx context.Context, event AuditEvent) error
+}
+
+// LogAuditLog is an AuditLog writing each event as a structured log line.
+// Recipients are masked so the log does not hold full phone numbers or addresses
+type LogAuditLog struct {
+	Logger *slog.Logger // Destination; slog.Default() when nil
+}
+
+// Record writes the event to the log
+func (l LogAuditLog) Record(ctx context.Context, event AuditEvent) error {
+	logger := l.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.LogAttrs(ctx, slog.LevelWarn, "audit",
+		slog.String("action", event.Action),
+		slog.Time("event_time", event.Time),
+		slog.String("recipient", maskRecipient(event.Recipient)),
+		slog.String("ip", event.IP),
+		slog.String("detail", event.Detail),
+	)
+	return nil
+}
+
+// AuditLogs records every event to each of its sinks
+type AuditLogs []AuditLog
+
+// Record passes the event to every sink, even when one fails
+func (l AuditLogs) Record(ctx context.Context, event AuditEvent) error {
+	var errs []error
+	for _, log := range l {
+		errs = append(errs, log.Record(ctx, event))
+	}
+	return errors.Join(errs...)
+}
+
+// maskRecipient keeps only the last four characters of a phone number or email address
+func maskRecipient(to string) string {
+	if len(to) <= 4 {
+		return "****"
+	}
+	return "****" + to[len(to)-4:]
+}
+
+// recordCall records a send or verify call in the audit trail and the metrics once it has
+// been answered; deferred by the handlers. An outcome the handler did not set is derived from
+// the response status
+func (app *Config) recordCall(c *gin.Context, event *AuditEvent) {
+	event.Time = time.Now().UTC()
+	event.IP = c.ClientIP()
+	if event.Outcome == "" {
+		event.Outcome = auditOutcome(event.Action, c.Writer.Status())
+	}
+	if app.Metrics != nil {
+		app.Metrics.observeCall(*event)
+	}
+	if app.Audit == nil {
+		return
+	}
+	if err := app.Audit.Record(context.WithoutCancel(c.Request.Context()), *event); err != nil {
+		app.logger(c).Error("cannot record audit event", "action", event.Action, "error", err)
+	}
+}
+
+// auditOutcome derives the outcome of a call from its response status
+func auditOutcome(action string, status int) string {
+	switch {
+	case status == http.StatusAccepted && (action == AuditOTPSend || action == AuditEmailSend):
+		return AuditOutcomeSent
+	case status == http.StatusAccepted:
+		return AuditOutcomeApproved
+	case status == http.StatusBadRequest, status == http.StatusUnprocessableEntity:
+		return AuditOutcomeInvalidRequest
+	case status == http.StatusNotFound:
+		return AuditOutcomeNotFound
+	case status == http.StatusConflict, status == http.StatusGone:
+		return AuditOutcomeNotPending
+	case status == http.StatusTooManyRequests:
+		return AuditOutcomeThrottled
+	case status == http.StatusServiceUnavailable:
+		return AuditOutcomeProviderUnavailable
+	default:
+		return AuditOutcomeError
+	}
+}
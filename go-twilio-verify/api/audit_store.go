@@ -0,0 +1,306 @@
+package api
+
+import (
+	"bufio"         // Used to read audit files line by line
+	"context"       // Carries request-scoped values to the store
+	"crypto/hmac"   // Keyed hashing of recipients
+	"crypto/sha256" // Hash function used by the HMAC
+	"encoding/hex"  // Encoding of recipient hashes
+	"encoding/json" // Serialization of audit records
+	"errors"        // Used to validate options
+	"fmt"           // Used to annotate file errors
+	"io"            // Used to close the store
+	"os"            // Audit files
+	"path/filepath" // Used to name and find audit files
+	"slices"        // Used to order audit files
+	"strings"       // Used to parse audit file names
+	"sync"          // Serializes appends
+	"time"          // Record timestamps and retention
+)
+
+// Defaults applied by NewFileAuditStore
+const (
+	defaultAuditRetention = 365 * 24 * time.Hour
+	auditFileLayout       = "audit-2006-01-02.jsonl"
+)
+
+// AuditRecord is one entry of the audit trail as stored and exported. The recipient is
+// only kept as a keyed hash, so the trail can be searched by phone number or email
+// address without holding them
+type AuditRecord struct {
+	Time           time.Time `json:"time"`                     // When the call was handled
+	Action         string    `json:"action"`                   // One of the Audit* action constants
+	RecipientHash  string    `json:"recipientHash,omitempty"`  // HMAC-SHA256 of the recipient, when known
+	IP             string    `json:"ip"`                       // Client IP
+	Channel        string    `json:"channel,omitempty"`        // Delivery channel, when known
+	Outcome        string    `json:"outcome"`                  // One of the AuditOutcome* constants
+	VerificationID string    `json:"verificationId,omitempty"` // Provider SID of the verification, when known
+	Detail         string    `json:"detail,omitempty"`         // Action-specific detail, e.g. the lockout scope
+}
+
+// AuditFilter selects audit records; zero fields match everything
+type AuditFilter struct {
+	From           time.Time // Records at or after this time
+	To             time.Time // Records before this time
+	Action         string
+	Outcome        string
+	RecipientHash  string
+	IP             string
+	VerificationID string
+}
+
+// match reports whether the record passes the filter
+func (f AuditFilter) match(r AuditRecord) bool {
+	return (f.From.IsZero() || !r.Time.Before(f.From)) &&
+		(f.To.IsZero() || r.Time.Before(f.To)) &&
+		(f.Action == "" || r.Action == f.Action) &&
+		(f.Outcome == "" || r.Outcome == f.Outcome) &&
+		(f.RecipientHash == "" || r.RecipientHash == f.RecipientHash) &&
+		(f.IP == "" || r.IP == f.IP) &&
+		(f.VerificationID == "" || r.VerificationID == f.VerificationID)
+}
+
+// AuditStore is an append-only store of audit records. Records cannot be changed or
+// deleted other than by the store's retention policy
+type AuditStore interface {
+	// Append adds a record
+	Append(ctx context.Context, record AuditRecord) error
+
+	// Scan calls fn with every record matching filter, oldest first, stopping at the first error
+	Scan(ctx context.Context, filter AuditFilter, fn func(AuditRecord) error) error
+}
+
+// FileAuditStore is an AuditStore writing one JSON line per record to a file per UTC day.
+// Files are only ever appended to; a day's file is deleted as a whole once all of its
+// records are older than the retention period
+type FileAuditStore struct {
+	dir       string
+	retention time.Duration
+
+	mu   sync.Mutex
+	day  string   // Name of the open file
+	file *os.File // File of the current day
+}
+
+// NewFileAuditStore creates a FileAuditStore in dir, keeping records for retention (default
+// one year), and deletes the files that are already past it
+func NewFileAuditStore(dir string, retention time.Duration) (*FileAuditStore, error) {
+	if retention == 0 {
+		retention = defaultAuditRetention
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	s := &FileAuditStore{dir: dir, retention: retention}
+	return s, s.prune(time.Now().UTC())
+}
+
+// Append writes the record to the file of its day and syncs it to disk
+func (s *FileAuditStore) Append(_ context.Context, record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Start a new file each day and drop the files that fell out of retention
+	day := record.Time.UTC().Format(auditFileLayout)
+	if s.file == nil || s.day != day {
+		if s.file != nil {
+			_ = s.file.Close()
+		}
+		s.file, err = os.OpenFile(filepath.Join(s.dir, day), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			s.file = nil
+			return err
+		}
+		s.day = day
+		if err := s.prune(record.Time.UTC()); err != nil {
+			return err
+		}
+	}
+	if _, err := s.file.Write(line); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Scan reads the files covering the filter's time range, oldest first
+func (s *FileAuditStore) Scan(ctx context.Context, filter AuditFilter, fn func(AuditRecord) error) error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if (!filter.From.IsZero() && f.day.Add(24*time.Hour).Before(filter.From)) || (!filter.To.IsZero() && !f.day.Before(filter.To)) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := scanAuditFile(f.path, filter, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the open file
+func (s *FileAuditStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// auditFile is a day file of the store
+type auditFile struct {
+	path string
+	day  time.Time // Start of the day the file covers, in UTC
+}
+
+// files lists the store's files, oldest first
+func (s *FileAuditStore) files() ([]auditFile, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []auditFile
+	for _, e := range entries {
+		day, err := time.Parse(auditFileLayout, e.Name())
+		if err != nil || e.IsDir() {
+			continue // Not an audit file
+		}
+		files = append(files, auditFile{path: filepath.Join(s.dir, e.Name()), day: day})
+	}
+	slices.SortFunc(files, func(a, b auditFile) int { return a.day.Compare(b.day) })
+	return files, nil
+}
+
+// prune deletes the files whose last record is older than the retention period
+func (s *FileAuditStore) prune(now time.Time) error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	cutoff := now.Add(-s.retention)
+	for _, f := range files {
+		if f.day.Add(24 * time.Hour).After(cutoff) {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanAuditFile calls fn with every matching record of the file
+func scanAuditFile(path string, filter AuditFilter, fn func(AuditRecord) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil // Pruned meanwhile
+		}
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		var r AuditRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		if !filter.match(r) {
+			continue
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// AuditTrail is an AuditLog keeping every event in an AuditStore, with the recipient
+// replaced by a keyed hash
+type AuditTrail struct {
+	store   AuditStore
+	hashKey []byte
+}
+
+// NewAuditTrail creates an AuditTrail. hashKey keys the recipient hashes; it must stay the
+// same for the trail to remain searchable by recipient
+func NewAuditTrail(store AuditStore, hashKey []byte) (*AuditTrail, error) {
+	if len(hashKey) == 0 {
+		return nil, errors.New("audit trail: hash key is required")
+	}
+	return &AuditTrail{store: store, hashKey: hashKey}, nil
+}
+
+// Record appends the event to the store
+func (t *AuditTrail) Record(ctx context.Context, event AuditEvent) error {
+	return t.store.Append(ctx, AuditRecord{
+		Time:           event.Time,
+		Action:         event.Action,
+		RecipientHash:  t.HashRecipient(event.Recipient),
+		IP:             event.IP,
+		Channel:        event.Channel,
+		Outcome:        event.Outcome,
+		VerificationID: event.VerificationID,
+		Detail:         event.Detail,
+	})
+}
+
+// HashRecipient returns the hash a recipient is recorded under, or "" for no recipient
+func (t *AuditTrail) HashRecipient(to string) string {
+	if to == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, t.hashKey)
+	mac.Write([]byte(to))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Query returns the newest records matching filter, newest first
+func (t *AuditTrail) Query(ctx context.Context, filter AuditFilter, limit int) ([]AuditRecord, error) {
+	var recent []AuditRecord
+	err := t.store.Scan(ctx, filter, func(r AuditRecord) error {
+		if len(recent) == limit {
+			recent = recent[1:]
+		}
+		recent = append(recent, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(recent)
+	if recent == nil {
+		recent = []AuditRecord{}
+	}
+	return recent, nil
+}
+
+// Close closes the store when it holds open files
+func (t *AuditTrail) Close() error {
+	if closer, ok := t.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Export calls fn with every record matching filter, oldest first
+func (t *AuditTrail) Export(ctx context.Context, filter AuditFilter, fn func(AuditRecord) error) error {
+	return t.store.Scan(ctx, filter, fn)
+}
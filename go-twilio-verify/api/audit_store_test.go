@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"       // Test contexts
+	"os"            // Audit files
+	"path/filepath" // Audit file paths
+	"slices"        // Used to compare results
+	"strings"       // Used to search file contents
+	"testing"       // Go test framework
+	"time"          // Record times and retention
+)
+
+// newTestAuditTrail returns an AuditTrail writing files into a temporary directory
+func newTestAuditTrail(t *testing.T, retention time.Duration) (*AuditTrail, string) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := NewFileAuditStore(dir, retention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trail, err := NewAuditTrail(store, []byte("audit-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { trail.Close() })
+	return trail, dir
+}
+
+func TestAuditTrailHashesRecipients(t *testing.T) {
+	ctx := context.Background()
+	trail, dir := newTestAuditTrail(t, 0)
+
+	// Computed independently: HMAC-SHA256 of the number keyed with "audit-key"
+	const want = "6d96245e2c4bf2731fa132d81664f90d01674e01c4b5127e5bdda62f76c12b70"
+	if got := trail.HashRecipient(testPhone); got != want {
+		t.Errorf("hash: got %s, want %s", got, want)
+	}
+	if got := trail.HashRecipient(""); got != "" {
+		t.Errorf("hash of no recipient: got %q, want none", got)
+	}
+
+	now := time.Now().UTC()
+	events := []AuditEvent{
+		{Time: now, Action: AuditOTPSend, Recipient: testPhone, IP: "192.0.2.1", Outcome: AuditOutcomeSent},
+		{Time: now, Action: AuditOTPVerify, IP: "192.0.2.1", Outcome: AuditOutcomeNotFound},
+	}
+	for _, e := range events {
+		if err := trail.Record(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, now.Format(auditFileLayout)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), testPhone) || strings.Contains(string(raw), "5552671") {
+		t.Errorf("audit file holds the recipient:\n%s", raw)
+	}
+	records, err := trail.Query(ctx, AuditFilter{RecipientHash: want}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Action != AuditOTPSend {
+		t.Errorf("records of the recipient: got %+v, want the send", records)
+	}
+}
+
+func TestAuditTrailQuery(t *testing.T) {
+	ctx := context.Background()
+	trail, _ := newTestAuditTrail(t, 0)
+
+	start := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	ids := []string{"VE1", "VE2", "VE3", "VE4"}
+	for i, id := range ids {
+		outcome := AuditOutcomeSent
+		if i%2 == 1 {
+			outcome = AuditOutcomeProviderError
+		}
+		err := trail.Record(ctx, AuditEvent{
+			Time:           start.Add(time.Duration(i) * time.Minute),
+			Action:         AuditOTPSend,
+			IP:             "192.0.2.1",
+			Outcome:        outcome,
+			VerificationID: id,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		limit  int
+		want   []string // Verification IDs, newest first
+	}{
+		{name: "everything", limit: 10, want: []string{"VE4", "VE3", "VE2", "VE1"}},
+		{name: "newest within the limit", limit: 2, want: []string{"VE4", "VE3"}},
+		{name: "by outcome", filter: AuditFilter{Outcome: AuditOutcomeSent}, limit: 10, want: []string{"VE3", "VE1"}},
+		{name: "time range", filter: AuditFilter{From: start.Add(time.Minute), To: start.Add(3 * time.Minute)}, limit: 10, want: []string{"VE3", "VE2"}},
+		{name: "by verification", filter: AuditFilter{VerificationID: "VE2"}, limit: 10, want: []string{"VE2"}},
+		{name: "no match", filter: AuditFilter{IP: "192.0.2.2"}, limit: 10, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := trail.Query(ctx, tt.filter, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, r := range records {
+				got = append(got, r.VerificationID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileAuditStorePrune(t *testing.T) {
+	dir := t.TempDir()
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	day := func(daysAgo int) string {
+		return today.AddDate(0, 0, -daysAgo).Format(auditFileLayout)
+	}
+	for _, name := range []string{day(0), day(1), day(2), day(3), day(10), "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A file goes once its whole day is older than the retention period
+	store, err := NewFileAuditStore(dir, 48*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	want := []string{day(2), day(1), day(0), "notes.txt"}
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("files after pruning: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"crypto/hmac"     // Twilio request signatures
+	"crypto/sha1"     // Hash function Twilio signs requests with
+	"encoding/base64" // Encoding of the X-Twilio-Signature header
+	"errors"          // Used to report invalid settings and requests
+	"net/http"        // HTTP status codes
+	"net/url"         // Used to build and parse callback URLs
+	"slices"          // Used to classify delivery statuses
+	"sort"            // Signed parameters are sorted by name
+
+	"go-twilio-verify/data" // Verification statuses
+
+	"github.com/gin-gonic/gin"
+)
+
+// Delivery statuses Twilio reports for messages and calls that never reached the recipient
+var failedDeliveryStatuses = []string{"failed", "undelivered", "busy", "no-answer", "canceled"}
+
+// Delivery statuses after which Twilio reports nothing further
+var finalDeliveryStatuses = append([]string{"delivered", "read", "completed"}, failedDeliveryStatuses...)
+
+// deliveryFailed reports whether a delivery status means the code never reached the recipient
+func deliveryFailed(status string) bool { return slices.Contains(failedDeliveryStatuses, status) }
+
+// deliveryFinal reports whether no further status will follow
+func deliveryFinal(status string) bool { return slices.Contains(finalDeliveryStatuses, status) }
+
+// StatusCallbacks authenticates the delivery status callbacks Twilio posts for sent codes
+type StatusCallbacks struct {
+	url       string // Public URL of the callback route, as Twilio requests it
+	authToken string // Twilio auth token the requests are signed with
+}
+
+// NewStatusCallbacks creates the status callback settings. publicURL is the address of the
+// /twilio/status route as seen from the internet, e.g. https://verify.example.com/twilio/status
+func NewStatusCallbacks(publicURL, authToken string) (*StatusCallbacks, error) {
+	u, err := url.Parse(publicURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, errors.New("status callbacks: the public URL must be an absolute http(s) URL")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, errors.New("status callbacks: the public URL must not have a query or fragment")
+	}
+	if authToken == "" {
+		return nil, errors.New("status callbacks: the Twilio auth token is required")
+	}
+	return &StatusCallbacks{url: publicURL, authToken: authToken}, nil
+}
+
+// URL returns the callback URL reporting delivery of the code sent for a verification
+func (s *StatusCallbacks) URL(verificationID string) string {
+	return s.url + "?" + url.Values{"verificationId": {verificationID}}.Encode()
+}
+
+// valid reports whether a callback request carries a valid X-Twilio-Signature. The form must already be parsed
+func (s *StatusCallbacks) valid(r *http.Request) bool {
+	signed := s.url
+	if r.URL.RawQuery != "" {
+		signed += "?" + r.URL.RawQuery
+	}
+	return ValidTwilioSignature(s.authToken, signed, r.PostForm, r.Header.Get("X-Twilio-Signature"))
+}
+
+// ValidTwilioSignature checks a Twilio webhook signature: the Base64 HMAC-SHA1, keyed with the
+// auth token, of the full request URL followed by every POST parameter name and value sorted by name
+func ValidTwilioSignature(authToken, fullURL string, params url.Values, signature string) bool {
+	got, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(got) == 0 {
+		return false
+	}
+
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(fullURL))
+	for _, k := range keys {
+		values := slices.Clone(params[k])
+		sort.Strings(values)
+		for _, v := range values {
+			mac.Write([]byte(k + v))
+		}
+	}
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// statusCallback handles the delivery status callbacks Twilio posts for sent messages and calls
+func (app *Config) statusCallback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := c.Request.ParseForm(); err != nil {
+			app.errorJSON(c, errors.New("malformed callback"))
+			return
+		}
+		if !app.StatusCallbacks.valid(c.Request) {
+			app.logger(c).Warn("rejected status callback with an invalid signature")
+			app.errorJSON(c, errors.New("invalid signature"), http.StatusForbidden)
+			return
+		}
+
+		status := c.Request.PostForm.Get("MessageStatus")
+		if status == "" {
+			status = c.Request.PostForm.Get("CallStatus")
+		}
+		id := c.Query("verificationId")
+		if id == "" || status == "" {
+			app.errorJSON(c, errors.New("missing verification ID or status"))
+			return
+		}
+
+		ctx := c.Request.Context()
+		sess, err := app.Sessions.Get(ctx, id)
+		if errors.Is(err, ErrSessionNotFound) {
+			// The verification is past its retention; there is nothing left to update
+			c.Status(http.StatusNoContent)
+			return
+		}
+		if err != nil {
+			app.logger(c).Error("cannot load verification", "verification_id", id, "error", err)
+			app.errorJSON(c, errors.New("internal server error"), http.StatusInternalServerError)
+			return
+		}
+
+		errorCode := c.Request.PostForm.Get("ErrorCode")
+		if err := app.Sessions.RecordDelivery(ctx, sess, status, errorCode); err != nil {
+			app.logger(c).Error("cannot record delivery status", "verification_id", id, "error", err)
+			app.errorJSON(c, errors.New("internal server error"), http.StatusInternalServerError)
+			return
+		}
+
+		// Let the user ask for a new code straight away when this one never arrived
+		if deliveryFailed(status) {
+			app.logger(c).Warn("OTP not delivered", "verification_id", id, "status", status, "error_code", errorCode)
+			if app.Throttle != nil && sess.Status == data.StatusPending {
+				if err := app.Throttle.ClearCooldown(ctx, sess.To); err != nil {
+					app.logger(c).Error("cannot lift resend cooldown", "verification_id", id, "error", err)
+				}
+			}
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"context"           // Test contexts
+	"crypto/hmac"       // Signs test callbacks
+	"crypto/sha1"       // Hash function Twilio signs requests with
+	"encoding/base64"   // Encoding of the X-Twilio-Signature header
+	"io"                // Discarded logs
+	"log/slog"          // Test logger
+	"net/http"          // HTTP methods and status codes
+	"net/http/httptest" // In-process requests against the router
+	"net/url"           // Callback forms
+	"sort"              // Signed parameters are sorted by name
+	"strings"           // Request bodies
+	"testing"           // Go test framework
+
+	"go-twilio-verify/data" // Verification statuses
+
+	"github.com/gin-gonic/gin" // Gin framework for HTTP handling
+)
+
+func TestValidTwilioSignature(t *testing.T) {
+	// The example from Twilio's webhook security documentation
+	const (
+		authToken = "12345"
+		fullURL   = "https://mycompany.com/myapp.php?foo=1&bar=2"
+		signature = "0/KCTR6DLpKmkAf8muzZqo1nDgQ="
+	)
+	params := url.Values{
+		"CallSid": {"CA1234567890ABCDE"},
+		"Caller":  {"+12349013030"},
+		"Digits":  {"1234"},
+		"From":    {"+12349013030"},
+		"To":      {"+18005551212"},
+	}
+	changed := url.Values{}
+	for k, v := range params {
+		changed[k] = v
+	}
+	changed.Set("Digits", "1235")
+
+	tests := []struct {
+		name      string
+		authToken string
+		url       string
+		params    url.Values
+		signature string
+		want      bool
+	}{
+		{name: "valid", authToken: authToken, url: fullURL, params: params, signature: signature, want: true},
+		{name: "wrong auth token", authToken: "54321", url: fullURL, params: params, signature: signature},
+		{name: "other URL", authToken: authToken, url: "https://mycompany.com/myapp.php?foo=1&bar=3", params: params, signature: signature},
+		{name: "changed parameter", authToken: authToken, url: fullURL, params: changed, signature: signature},
+		{name: "missing signature", authToken: authToken, url: fullURL, params: params},
+		{name: "not base64", authToken: authToken, url: fullURL, params: params, signature: "not a signature!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidTwilioSignature(tt.authToken, tt.url, tt.params, tt.signature); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// signCallback computes the X-Twilio-Signature of a callback the way Twilio does
+func signCallback(authToken, fullURL string, form url.Values) string {
+	keys := make([]string, 0, len(form))
+	for k := range form {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(fullURL))
+	for _, k := range keys {
+		mac.Write([]byte(k + form.Get(k)))
+	}
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestStatusCallback(t *testing.T) {
+	const (
+		publicURL = "https://verify.acme.test/twilio/status"
+		authToken = "twilio-auth-token"
+	)
+	tests := []struct {
+		name         string
+		form         url.Values
+		signedURL    string // URL the signature is computed over; the callback URL when empty
+		signature    string // Sent instead of a computed signature when set
+		wantStatus   int
+		wantDelivery string // Delivery status recorded on the verification
+		wantResend   int    // Status of an immediate resend to the recipient
+	}{
+		{
+			name:         "delivered",
+			form:         url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"delivered"}},
+			wantStatus:   http.StatusNoContent,
+			wantDelivery: "delivered",
+			wantResend:   http.StatusTooManyRequests,
+		},
+		{
+			name:         "undelivered lifts the cooldown",
+			form:         url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"undelivered"}, "ErrorCode": {"30003"}},
+			wantStatus:   http.StatusNoContent,
+			wantDelivery: "undelivered",
+			wantResend:   http.StatusAccepted,
+		},
+		{
+			name:       "wrong signature",
+			form:       url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"undelivered"}},
+			signature:  "AAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+			wantStatus: http.StatusForbidden,
+			wantResend: http.StatusTooManyRequests,
+		},
+		{
+			name:       "signed for another verification",
+			form:       url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"undelivered"}},
+			signedURL:  publicURL + "?verificationId=VEother",
+			wantStatus: http.StatusForbidden,
+			wantResend: http.StatusTooManyRequests,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			callbacks, err := NewStatusCallbacks(publicURL, authToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gin.SetMode(gin.TestMode)
+			fake := NewFakeVerifier()
+			app := &Config{
+				Router:          gin.New(),
+				Logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+				Verifier:        fake,
+				Throttle:        NewThrottler(NewMemoryStore(), ThrottleOptions{}),
+				StatusCallbacks: callbacks,
+			}
+			app.Routes()
+			id, _ := sendCode(t, app, fake)
+
+			callbackURL := callbacks.URL(id)
+			signature := tt.signature
+			if signature == "" {
+				signedURL := tt.signedURL
+				if signedURL == "" {
+					signedURL = callbackURL
+				}
+				signature = signCallback(authToken, signedURL, tt.form)
+			}
+			u, err := url.Parse(callbackURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodPost, u.RequestURI(), strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("X-Twilio-Signature", signature)
+			rec := httptest.NewRecorder()
+			app.Router.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("callback: got %d %s, want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+
+			sess, err := app.Sessions.Get(context.Background(), id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if sess.Delivery != nil {
+				got = sess.Delivery.Status
+			}
+			if got != tt.wantDelivery {
+				t.Errorf("delivery: got %q, want %q", got, tt.wantDelivery)
+			}
+			if status, resp := call(t, app, http.MethodPost, "/otp", data.OTPData{PhoneNumber: testPhone}); status != tt.wantResend {
+				t.Errorf("resend: got %d %q, want %d", status, resp.Message, tt.wantResend)
+			}
+		})
+	}
+}
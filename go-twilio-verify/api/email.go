@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"      // Bounds the SMTP exchange
+	"crypto/rand"  // Used to generate message IDs
+	"crypto/tls"   // STARTTLS
+	"encoding/hex" // Encoding of message IDs
+	"errors"       // Used to reject unsafe header values
+	"fmt"          // Used to format the message
+	"mime"         // Encoding of non-ASCII subjects
+	"net"          // Used to split the SMTP address
+	"net/smtp"     // SMTP client from the standard library
+	"strings"      // Used to build the message
+	"time"         // Used for the Date header
+)
+
+// SMTPOptions configures an SMTPSender
+type SMTPOptions struct {
+	Addr     string // SMTP server address, host:port
+	Username string // Optional; PLAIN authentication is used when set
+	Password string // Password for Username
+	From     string // Sender address, e.g. "Acme <no-reply@acme.test>"
+	Subject  string // Subject line of every message (default "Your verification code")
+}
+
+// Mail is a plain-text email message
+type Mail struct {
+	To      string // Recipient address
+	Subject string // Subject line
+	Body    string // Plain-text body; lines may end in \n
+}
+
+// MailSender delivers email. Email verification links are sent through it, so any mail
+// gateway can be plugged in
+type MailSender interface {
+	// SendMail delivers the message and returns the provider's message ID
+	SendMail(ctx context.Context, mail Mail) (string, error)
+}
+
+// SMTPSender is a MessageSender and MailSender that delivers messages as plain-text email
+// over SMTP. STARTTLS is used whenever the server offers it
+type SMTPSender struct {
+	opts SMTPOptions
+	auth smtp.Auth
+}
+
+// NewSMTPSender creates an email sender for the server in opts
+func NewSMTPSender(opts SMTPOptions) (*SMTPSender, error) {
+	host, _, err := net.SplitHostPort(opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("smtp sender: %w", err)
+	}
+	if opts.From == "" {
+		return nil, errors.New("smtp sender: from address is required")
+	}
+	if opts.Subject == "" {
+		opts.Subject = "Your verification code"
+	}
+
+	s := &SMTPSender{opts: opts}
+	if opts.Username != "" {
+		s.auth = smtp.PlainAuth("", opts.Username, opts.Password, host)
+	}
+	return s, nil
+}
+
+// SendMessage emails body to the address with the configured subject and returns the
+// generated Message-ID
+func (s *SMTPSender) SendMessage(ctx context.Context, to, body string) (string, error) {
+	return s.SendMail(ctx, Mail{To: to, Subject: s.opts.Subject, Body: body})
+}
+
+// SendMail emails the message and returns the generated Message-ID
+func (s *SMTPSender) SendMail(ctx context.Context, mail Mail) (string, error) {
+	// Addresses and the subject end up in headers, so refuse anything that could inject new ones
+	if strings.ContainsAny(mail.To, "\r\n") {
+		return "", errors.New("smtp sender: invalid recipient address")
+	}
+	if strings.ContainsAny(mail.Subject, "\r\n") {
+		return "", errors.New("smtp sender: invalid subject")
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	messageID := fmt.Sprintf("<%s@go-twilio-verify>", hex.EncodeToString(id))
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", s.opts.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", mail.To)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", mail.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: %s\r\n", messageID)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(mail.Body, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	if err := s.send(ctx, envelopeAddress(s.opts.From), mail.To, []byte(msg.String())); err != nil {
+		return "", err
+	}
+	return messageID, nil
+}
+
+// Ping connects to the server and greets it without sending anything
+func (s *SMTPSender) Ping(ctx context.Context) error {
+	return s.session(ctx, func(c *smtp.Client) error {
+		if err := c.Noop(); err != nil {
+			return err
+		}
+		return c.Quit()
+	})
+}
+
+// send delivers msg like smtp.SendMail, but dials with ctx and abandons the exchange when ctx ends
+func (s *SMTPSender) send(ctx context.Context, from, to string, msg []byte) error {
+	return s.session(ctx, func(c *smtp.Client) error {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			host, _, _ := net.SplitHostPort(s.opts.Addr)
+			if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+				return err
+			}
+		}
+		if s.auth != nil {
+			if ok, _ := c.Extension("AUTH"); !ok {
+				return errors.New("smtp sender: server does not support authentication")
+			}
+			if err := c.Auth(s.auth); err != nil {
+				return err
+			}
+		}
+		if err := c.Mail(from); err != nil {
+			return err
+		}
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+		w, err := c.Data()
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(msg); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		return c.Quit()
+	})
+}
+
+// session dials the server with ctx and runs fn on the connection, abandoning the exchange when ctx ends
+func (s *SMTPSender) session(ctx context.Context, fn func(*smtp.Client) error) (err error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.opts.Addr)
+	if err != nil {
+		return err
+	}
+	// Unblock any pending read or write once ctx ends, and report ctx's error instead of the I/O error
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer func() {
+		if !stop() && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+
+	host, _, _ := net.SplitHostPort(s.opts.Addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	return fn(c)
+}
+
+// envelopeAddress extracts the bare address from a From value such as "Acme <no-reply@acme.test>"
+func envelopeAddress(from string) string {
+	if i := strings.LastIndex(from, "<"); i >= 0 {
+		return strings.TrimSuffix(from[i+1:], ">")
+	}
+	return from
+}
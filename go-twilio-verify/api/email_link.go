@@ -0,0 +1,550 @@
+package api
+
+import (
+	"context"                    // Carries deadlines to the store and mail sender
+	"crypto/hmac"                // Link signatures and code hashes
+	"crypto/rand"                // Code generation
+	"crypto/sha256"              // Hash function of the HMAC
+	"encoding/base64"            // Encoding of link signatures
+	"encoding/hex"               // Encoding of code hashes
+	"encoding/json"              // Serialization of pending verifications
+	"errors"                     // Used to define email link errors
+	"fmt"                        // Used to format codes
+	htmltemplate "html/template" // Confirmation page
+	"math/big"                   // Used to draw a uniformly random code
+	"net/http"                   // HTTP status codes
+	"net/url"                    // Used to build the link and redirects
+	"strconv"                    // Expiry times in links
+	"strings"                    // Used to split links and compose messages
+	"text/template"              // Message wording
+	"time"                       // Link expiry
+
+	"go-twilio-verify/data" // Request and response models
+
+	"github.com/gin-gonic/gin" // Gin framework for HTTP handling
+)
+
+// Defaults applied by NewEmailLinks for zero EmailLinkOptions fields
+const (
+	defaultEmailLinkTTL         = 15 * time.Minute
+	defaultEmailLinkSubject     = "Confirm your email address"
+	defaultEmailLinkMaxAttempts = 5
+)
+
+// emailCodeLength is the number of digits of the code sent along with the link
+const emailCodeLength = 6
+
+// defaultEmailLinkMessage is the body of the verification email
+const defaultEmailLinkMessage = `Confirm your email address{{if .AppName}} for {{.AppName}}{{end}} by opening this link:
+
+{{.Link}}
+
+Or enter this code: {{.Code}}
+
+The link and the code work once and expire in {{.TTLMinutes}} minutes. If you did not ask for this, you can ignore this email.`
+
+// emailLinkPage asks the user to confirm the address. Opening the link only shows it, so
+// mail scanners fetching the link do not use it up; the button posts the token to the
+// confirm route next to the callback route
+var emailLinkPage = htmltemplate.Must(htmltemplate.New("email_link_page").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>{{.Subject}}</title></head>
+<body>
+<form method="post" action="confirm">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Confirm my email address</button>
+</form>
+</body>
+</html>
+`))
+
+// Email link errors
+var (
+	ErrEmailLinkInvalid = errors.New("invalid verification link")
+	ErrEmailLinkExpired = errors.New("verification link or code expired")
+	ErrEmailLinkUsed    = errors.New("verification link or code already used")
+)
+
+// EmailLinkOptions configures EmailLinks
+type EmailLinkOptions struct {
+	CallbackURL string        // Public URL of the callback route the link points to, e.g. https://verify.example.com/email-verifications/callback
+	RedirectURL string        // Page the link sends the browser to with the outcome; the outcome is answered as JSON when empty
+	TTL         time.Duration // How long the link and code stay valid (default 15m)
+	MaxAttempts int           // Wrong codes allowed before the verification is discarded (default 5)
+	Subject     string        // Subject line of the email (default "Confirm your email address")
+	AppName     string        // Name shown in the email
+}
+
+// emailLinkRecord is the state kept for a pending verification. Only a hash of the code is stored
+type emailLinkRecord struct {
+	Email     string    `json:"email"`      // Address the link was sent to
+	CodeHash  string    `json:"code_hash"`  // HMAC-SHA256 of the verification ID and code
+	ExpiresAt time.Time `json:"expires_at"` // When the link and code stop working
+}
+
+// EmailLinks verifies email addresses by sending a signed link, and a code for users who
+// read their email on another device. Either works once and only until it expires; using
+// one marks the address as verified
+type EmailLinks struct {
+	store   Store
+	mail    MailSender
+	key     []byte
+	opts    EmailLinkOptions
+	message *template.Template
+}
+
+// NewEmailLinks creates an EmailLinks sending through mail and keeping verifications in
+// store. key signs the links and hashes the codes; it must be shared by every instance
+func NewEmailLinks(store Store, mail MailSender, key []byte, opts EmailLinkOptions) (*EmailLinks, error) {
+	if mail == nil {
+		return nil, errors.New("email links: mail sender is required")
+	}
+	if len(key) == 0 {
+		return nil, errors.New("email links: key is required")
+	}
+	if u, err := url.Parse(opts.CallbackURL); err != nil || !u.IsAbs() {
+		return nil, errors.New("email links: callback URL must be absolute")
+	}
+	if u, err := url.Parse(opts.RedirectURL); opts.RedirectURL != "" && (err != nil || !u.IsAbs()) {
+		return nil, errors.New("email links: redirect URL must be absolute")
+	}
+	if opts.TTL == 0 {
+		opts.TTL = defaultEmailLinkTTL
+	}
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = defaultEmailLinkMaxAttempts
+	}
+	if opts.Subject == "" {
+		opts.Subject = defaultEmailLinkSubject
+	}
+	message := template.Must(template.New("email_link").Parse(defaultEmailLinkMessage))
+	return &EmailLinks{store: store, mail: mail, key: key, opts: opts, message: message}, nil
+}
+
+// Send emails a new link and code to the address
+func (l *EmailLinks) Send(ctx context.Context, email string) (*data.EmailVerification, error) {
+	id, err := newVerificationSID()
+	if err != nil {
+		return nil, err
+	}
+	code, err := newEmailCode()
+	if err != nil {
+		return nil, err
+	}
+	expires := time.Now().UTC().Add(l.opts.TTL).Truncate(time.Second)
+
+	raw, err := json.Marshal(emailLinkRecord{Email: email, CodeHash: l.hash(id, code), ExpiresAt: expires})
+	if err != nil {
+		return nil, err
+	}
+	if err := l.store.Set(ctx, emailLinkKey(id), string(raw), l.opts.TTL); err != nil {
+		return nil, err
+	}
+
+	var body strings.Builder
+	err = l.message.Execute(&body, map[string]any{
+		"AppName":    l.opts.AppName,
+		"Link":       l.link(id, expires),
+		"Code":       code,
+		"TTLMinutes": int(l.opts.TTL.Round(time.Minute) / time.Minute),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := l.mail.SendMail(ctx, Mail{To: email, Subject: l.opts.Subject, Body: body.String()}); err != nil {
+		// Nothing was delivered, so nothing should be left to verify
+		_ = l.store.Delete(context.WithoutCancel(ctx), emailLinkKey(id))
+		return nil, err
+	}
+	return &data.EmailVerification{ID: id, Email: email, ExpiresAt: expires}, nil
+}
+
+// VerifyLink accepts the token of a link. It returns ErrEmailLinkInvalid for a token it
+// did not sign, ErrEmailLinkExpired once the link expired and ErrEmailLinkUsed when the
+// link or its code was already used
+func (l *EmailLinks) VerifyLink(ctx context.Context, token string) (*data.EmailApproval, error) {
+	id, expires, err := l.parseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(expires) {
+		return nil, ErrEmailLinkExpired
+	}
+	rec, err := l.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return l.consume(ctx, id, rec)
+}
+
+// VerifyCode accepts the code sent with the link. Every wrong code uses up an attempt; once
+// the attempts are exhausted the verification is discarded and a new email must be requested
+func (l *EmailLinks) VerifyCode(ctx context.Context, id, code string) (*data.EmailApproval, error) {
+	rec, err := l.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts, err := l.store.Incr(ctx, emailLinkAttemptsKey(id), l.opts.TTL)
+	if err != nil {
+		return nil, err
+	}
+	if attempts > int64(l.opts.MaxAttempts) {
+		_ = l.store.Delete(ctx, emailLinkKey(id), emailLinkAttemptsKey(id))
+		return nil, ErrInvalidCode
+	}
+	if !hmac.Equal([]byte(rec.CodeHash), []byte(l.hash(id, code))) {
+		return nil, ErrInvalidCode
+	}
+	return l.consume(ctx, id, rec)
+}
+
+// Verified returns when the address was last verified, or ErrNotFound if it never was
+func (l *EmailLinks) Verified(ctx context.Context, email string) (time.Time, error) {
+	raw, err := l.store.Get(ctx, emailVerifiedKey(email))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, raw)
+}
+
+// Ping checks the mail sender
+func (l *EmailLinks) Ping(ctx context.Context) error {
+	return ping(ctx, l.mail)
+}
+
+// consume uses up the verification and marks its address as verified
+func (l *EmailLinks) consume(ctx context.Context, id string, rec *emailLinkRecord) (*data.EmailApproval, error) {
+	// The counter makes concurrent clicks on the same link race safely; it outlives the
+	// verification so a second click is told it was already used
+	uses, err := l.store.Incr(ctx, emailLinkUsedKey(id), time.Until(rec.ExpiresAt)+time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if uses > 1 {
+		return nil, ErrEmailLinkUsed
+	}
+
+	now := time.Now().UTC()
+	if err := l.store.Set(ctx, emailVerifiedKey(rec.Email), now.Format(time.RFC3339Nano), 0); err != nil {
+		return nil, err
+	}
+	if err := l.store.Delete(ctx, emailLinkKey(id), emailLinkAttemptsKey(id)); err != nil {
+		return nil, err
+	}
+	return &data.EmailApproval{VerificationID: id, Email: rec.Email, VerifiedAt: now}, nil
+}
+
+// load returns the pending verification, telling used ones apart from expired or unknown ones
+func (l *EmailLinks) load(ctx context.Context, id string) (*emailLinkRecord, error) {
+	raw, err := l.store.Get(ctx, emailLinkKey(id))
+	if errors.Is(err, ErrNotFound) {
+		if _, uerr := l.store.Get(ctx, emailLinkUsedKey(id)); uerr == nil {
+			return nil, ErrEmailLinkUsed
+		}
+		return nil, ErrEmailLinkExpired
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec emailLinkRecord
+	if err := json.Unmarshal([]byte(raw), &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// link returns the URL sent in the email: the callback URL with a token holding the
+// verification ID and expiry, signed with the key
+func (l *EmailLinks) link(id string, expires time.Time) string {
+	payload := id + "." + strconv.FormatInt(expires.Unix(), 10)
+	u, _ := url.Parse(l.opts.CallbackURL) // Checked by NewEmailLinks
+	q := u.Query()
+	q.Set("token", payload+"."+l.sign(payload))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// parseToken checks the signature of a link token and returns its verification ID and expiry
+func (l *EmailLinks) parseToken(token string) (string, time.Time, error) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return "", time.Time{}, ErrEmailLinkInvalid
+	}
+	payload, sig := token[:i], token[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(l.sign(payload))) {
+		return "", time.Time{}, ErrEmailLinkInvalid
+	}
+	id, exp, ok := strings.Cut(payload, ".")
+	if !ok {
+		return "", time.Time{}, ErrEmailLinkInvalid
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return "", time.Time{}, ErrEmailLinkInvalid
+	}
+	return id, time.Unix(unix, 0), nil
+}
+
+// sign returns the signature of a link payload
+func (l *EmailLinks) sign(payload string) string {
+	mac := hmac.New(sha256.New, l.key)
+	mac.Write([]byte("link:" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// hash returns the keyed hash a code is stored as, bound to its verification
+func (l *EmailLinks) hash(id, code string) string {
+	mac := hmac.New(sha256.New, l.key)
+	mac.Write([]byte("code:" + id + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newEmailCode returns a uniformly random code of emailCodeLength digits
+func newEmailCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", emailCodeLength, n.Int64()), nil
+}
+
+// Store keys used by EmailLinks
+func emailLinkKey(id string) string         { return "emaillink:" + id }
+func emailLinkAttemptsKey(id string) string { return "emaillink:attempts:" + id }
+func emailLinkUsedKey(id string) string     { return "emaillink:used:" + id }
+func emailVerifiedKey(email string) string  { return "emaillink:verified:" + strings.ToLower(email) }
+
+// normalizeEmail lowercases an address and trims the space around it, so one address is
+// always written the same way
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// sendEmailLink handles the API endpoint emailing a verification link and code
+func (app *Config) sendEmailLink() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), appTimeout)
+		defer cancel()
+
+		audit := AuditEvent{Action: AuditEmailSend, Channel: data.ChannelEmail}
+		defer app.recordCall(c, &audit)
+
+		var payload data.EmailLinkData
+		if err := app.validateBody(c, &payload); err != nil {
+			app.writeRequestError(c, err)
+			return
+		}
+		// Addresses differing only in case or surrounding space share limits, state and audit records
+		payload.Email = normalizeEmail(payload.Email)
+		audit.Recipient = payload.Email
+
+		// Emails count against the same cooldown and daily cap as codes
+		if app.Throttle != nil {
+			if err := app.Throttle.AllowSend(ctx, payload.Email); err != nil {
+				app.writeThrottleError(c, err)
+				return
+			}
+		}
+
+		verification, err := app.EmailLinks.Send(ctx, payload.Email)
+		if err != nil {
+			app.logger(c).Error("cannot send verification email", "error", err)
+			audit.Outcome = providerOutcome(err)
+			app.releaseSend(c, payload.Email, err)
+			app.writeProviderError(c, err)
+			return
+		}
+		audit.Outcome, audit.VerificationID = AuditOutcomeSent, verification.ID
+
+		app.publish(EventOTPSent, emailLinkSession(verification.ID, payload.Email), data.StatusPending, "")
+		app.writeJSON(c, http.StatusAccepted, verification)
+	}
+}
+
+// emailLinkCallback handles the link in the email by showing a page that asks the user to
+// confirm. It does not use the link up
+func (app *Config) emailLinkCallback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// The link must not end up in shared caches or be sent on to other sites, and the
+		// page must not be framed to trick users into confirming
+		c.Header("Cache-Control", "no-store")
+		c.Header("Referrer-Policy", "no-referrer")
+		c.Header("Content-Security-Policy", "default-src 'none'; form-action 'self'; frame-ancestors 'none'")
+
+		var page strings.Builder
+		err := emailLinkPage.Execute(&page, map[string]string{"Subject": app.EmailLinks.opts.Subject, "Token": c.Query("token")})
+		if err != nil {
+			app.logger(c).Error("cannot render email confirmation page", "error", err)
+			app.errorJSON(c, errors.New("internal server error"), http.StatusInternalServerError)
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(page.String()))
+	}
+}
+
+// emailLinkConfirm handles the confirmation posted from the page shown for the link. With
+// a redirect URL configured the browser is sent there with the outcome in the status query
+// parameter: verified, invalid, expired, used or error. Otherwise the outcome is answered
+// as JSON
+func (app *Config) emailLinkConfirm() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		audit := AuditEvent{Action: AuditEmailVerify, Channel: data.ChannelEmail}
+		defer app.recordCall(c, &audit)
+
+		c.Header("Cache-Control", "no-store")
+		c.Header("Referrer-Policy", "no-referrer")
+
+		redirect := app.EmailLinks.opts.RedirectURL
+		approval, err := app.EmailLinks.VerifyLink(c.Request.Context(), c.PostForm("token"))
+		if err != nil && redirect == "" {
+			audit.Outcome = app.writeEmailLinkError(c, err)
+			return
+		}
+		if err != nil {
+			audit.Outcome = emailLinkOutcome(err)
+			status := map[string]string{
+				AuditOutcomeInvalidRequest: "invalid",
+				AuditOutcomeNotPending:     "expired",
+				AuditOutcomeReplayed:       "used",
+			}[audit.Outcome]
+			if status == "" {
+				app.logger(c).Error("email verification failed", "error", err)
+				status = "error"
+			}
+			redirectWithStatus(c, redirect, status)
+			return
+		}
+		audit.Outcome, audit.Recipient, audit.VerificationID = AuditOutcomeApproved, approval.Email, approval.VerificationID
+
+		app.publish(EventOTPApproved, emailLinkSession(approval.VerificationID, approval.Email), data.StatusApproved, "")
+		if redirect != "" {
+			redirectWithStatus(c, redirect, "verified")
+			return
+		}
+		app.writeEmailApproval(c, approval)
+	}
+}
+
+// verifyEmailCode handles the API endpoint verifying an email address with the code from the email
+func (app *Config) verifyEmailCode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		audit := AuditEvent{Action: AuditEmailVerify, Channel: data.ChannelEmail}
+		defer app.recordCall(c, &audit)
+
+		var payload data.EmailCodeData
+		if err := app.validateBody(c, &payload); err != nil {
+			app.writeRequestError(c, err)
+			return
+		}
+		audit.VerificationID = payload.VerificationID
+
+		// Wrong codes count against the verification like wrong delivered codes
+		if app.Guard != nil {
+			if err := app.Guard.Allow(ctx, payload.VerificationID, payload.VerificationID, c.ClientIP()); err != nil {
+				app.writeThrottleError(c, err)
+				return
+			}
+		}
+
+		approval, err := app.EmailLinks.VerifyCode(ctx, payload.VerificationID, payload.Code)
+		if errors.Is(err, ErrInvalidCode) && app.Guard != nil {
+			if _, gerr := app.Guard.RecordFailure(ctx, payload.VerificationID, payload.VerificationID, c.ClientIP()); gerr != nil {
+				app.logger(c).Error("cannot record failed verification", "error", gerr)
+			}
+		}
+		if err != nil {
+			audit.Outcome = app.writeEmailLinkError(c, err)
+			return
+		}
+		audit.Outcome, audit.Recipient = AuditOutcomeApproved, approval.Email
+
+		app.publish(EventOTPApproved, emailLinkSession(approval.VerificationID, approval.Email), data.StatusApproved, "")
+		app.writeEmailApproval(c, approval)
+	}
+}
+
+// emailStatus handles the admin endpoint telling whether an email address was verified
+func (app *Config) emailStatus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		email := normalizeEmail(c.Query("email"))
+		if email == "" {
+			app.writeRequestError(c, invalidField("email", "required", "is required"))
+			return
+		}
+		status := data.EmailStatus{Email: email}
+		verifiedAt, err := app.EmailLinks.Verified(c.Request.Context(), email)
+		switch {
+		case errors.Is(err, ErrNotFound):
+		case err != nil:
+			app.logger(c).Error("cannot look up email verification", "error", err)
+			app.errorJSON(c, errors.New("internal server error"), http.StatusInternalServerError)
+			return
+		default:
+			status.Verified, status.VerifiedAt = true, &verifiedAt
+		}
+		app.writeJSON(c, http.StatusOK, status)
+	}
+}
+
+// writeEmailApproval responds to a verified email address with a token for it
+func (app *Config) writeEmailApproval(c *gin.Context, approval *data.EmailApproval) {
+	if app.Tokens != nil {
+		token, expires, err := app.Tokens.Issue(emailLinkSession(approval.VerificationID, approval.Email), approval.VerifiedAt)
+		if err != nil {
+			app.logger(c).Error("cannot issue token", "verification_id", approval.VerificationID, "error", err)
+			app.errorJSON(c, errors.New("internal server error"), http.StatusInternalServerError)
+			return
+		}
+		approval.Token, approval.TokenExpiresAt = token, &expires
+	}
+	app.writeJSON(c, http.StatusOK, approval)
+}
+
+// redirectWithStatus sends the browser to target with the status query parameter added
+func redirectWithStatus(c *gin.Context, target, status string) {
+	u, _ := url.Parse(target) // Checked by NewEmailLinks
+	q := u.Query()
+	q.Set("status", status)
+	u.RawQuery = q.Encode()
+	c.Redirect(http.StatusSeeOther, u.String())
+}
+
+// writeEmailLinkError responds to a failed email verification and returns its audit outcome
+func (app *Config) writeEmailLinkError(c *gin.Context, err error) string {
+	outcome := emailLinkOutcome(err)
+	switch outcome {
+	case AuditOutcomeInvalidCode, AuditOutcomeInvalidRequest:
+		app.errorJSON(c, err)
+	case AuditOutcomeReplayed:
+		app.errorJSON(c, err, http.StatusConflict)
+	case AuditOutcomeNotPending:
+		app.errorJSON(c, err, http.StatusGone)
+	default:
+		app.logger(c).Error("email verification failed", "error", err)
+		app.errorJSON(c, errors.New("internal server error"), http.StatusInternalServerError)
+	}
+	return outcome
+}
+
+// emailLinkOutcome returns the audit outcome of a failed email verification
+func emailLinkOutcome(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidCode):
+		return AuditOutcomeInvalidCode
+	case errors.Is(err, ErrEmailLinkInvalid):
+		return AuditOutcomeInvalidRequest
+	case errors.Is(err, ErrEmailLinkUsed):
+		return AuditOutcomeReplayed
+	case errors.Is(err, ErrEmailLinkExpired):
+		return AuditOutcomeNotPending
+	default:
+		return AuditOutcomeError
+	}
+}
+
+// emailLinkSession describes an email verification the way webhooks and tokens expect
+func emailLinkSession(id, email string) *Session {
+	return &Session{ID: id, Channel: data.ChannelEmail, To: email}
+}
@@ -0,0 +1,304 @@
+package api
+
+import (
+	"context"           // Test contexts
+	"encoding/json"     // Response bodies
+	"errors"            // Used to inspect verification errors
+	"io"                // Discarded logs
+	"log/slog"          // Test logger
+	"net"               // The fake mail server listens on loopback
+	"net/http"          // HTTP methods and status codes
+	"net/http/httptest" // In-process requests against the router
+	"net/textproto"     // SMTP line reading and dot-stuffed message bodies
+	"net/url"           // Used to read the token from the link
+	"regexp"            // Used to find the link and code in the message
+	"strings"           // SMTP command matching
+	"testing"           // Go test framework
+	"time"              // Link expiry
+
+	"go-twilio-verify/data" // Request and response models
+
+	"github.com/gin-gonic/gin" // Gin framework for HTTP handling
+)
+
+// smtpServer is a minimal SMTP server accepting every message, without TLS or authentication
+type smtpServer struct {
+	addr     string
+	messages chan string // Received message data, headers included
+}
+
+// newSMTPServer starts a smtpServer on a loopback port, closed when the test ends
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &smtpServer{addr: ln.Addr().String(), messages: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// serve answers one SMTP session
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	_ = tp.PrintfLine("220 localhost ready")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); cmd {
+		case "EHLO", "HELO", "MAIL", "RCPT", "NOOP", "RSET":
+			_ = tp.PrintfLine("250 OK")
+		case "DATA":
+			_ = tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			lines, err := tp.ReadDotLines()
+			if err != nil {
+				return
+			}
+			s.messages <- strings.Join(lines, "\n")
+			_ = tp.PrintfLine("250 OK queued")
+		case "QUIT":
+			_ = tp.PrintfLine("221 Bye")
+			return
+		default:
+			_ = tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+// receive returns the next message the server received
+func (s *smtpServer) receive(t *testing.T) string {
+	t.Helper()
+	select {
+	case msg := <-s.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+		return ""
+	}
+}
+
+var (
+	emailLinkPattern = regexp.MustCompile(`https://\S+`)
+	emailCodePattern = regexp.MustCompile(`enter this code: (\d{6})`)
+)
+
+// newTestEmailLinks returns EmailLinks sending through a fresh smtpServer
+func newTestEmailLinks(t *testing.T) (*EmailLinks, *smtpServer) {
+	t.Helper()
+	server := newSMTPServer(t)
+	sender, err := NewSMTPSender(SMTPOptions{Addr: server.addr, From: "Acme <no-reply@acme.test>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := NewEmailLinks(NewMemoryStore(), sender, []byte("test-key"), EmailLinkOptions{
+		CallbackURL: "https://verify.acme.test/email-verifications/callback",
+		AppName:     "Acme",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return links, server
+}
+
+// sendEmailLink sends a verification to the address and returns its ID, and the link token and code delivered
+func sendEmailLink(t *testing.T, links *EmailLinks, server *smtpServer, email string) (string, string, string) {
+	t.Helper()
+	verification, err := links.Send(context.Background(), email)
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	msg := server.receive(t)
+	if !strings.Contains(msg, "To: "+email) {
+		t.Errorf("message is not addressed to %s:\n%s", email, msg)
+	}
+
+	link := emailLinkPattern.FindString(msg)
+	u, err := url.Parse(link)
+	if link == "" || err != nil || u.Query().Get("token") == "" {
+		t.Fatalf("no link in message:\n%s", msg)
+	}
+	code := emailCodePattern.FindStringSubmatch(msg)
+	if code == nil {
+		t.Fatalf("no code in message:\n%s", msg)
+	}
+	return verification.ID, u.Query().Get("token"), code[1]
+}
+
+func TestEmailLinkIsSingleUse(t *testing.T) {
+	ctx := context.Background()
+	links, server := newTestEmailLinks(t)
+	_, token, _ := sendEmailLink(t, links, server, "ann@example.com")
+
+	approval, err := links.VerifyLink(ctx, token)
+	if err != nil {
+		t.Fatalf("first click: %v", err)
+	}
+	if approval.Email != "ann@example.com" {
+		t.Errorf("first click: got email %q, want ann@example.com", approval.Email)
+	}
+	if _, err := links.Verified(ctx, "ann@example.com"); err != nil {
+		t.Errorf("address not marked verified: %v", err)
+	}
+
+	if _, err := links.VerifyLink(ctx, token); !errors.Is(err, ErrEmailLinkUsed) {
+		t.Errorf("second click: got %v, want %v", err, ErrEmailLinkUsed)
+	}
+}
+
+func TestEmailCodeVerifiesOnce(t *testing.T) {
+	ctx := context.Background()
+	links, server := newTestEmailLinks(t)
+	id, token, code := sendEmailLink(t, links, server, "ann@example.com")
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	if _, err := links.VerifyCode(ctx, id, wrong); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("wrong code: got %v, want %v", err, ErrInvalidCode)
+	}
+	if _, err := links.VerifyCode(ctx, id, code); err != nil {
+		t.Fatalf("code: %v", err)
+	}
+
+	// The code and the link are used up together
+	if _, err := links.VerifyLink(ctx, token); !errors.Is(err, ErrEmailLinkUsed) {
+		t.Errorf("link after code: got %v, want %v", err, ErrEmailLinkUsed)
+	}
+}
+
+func TestEmailLinkRejectsExpiredToken(t *testing.T) {
+	ctx := context.Background()
+	links, server := newTestEmailLinks(t)
+	id, _, _ := sendEmailLink(t, links, server, "ann@example.com")
+
+	// A validly signed token whose expiry has passed
+	u, err := url.Parse(links.link(id, time.Now().Add(-time.Second)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := links.VerifyLink(ctx, u.Query().Get("token")); !errors.Is(err, ErrEmailLinkExpired) {
+		t.Errorf("expired token: got %v, want %v", err, ErrEmailLinkExpired)
+	}
+
+	// A token whose expiry was altered no longer matches its signature
+	token := strings.Replace(u.Query().Get("token"), ".", ".9", 1)
+	if _, err := links.VerifyLink(ctx, token); !errors.Is(err, ErrEmailLinkInvalid) {
+		t.Errorf("altered token: got %v, want %v", err, ErrEmailLinkInvalid)
+	}
+}
+
+// newTestEmailLinkApp returns the routes with email links sending through a fresh smtpServer
+func newTestEmailLinkApp(t *testing.T) (*Config, *smtpServer) {
+	t.Helper()
+	links, server := newTestEmailLinks(t)
+	gin.SetMode(gin.TestMode)
+	app := &Config{
+		Router:     gin.New(),
+		Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Verifier:   NewFakeVerifier(),
+		Throttle:   NewThrottler(NewMemoryStore(), ThrottleOptions{}),
+		EmailLinks: links,
+		AdminToken: "admin-token",
+	}
+	app.Routes()
+	return app, server
+}
+
+// serve sends a request to the routes and returns the response
+func serve(app *Config, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	app.Router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSendEmailLinkNormalizesAddress(t *testing.T) {
+	app, server := newTestEmailLinkApp(t)
+
+	body, _ := json.Marshal(data.EmailLinkData{Email: "Ann@Example.COM"})
+	rec := serve(app, httptest.NewRequest(http.MethodPost, "/email-verifications", strings.NewReader(string(body))))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("send: got %d %s, want %d", rec.Code, rec.Body, http.StatusAccepted)
+	}
+	if msg := server.receive(t); !strings.Contains(msg, "To: ann@example.com") {
+		t.Errorf("message is not addressed to ann@example.com:\n%s", msg)
+	}
+
+	// The same address written differently shares the cooldown
+	body, _ = json.Marshal(data.EmailLinkData{Email: "ann@example.com"})
+	rec = serve(app, httptest.NewRequest(http.MethodPost, "/email-verifications", strings.NewReader(string(body))))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("resend: got %d %s, want %d", rec.Code, rec.Body, http.StatusTooManyRequests)
+	}
+}
+
+func TestEmailLinkOpenedThenConfirmed(t *testing.T) {
+	app, server := newTestEmailLinkApp(t)
+	_, token, _ := sendEmailLink(t, app.EmailLinks, server, "ann@example.com")
+	link := "/email-verifications/callback?token=" + url.QueryEscape(token)
+
+	// Opening the link, as a mail scanner would, only shows the confirmation page
+	for i := 0; i < 2; i++ {
+		rec := serve(app, httptest.NewRequest(http.MethodGet, link, nil))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `value="`+token+`"`) {
+			t.Fatalf("open: got %d %s, want the confirmation page", rec.Code, rec.Body)
+		}
+	}
+	if _, err := app.EmailLinks.Verified(context.Background(), "ann@example.com"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("opening the link verified the address: %v", err)
+	}
+
+	confirm := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/email-verifications/confirm", strings.NewReader(url.Values{"token": {token}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return serve(app, req)
+	}
+	if rec := confirm(); rec.Code != http.StatusOK {
+		t.Fatalf("confirm: got %d %s, want %d", rec.Code, rec.Body, http.StatusOK)
+	}
+	if rec := confirm(); rec.Code != http.StatusConflict {
+		t.Errorf("second confirm: got %d %s, want %d", rec.Code, rec.Body, http.StatusConflict)
+	}
+}
+
+func TestEmailStatusIsAdminOnly(t *testing.T) {
+	app, server := newTestEmailLinkApp(t)
+	_, token, _ := sendEmailLink(t, app.EmailLinks, server, "ann@example.com")
+	if _, err := app.EmailLinks.VerifyLink(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := serve(app, httptest.NewRequest(http.MethodGet, "/email-verifications/status?email=ann@example.com", nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("public lookup: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := serve(app, httptest.NewRequest(http.MethodGet, "/admin/email-verifications/status?email=ann@example.com", nil)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("lookup without the admin token: got %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/email-verifications/status?email="+url.QueryEscape(" Ann@example.com"), nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rec := serve(app, req)
+	var resp struct {
+		Data data.EmailStatus `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || !resp.Data.Verified || resp.Data.Email != "ann@example.com" {
+		t.Errorf("admin lookup: got %d %s, want the address verified", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"     // Carries deadlines and cancellation to the stores
+	"errors"      // Used to tell expected results from failures
+	"log/slog"    // Used to report switching between stores
+	"sync/atomic" // Tracks whether the primary store is failing
+	"time"        // Used for key expiry
+)
+
+// FallbackStore is a Store that uses a primary store (usually Redis) and switches to a
+// fallback (usually a MemoryStore) whenever the primary fails, so throttling keeps
+// working while Redis is unavailable. While the primary is failing it is only retried
+// every fallbackRetryInterval, so requests do not each wait for it to time out.
+// State written to the fallback is not copied back
+type FallbackStore struct {
+	primary  Store
+	fallback Store
+	logger   *slog.Logger
+	degraded atomic.Bool  // Set while the primary is failing, so the switch is only logged once
+	retryAt  atomic.Int64 // Unix nanoseconds before which the failing primary is skipped
+}
+
+// fallbackRetryInterval is how long a failing primary store is skipped before it is tried again
+const fallbackRetryInterval = 10 * time.Second
+
+// NewFallbackStore creates a FallbackStore. logger may be nil
+func NewFallbackStore(primary, fallback Store, logger *slog.Logger) *FallbackStore {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &FallbackStore{primary: primary, fallback: fallback, logger: logger}
+}
+
+// Get returns the value stored under key, or ErrNotFound
+func (s *FallbackStore) Get(ctx context.Context, key string) (string, error) {
+	if s.skipPrimary() {
+		return s.fallback.Get(ctx, key)
+	}
+	v, err := s.primary.Get(ctx, key)
+	if s.failed(err) {
+		return s.fallback.Get(ctx, key)
+	}
+	return v, err
+}
+
+// Set stores value under key for ttl
+func (s *FallbackStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if s.skipPrimary() {
+		return s.fallback.Set(ctx, key, value, ttl)
+	}
+	err := s.primary.Set(ctx, key, value, ttl)
+	if s.failed(err) {
+		return s.fallback.Set(ctx, key, value, ttl)
+	}
+	return err
+}
+
+// SetNX stores value under key for ttl unless the key exists
+func (s *FallbackStore) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	if s.skipPrimary() {
+		return s.fallback.SetNX(ctx, key, value, ttl)
+	}
+	ok, err := s.primary.SetNX(ctx, key, value, ttl)
+	if s.failed(err) {
+		return s.fallback.SetNX(ctx, key, value, ttl)
+	}
+	return ok, err
+}
+
+// Incr increments the counter under key, creating it with ttl when missing
+func (s *FallbackStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	if s.skipPrimary() {
+		return s.fallback.Incr(ctx, key, ttl)
+	}
+	n, err := s.primary.Incr(ctx, key, ttl)
+	if s.failed(err) {
+		return s.fallback.Incr(ctx, key, ttl)
+	}
+	return n, err
+}
+
+// Decr decrements an existing counter under key, not below zero
+func (s *FallbackStore) Decr(ctx context.Context, key string) (int64, error) {
+	if s.skipPrimary() {
+		return s.fallback.Decr(ctx, key)
+	}
+	n, err := s.primary.Decr(ctx, key)
+	if s.failed(err) {
+		return s.fallback.Decr(ctx, key)
+	}
+	return n, err
+}
+
+// TTL returns how long the key has left to live, or ErrNotFound
+func (s *FallbackStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	if s.skipPrimary() {
+		return s.fallback.TTL(ctx, key)
+	}
+	ttl, err := s.primary.TTL(ctx, key)
+	if s.failed(err) {
+		return s.fallback.TTL(ctx, key)
+	}
+	return ttl, err
+}
+
+// Delete removes the keys
+func (s *FallbackStore) Delete(ctx context.Context, keys ...string) error {
+	if s.skipPrimary() {
+		return s.fallback.Delete(ctx, keys...)
+	}
+	err := s.primary.Delete(ctx, keys...)
+	if s.failed(err) {
+		return s.fallback.Delete(ctx, keys...)
+	}
+	return err
+}
+
+// Ping checks the primary store. The FallbackStore keeps working while it fails, so the
+// error only reports that state is not being shared
+func (s *FallbackStore) Ping(ctx context.Context) error {
+	return ping(ctx, s.primary)
+}
+
+// skipPrimary reports whether the primary failed recently enough to go straight to the fallback
+func (s *FallbackStore) skipPrimary() bool {
+	return s.degraded.Load() && time.Now().UnixNano() < s.retryAt.Load()
+}
+
+// failed reports whether err is a failure of the primary store, logging when the
+// primary starts and stops failing
+func (s *FallbackStore) failed(err error) bool {
+	if err == nil || errors.Is(err, ErrNotFound) {
+		if s.degraded.CompareAndSwap(true, false) {
+			s.logger.Info("primary store recovered")
+		}
+		return false
+	}
+	s.retryAt.Store(time.Now().Add(fallbackRetryInterval).UnixNano())
+	if s.degraded.CompareAndSwap(false, true) {
+		s.logger.Warn("primary store failing, using in-memory fallback", "error", err)
+	}
+	return true
+}
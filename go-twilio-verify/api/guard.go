@@ -0,0 +1,208 @@
+package api
+
+import (
+	"context" // Carries deadlines to the store
+	"errors"  // Used to inspect store errors
+	"strconv" // Used to read counters
+	"time"    // Used for windows and lockouts
+)
+
+// Defaults applied by NewVerifyGuard for zero GuardOptions fields
+const (
+	defaultMaxRecipientFailures = 10
+	defaultMaxSessionFailures   = 5
+	defaultFailureWindow        = time.Hour
+	defaultLockoutDuration      = 15 * time.Minute
+)
+
+// GuardOptions configures a VerifyGuard
+type GuardOptions struct {
+	MaxRecipientFailures int           // Checks per recipient within FailureWindow without a success before it is locked (default 10)
+	MaxSessionFailures   int           // Checks of one sent code without a success before that code is locked (default 5)
+	FailureWindow        time.Duration // Period failures are counted over (default 1h)
+	LockoutDuration      time.Duration // How long a locked recipient or session is refused (default 15m)
+}
+
+// VerifyGuard protects code checks against brute force, independently of whatever the
+// provider enforces. Checks are counted per recipient and per verification session (one
+// sent code) before they reach the provider, so parallel guesses cannot exceed the limits;
+// a successful check resets the counts. A session or recipient that reaches its limit is
+// locked for the lockout duration; a session's code can also be replaced by sending a new one.
+// Lockouts are audited
+type VerifyGuard struct {
+	store Store
+	audit AuditLog
+	opts  GuardOptions
+}
+
+// NewVerifyGuard creates a VerifyGuard keeping its counters in store and recording lockouts in audit
+func NewVerifyGuard(store Store, audit AuditLog, opts GuardOptions) *VerifyGuard {
+	if opts.MaxRecipientFailures == 0 {
+		opts.MaxRecipientFailures = defaultMaxRecipientFailures
+	}
+	if opts.MaxSessionFailures == 0 {
+		opts.MaxSessionFailures = defaultMaxSessionFailures
+	}
+	if opts.FailureWindow == 0 {
+		opts.FailureWindow = defaultFailureWindow
+	}
+	if opts.LockoutDuration == 0 {
+		opts.LockoutDuration = defaultLockoutDuration
+	}
+	return &VerifyGuard{store: store, audit: audit, opts: opts}
+}
+
+// Allow counts a check of session sid from ip and returns a *ThrottleError when the recipient
+// or the session is locked, or the check would exceed their limits, which locks them. The check
+// must be followed by RecordFailure or RecordSuccess once its outcome is known. sid is empty
+// for factors without a sent code, such as authenticator apps, which are limited per recipient only
+func (g *VerifyGuard) Allow(ctx context.Context, to, sid, ip string) error {
+	ttl, err := g.remaining(ctx, guardLockKey(to))
+	if err != nil {
+		return err
+	}
+	if ttl > 0 {
+		return &ThrottleError{Reason: "recipient_locked", RetryAfter: ttl}
+	}
+
+	if sid != "" {
+		ttl, err = g.remaining(ctx, guardSessionLockKey(sid))
+		if err != nil {
+			return err
+		}
+		if ttl > 0 {
+			return &ThrottleError{Reason: "session_locked", RetryAfter: ttl}
+		}
+	}
+
+	// The increments are atomic, so of concurrent checks only as many as the limits allow
+	// get through, even before the failures of the earlier ones are known
+	if sid != "" {
+		if err := g.count(ctx, g.opts.MaxSessionFailures, to, sid, ip, "session"); err != nil {
+			return err
+		}
+	}
+	return g.count(ctx, g.opts.MaxRecipientFailures, to, sid, ip, "recipient")
+}
+
+// RecordFailure locks the session sid or its recipient once a failed check used up the last
+// attempt of either. It returns the scope that was locked by this failure ("session" or
+// "recipient"), or "" when nothing was
+func (g *VerifyGuard) RecordFailure(ctx context.Context, to, sid, ip string) (string, error) {
+	locked := ""
+	if sid != "" {
+		n, err := g.counter(ctx, guardSessionFailKey(sid))
+		if err != nil {
+			return "", err
+		}
+		if n >= int64(g.opts.MaxSessionFailures) {
+			ok, err := g.lock(ctx, to, sid, ip, "session")
+			if err != nil {
+				return "", err
+			}
+			if ok {
+				locked = "session"
+			}
+		}
+	}
+
+	n, err := g.counter(ctx, guardFailKey(to))
+	if err != nil {
+		return locked, err
+	}
+	if n >= int64(g.opts.MaxRecipientFailures) {
+		ok, err := g.lock(ctx, to, sid, ip, "recipient")
+		if err != nil || !ok {
+			return locked, err
+		}
+		return "recipient", nil
+	}
+	return locked, nil
+}
+
+// RecordSuccess clears the failure counts of the recipient and session sid after a successful check
+func (g *VerifyGuard) RecordSuccess(ctx context.Context, to, sid string) error {
+	if sid == "" {
+		return g.store.Delete(ctx, guardFailKey(to))
+	}
+	return g.store.Delete(ctx, guardFailKey(to), guardSessionFailKey(sid))
+}
+
+// count counts a check against the fail counter of scope ("session" or "recipient"). Once
+// the count exceeds limit, which takes concurrent checks racing past RecordFailure, it locks
+// the scope like RecordFailure does and returns a *ThrottleError
+func (g *VerifyGuard) count(ctx context.Context, limit int, to, sid, ip, scope string) error {
+	failKey, _ := guardKeys(to, sid, scope)
+	n, err := g.store.Incr(ctx, failKey, g.opts.FailureWindow)
+	if err != nil {
+		return err
+	}
+	if n <= int64(limit) {
+		return nil
+	}
+	if _, err := g.lock(ctx, to, sid, ip, scope); err != nil {
+		return err
+	}
+	return &ThrottleError{Reason: scope + "_locked", RetryAfter: g.opts.LockoutDuration}
+}
+
+// counter returns the value of the counter under key, or 0 when it does not exist
+func (g *VerifyGuard) counter(ctx context.Context, key string) (int64, error) {
+	v, err := g.store.Get(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// lock locks scope ("session" or "recipient") for the lockout duration and audits the lockout.
+// It reports false, without auditing, when a concurrent check already set the lock
+func (g *VerifyGuard) lock(ctx context.Context, to, sid, ip, scope string) (bool, error) {
+	failKey, lockKey := guardKeys(to, sid, scope)
+	ok, err := g.store.SetNX(ctx, lockKey, "1", g.opts.LockoutDuration)
+	if err != nil || !ok {
+		return false, err
+	}
+	// The lockout replaces the count; checks start afresh once it expires
+	if err := g.store.Delete(ctx, failKey); err != nil {
+		return true, err
+	}
+	if g.audit == nil {
+		return true, nil
+	}
+	return true, g.audit.Record(ctx, AuditEvent{
+		Time:           time.Now().UTC(),
+		Action:         AuditOTPLocked,
+		Outcome:        AuditOutcomeLocked,
+		Recipient:      to,
+		IP:             ip,
+		Detail:         scope,
+		VerificationID: sid,
+	})
+}
+
+// remaining returns how long the key has left to live, or 0 when it does not exist
+func (g *VerifyGuard) remaining(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := g.store.TTL(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return 0, nil
+	}
+	return ttl, err
+}
+
+// guardKeys returns the fail counter and lock keys of scope ("session" or "recipient")
+func guardKeys(to, sid, scope string) (string, string) {
+	if scope == "session" {
+		return guardSessionFailKey(sid), guardSessionLockKey(sid)
+	}
+	return guardFailKey(to), guardLockKey(to)
+}
+
+// Store keys used by VerifyGuard. The fail counters count every check since the last success
+func guardFailKey(to string) string         { return "guard:fail:" + to }
+func guardLockKey(to string) string         { return "guard:lock:" + to }
+func guardSessionFailKey(sid string) string { return "guard:fail:session:" + sid }
+func guardSessionLockKey(sid string) string { return "guard:lock:session:" + sid }
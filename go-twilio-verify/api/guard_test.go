@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context" // Test contexts
+	"errors"  // Used to inspect guard errors
+	"slices"  // Used to compare the audited lockouts
+	"strconv" // Session IDs
+	"sync"    // Guards the recorded events
+	"testing" // Go test framework
+	"time"    // Lockout durations
+)
+
+// recordingAuditLog is an AuditLog keeping every event
+type recordingAuditLog struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (l *recordingAuditLog) Record(_ context.Context, event AuditEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+	return nil
+}
+
+// lockouts returns the scope of every audited lockout, in order
+func (l *recordingAuditLog) lockouts() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var scopes []string
+	for _, e := range l.events {
+		if e.Action == AuditOTPLocked {
+			scopes = append(scopes, e.Detail)
+		}
+	}
+	return scopes
+}
+
+func TestVerifyGuard(t *testing.T) {
+	const lockout = 10 * time.Minute
+	tests := []struct {
+		name string
+		// steps are run in order against testPhone: "fail" and "pass" are checks with that
+		// outcome, "race" is a check whose outcome is not yet known and "new" sends a new code
+		steps        []string
+		lockout      time.Duration // Overrides the lockout duration when set
+		wait         time.Duration // Time to let pass before the last step
+		wantReason   string        // Reason the last check is refused with; empty when it is allowed
+		wantLockouts []string      // Scopes of the audited lockouts
+	}{
+		{name: "under the session limit", steps: []string{"fail", "fail"}},
+		{
+			name:         "last attempt is checked",
+			steps:        []string{"fail", "fail", "fail"},
+			wantLockouts: []string{"session"},
+		},
+		{
+			name:         "session locked",
+			steps:        []string{"fail", "fail", "fail", "fail"},
+			wantReason:   "session_locked",
+			wantLockouts: []string{"session"},
+		},
+		{
+			name:         "new code after a session lock",
+			steps:        []string{"fail", "fail", "fail", "new", "fail"},
+			wantLockouts: []string{"session"},
+		},
+		{
+			name:         "session lock expires",
+			steps:        []string{"fail", "fail", "fail", "fail"},
+			lockout:      time.Millisecond,
+			wait:         5 * time.Millisecond,
+			wantLockouts: []string{"session"},
+		},
+		{
+			name:         "recipient locked across codes",
+			steps:        []string{"fail", "fail", "new", "fail", "fail", "new", "fail", "fail", "new", "fail"},
+			wantReason:   "recipient_locked",
+			wantLockouts: []string{"recipient"},
+		},
+		{
+			name:  "success clears the counts",
+			steps: []string{"fail", "fail", "pass", "fail", "fail"},
+		},
+		{
+			name:         "concurrent checks past the limit",
+			steps:        []string{"race", "race", "race", "race"},
+			wantReason:   "session_locked",
+			wantLockouts: []string{"session"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			audit := &recordingAuditLog{}
+			opts := GuardOptions{MaxSessionFailures: 3, MaxRecipientFailures: 6, FailureWindow: time.Hour, LockoutDuration: lockout}
+			if tt.lockout != 0 {
+				opts.LockoutDuration = tt.lockout
+			}
+			g := NewVerifyGuard(NewMemoryStore(), audit, opts)
+
+			session := 1
+			var err error
+			for i, step := range tt.steps {
+				if i == len(tt.steps)-1 {
+					time.Sleep(tt.wait)
+				}
+				sid := "VE" + strconv.Itoa(session)
+				switch step {
+				case "new":
+					session++
+					continue
+				case "race":
+					err = g.Allow(ctx, testPhone, sid, "192.0.2.1")
+					continue
+				}
+				if err = g.Allow(ctx, testPhone, sid, "192.0.2.1"); err != nil {
+					continue
+				}
+				if step == "pass" {
+					err = g.RecordSuccess(ctx, testPhone, sid)
+				} else {
+					_, err = g.RecordFailure(ctx, testPhone, sid, "192.0.2.1")
+				}
+				if err != nil {
+					t.Fatalf("step %d: %v", i+1, err)
+				}
+			}
+
+			var terr *ThrottleError
+			switch {
+			case tt.wantReason == "" && err != nil:
+				t.Errorf("last check: got %v, want it allowed", err)
+			case tt.wantReason != "" && !errors.As(err, &terr):
+				t.Errorf("last check: got %v, want %s", err, tt.wantReason)
+			case tt.wantReason != "" && (terr.Reason != tt.wantReason || terr.RetryAfter <= 0 || terr.RetryAfter > lockout):
+				t.Errorf("last check: got %s for %s, want %s for at most %s", terr.Reason, terr.RetryAfter, tt.wantReason, lockout)
+			}
+			if got := audit.lockouts(); !slices.Equal(got, tt.wantLockouts) {
+				t.Errorf("audited lockouts: got %v, want %v", got, tt.wantLockouts)
+			}
+		})
+	}
+}
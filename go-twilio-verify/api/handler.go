@@ -2,7 +2,8 @@ package api
 
 import (
 	"context"  // Provides functionality to handle deadlines, cancellations, and other context-aware tasks
-	"fmt"      // Used for formatted I/O
+	"errors"   // Used to recognise rejected codes
+	"fmt"      // Used to format error messages
 	"net/http" // Provides HTTP client and server implementations
 	"time"     // Used to manage timeouts and intervals
 
@@ -11,69 +12,356 @@ import (
 	"github.com/gin-gonic/gin" // Importing the Gin web framework
 )
 
-// appTimeout defines the maximum duration allowed for an operation before timing out
+// appTimeout defines the maximum duration allowed for an operation before timing out,
+// including every provider attempt and retry
 const appTimeout = time.Second * 10
 
 // sendSMS handles the API endpoint for sending OTPs via SMS
 func (app *Config) sendSMS() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Create a context with a timeout to ensure operations complete within a defined period
-		_, cancel := context.WithTimeout(context.Background(), appTimeout)
+		// Bound the request with a timeout; provider calls also stop when the client disconnects
+		ctx, cancel := context.WithTimeout(c.Request.Context(), appTimeout)
 		defer cancel() // Ensure context resources are released
 
+		// Record the call in the audit trail and metrics once it is answered
+		audit := AuditEvent{Action: AuditOTPSend}
+		defer app.recordCall(c, &audit)
+
 		// Variable to hold the incoming request payload
 		var payload data.OTPData
 
-		// Validate the request body and bind it to the payload
-		app.validateBody(c, &payload)
+		// Validate the request body and bind it to the payload; invalid input never reaches the provider
+		if err := app.validateBody(c, &payload); err != nil {
+			app.writeRequestError(c, err)
+			return
+		}
 
 		// Create a new OTPData object using the validated payload
 		newData := data.OTPData{
 			PhoneNumber: payload.PhoneNumber,
+			Email:       payload.Email,
+			Channel:     payload.Channel,
+			Locale:      payload.Locale,
+		}
+
+		// Only deliver over the channels this deployment has enabled
+		channel := newData.DeliveryChannel()
+		audit.Recipient, audit.Channel = newData.Recipient(), channel
+		if !app.channelEnabled(channel) {
+			app.writeRequestError(c, invalidField("channel", "enabled", "is not enabled on this deployment"))
+			return
+		}
+
+		// Normalize the phone number to E.164 before it is throttled, stored or sent
+		phone, ok := app.normalizePhone(c, "phoneNumber", &newData)
+		if !ok {
+			return
+		}
+		audit.Recipient = newData.Recipient()
+
+		// Enforce destination lists, resend cooldown and daily caps before anything is sent
+		if app.Throttle != nil {
+			if channel != data.ChannelEmail {
+				if err := app.Throttle.AllowDestination(newData.PhoneNumber); err != nil {
+					app.writeRequestError(c, invalidField("phoneNumber", "destination", "this country is not served"))
+					return
+				}
+			}
+			if err := app.Throttle.AllowSend(ctx, newData.Recipient()); err != nil {
+				app.writeThrottleError(c, err)
+				return
+			}
 		}
 
-		// Call the Twilio service to send the OTP
-		_, err := app.twilioSendOTP(newData.PhoneNumber)
+		// Word the message in the best offered language for the user
+		locale := app.Templates.Match(newData.Locale, c.GetHeader("Accept-Language"))
+
+		// Ask the verifier to send the OTP
+		sid, err := app.Verifier.Send(ctx, channel, newData.Recipient(), locale)
+		if err != nil {
+			// Log and respond with an error if OTP sending fails
+			app.logger(c).Error("cannot send OTP", "error", err)
+			audit.Outcome = providerOutcome(err)
+			app.releaseSend(c, newData.Recipient(), err)
+			app.writeProviderError(c, err)
+			return
+		}
+		audit.VerificationID = sid
+
+		// Track the verification so the check can be bound to this send
+		sess, err := app.Sessions.Start(ctx, sid, channel, newData.Recipient(), phone)
 		if err != nil {
-			// Respond with an error if OTP sending fails
-			app.errorJSON(c, err)
+			app.logger(c).Error("cannot record verification", "error", err)
+			app.errorJSON(c, errors.New("internal server error"), http.StatusInternalServerError)
 			return
 		}
 
-		// Respond with a success message if OTP is sent successfully
-		app.writeJSON(c, http.StatusAccepted, "OTP sent successfully")
+		// Respond with the verification the client submits the code for
+		view := sess.View(time.Now())
+		app.publish(EventOTPSent, sess, view.Status, "")
+		app.writeJSON(c, http.StatusAccepted, view)
 	}
 }
 
 // verifySMS handles the API endpoint for verifying OTPs sent via SMS
 func (app *Config) verifySMS() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Create a context with a timeout to ensure operations complete within a defined period
-		_, cancel := context.WithTimeout(context.Background(), appTimeout)
+		// Bound the request with a timeout; provider calls also stop when the client disconnects
+		ctx, cancel := context.WithTimeout(c.Request.Context(), appTimeout)
 		defer cancel() // Ensure context resources are released
 
+		// Record the call in the audit trail and metrics once it is answered
+		audit := AuditEvent{Action: AuditOTPVerify}
+		defer app.recordCall(c, &audit)
+
 		// Variable to hold the incoming request payload
 		var payload data.VerifyData
 
-		// Validate the request body and bind it to the payload
-		app.validateBody(c, &payload)
+		// Validate the request body and bind it to the payload; invalid input never reaches the provider
+		if err := app.validateBody(c, &payload); err != nil {
+			app.writeRequestError(c, err)
+			return
+		}
 
 		// Create a new VerifyData object using the validated payload
 		newData := data.VerifyData{
-			User: payload.User,
-			Code: payload.Code,
+			VerificationID: payload.VerificationID,
+			User:           payload.User,
+			Code:           payload.Code,
+		}
+
+		// Match the user's phone number in the form it was stored in
+		if newData.User != nil {
+			user := *newData.User
+			if _, ok := app.normalizePhone(c, "user.phoneNumber", &user); !ok {
+				return
+			}
+			newData.User = &user
+			audit.Recipient, audit.Channel = user.Recipient(), user.DeliveryChannel()
+		}
+		audit.VerificationID = newData.VerificationID
+
+		// Find the verification the code answers
+		sess, ok := app.lookupSession(c, newData)
+		if !ok {
+			return
+		}
+		audit.Recipient, audit.Channel, audit.VerificationID = sess.To, sess.Channel, sess.ID
+		if !app.requirePending(c, sess) {
+			return
+		}
+
+		// Count the check and refuse it for locked recipients and sessions before the provider sees the code
+		if app.Guard != nil {
+			if err := app.Guard.Allow(ctx, sess.To, sess.ID, c.ClientIP()); err != nil {
+				app.writeThrottleError(c, err)
+				return
+			}
 		}
 
-		// Call the Twilio service to verify the OTP
-		err := app.twilioVerifyOTP(newData.User.PhoneNumber, newData.Code)
-		fmt.Println("err: ", err) // Log the error for debugging
+		// Ask the verifier to check the OTP
+		err := app.Verifier.Check(ctx, sess.To, newData.Code)
 		if err != nil {
-			// Respond with an error if OTP verification fails
-			app.errorJSON(c, err)
+			// Log and respond with an error if OTP verification fails
+			app.logger(c).Warn("OTP verification failed", "verification_id", sess.ID, "error", err)
+			audit.Outcome = providerOutcome(err)
+			if errors.Is(err, ErrInvalidCode) {
+				audit.Outcome = AuditOutcomeInvalidCode
+				app.publish(EventOTPFailed, sess, data.StatusPending, "invalid_code")
+				if app.Guard != nil {
+					locked, gerr := app.Guard.RecordFailure(ctx, sess.To, sess.ID, c.ClientIP())
+					if gerr != nil {
+						app.logger(c).Error("cannot record failed verification", "error", gerr)
+					}
+					if locked != "" {
+						app.publish(EventOTPLocked, sess, data.StatusPending, locked)
+					}
+				}
+			}
+			app.writeProviderError(c, err)
+			return
+		}
+
+		// The provider has consumed the code, so record the outcome even if the client has gone away
+		ctx = context.WithoutCancel(ctx)
+		if app.Guard != nil {
+			if err := app.Guard.RecordSuccess(ctx, sess.To, sess.ID); err != nil {
+				app.logger(c).Error("cannot clear failed verifications", "error", err)
+			}
+		}
+
+		// Only the check that moves the session out of pending is answered with an approval and a token
+		if err := app.Sessions.Approve(ctx, sess); errors.Is(err, ErrSessionNotPending) {
+			app.errorJSON(c, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			app.logger(c).Error("cannot record approval", "verification_id", sess.ID, "error", err)
+			app.errorJSON(c, errors.New("internal server error"), http.StatusInternalServerError)
+			return
+		}
+
+		// Mint a token downstream services can trust
+		now := time.Now()
+		approval := data.Approval{Verification: sess.View(now)}
+		if app.Tokens != nil {
+			token, expires, err := app.Tokens.Issue(sess, now)
+			if err != nil {
+				app.logger(c).Error("cannot issue token", "verification_id", sess.ID, "error", err)
+				app.errorJSON(c, errors.New("internal server error"), http.StatusInternalServerError)
+				return
+			}
+			approval.Token, approval.TokenExpiresAt = token, &expires
+		}
+
+		// Respond with the approved verification and its token
+		app.publish(EventOTPApproved, sess, approval.Status, "")
+		app.writeJSON(c, http.StatusAccepted, approval)
+	}
+}
+
+// getVerification handles the API endpoint reporting the status of a verification
+func (app *Config) getVerification() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sess, ok := app.sessionByID(c, c.Param("id"))
+		if !ok {
+			return
+		}
+		app.writeJSON(c, http.StatusOK, sess.View(time.Now()))
+	}
+}
+
+// cancelVerification handles the API endpoint canceling a pending verification
+func (app *Config) cancelVerification() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sess, ok := app.sessionByID(c, c.Param("id"))
+		if !ok {
 			return
 		}
+		if !app.requirePending(c, sess) {
+			return
+		}
+		if err := app.Sessions.Cancel(c.Request.Context(), sess); errors.Is(err, ErrSessionNotPending) {
+			app.errorJSON(c, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			app.logger(c).Error("cannot cancel verification", "verification_id", sess.ID, "error", err)
+			app.errorJSON(c, errors.New("internal server error"), http.StatusInternalServerError)
+			return
+		}
+		app.writeJSON(c, http.StatusOK, sess.View(time.Now()))
+	}
+}
+
+// lookupSession finds the verification a check refers to: by ID when given, otherwise the
+// latest verification of the user. It writes the error response and returns false on failure
+func (app *Config) lookupSession(c *gin.Context, req data.VerifyData) (*Session, bool) {
+	if req.VerificationID == "" {
+		sess, err := app.Sessions.Current(c.Request.Context(), req.User.Recipient())
+		if errors.Is(err, ErrSessionNotFound) {
+			app.errorJSON(c, ErrInvalidCode) // Nothing was sent to this recipient
+			return nil, false
+		}
+		if err != nil {
+			app.logger(c).Error("cannot load verification", "error", err)
+			app.errorJSON(c, errors.New("internal server error"), http.StatusInternalServerError)
+			return nil, false
+		}
+		return sess, true
+	}
+
+	sess, ok := app.sessionByID(c, req.VerificationID)
+	if !ok {
+		return nil, false
+	}
+	// A user sent along with the ID must match it; a mismatch is reported like an unknown ID
+	if req.User != nil && req.User.Recipient() != sess.To {
+		app.errorJSON(c, ErrSessionNotFound, http.StatusNotFound)
+		return nil, false
+	}
+	return sess, true
+}
+
+// sessionByID loads a verification by ID, writing the error response and returning false on failure
+func (app *Config) sessionByID(c *gin.Context, id string) (*Session, bool) {
+	sess, err := app.Sessions.Get(c.Request.Context(), id)
+	if errors.Is(err, ErrSessionNotFound) {
+		app.errorJSON(c, err, http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		app.logger(c).Error("cannot load verification", "verification_id", id, "error", err)
+		app.errorJSON(c, errors.New("internal server error"), http.StatusInternalServerError)
+		return nil, false
+	}
+	return sess, true
+}
+
+// requirePending responds 410 for an expired verification and 409 for an approved or canceled
+// one, returning false; it returns true when the verification is still pending
+func (app *Config) requirePending(c *gin.Context, sess *Session) bool {
+	switch sess.CurrentStatus(time.Now()) {
+	case data.StatusPending:
+		return true
+	case data.StatusExpired:
+		app.errorJSON(c, errors.New("verification expired, request a new code"), http.StatusGone)
+	default:
+		app.errorJSON(c, fmt.Errorf("verification is already %s", sess.Status), http.StatusConflict)
+	}
+	return false
+}
+
+// writeProviderError responds to a failed Verifier call. Provider outages, timeouts and
+// transient failures that outlasted the retries are reported as 503; anything else,
+// such as a wrong code or a number the provider rejects, as 400
+func (app *Config) writeProviderError(c *gin.Context, err error) {
+	if providerUnavailable(err) {
+		app.errorJSON(c, errors.New("verification provider unavailable, try again later"), http.StatusServiceUnavailable)
+		return
+	}
+	app.errorJSON(c, err)
+}
+
+// providerUnavailable reports whether a provider error means the provider could not be reached
+// in time, as opposed to it refusing the request
+func providerUnavailable(err error) bool {
+	return errors.Is(err, ErrProviderUnavailable) || errors.Is(err, context.Canceled) || transientError(err)
+}
 
-		// Respond with a success message if OTP is verified successfully
-		app.writeJSON(c, http.StatusAccepted, "OTP verified successfully")
+// releaseSend gives back the throttle reservation of a send that failed before any message
+// went out, so a provider outage does not cost the user a cooldown and part of their daily cap
+func (app *Config) releaseSend(c *gin.Context, to string, err error) {
+	if app.Throttle == nil || !notSent(err) {
+		return
+	}
+	if err := app.Throttle.ReleaseSend(context.WithoutCancel(c.Request.Context()), to); err != nil {
+		app.logger(c).Warn("cannot release send throttle", "error", err)
+	}
+}
+
+// notSent reports whether a failed send proves no message went out: the circuit was open,
+// the channel is not offered, or the provider could not be reached or turned the request
+// away unprocessed. Timeouts and other failures may come after delivery and do not qualify
+func notSent(err error) bool {
+	if errors.Is(err, ErrProviderUnavailable) || errors.Is(err, ErrChannelDisabled) {
+		return true
+	}
+	_, retry := retryDelay(err)
+	return retry
+}
+
+// providerOutcome is the audit outcome of a failed provider call
+func providerOutcome(err error) string {
+	if providerUnavailable(err) {
+		return AuditOutcomeProviderUnavailable
+	}
+	return AuditOutcomeProviderError
+}
+
+// jwks handles the API endpoint publishing the public keys tokens are signed with
+func (app *Config) jwks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Let validators cache the keys briefly; rotated keys are published alongside the current one
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, app.Tokens.JWKS())
 	}
 }
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"           // Test contexts
+	"encoding/json"     // Used to build request bodies and read responses
+	"io"                // Discarded logs
+	"log/slog"          // Test logger
+	"net/http"          // HTTP methods and status codes
+	"net/http/httptest" // In-process requests against the router
+	"strings"           // Request bodies
+	"sync"              // Concurrent checks
+	"testing"           // Go test framework
+	"time"              // Code lifetimes
+
+	"go-twilio-verify/data" // Verification statuses
+
+	"github.com/gin-gonic/gin" // Gin framework for HTTP handling
+)
+
+const testPhone = "+14155552671"
+
+// testResponse is the envelope every handler answers with
+type testResponse struct {
+	Status  int               `json:"status"`
+	Message string            `json:"message"`
+	Data    data.Verification `json:"data"`
+}
+
+// newTestApp returns the routes backed by a FakeVerifier, with sessions kept as opts says
+func newTestApp(t *testing.T, opts SessionOptions) (*Config, *FakeVerifier) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	fake := NewFakeVerifier()
+	app := &Config{
+		Router:   gin.New(),
+		Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Verifier: fake,
+		Sessions: NewSessionStore(NewMemoryStore(), opts),
+	}
+	app.Routes()
+	return app, fake
+}
+
+// call sends a JSON request through the router and decodes the response
+func call(t *testing.T, app *Config, method, path string, body any) (int, testResponse) {
+	t.Helper()
+	raw, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, path, strings.NewReader(string(raw)))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	app.Router.ServeHTTP(rec, req)
+
+	var resp testResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s %s: cannot decode %q: %v", method, path, rec.Body.String(), err)
+	}
+	return rec.Code, resp
+}
+
+// sendCode sends a code to testPhone and returns the verification ID and the code
+func sendCode(t *testing.T, app *Config, fake *FakeVerifier) (string, string) {
+	t.Helper()
+	status, resp := call(t, app, http.MethodPost, "/otp", data.OTPData{PhoneNumber: testPhone})
+	if status != http.StatusAccepted {
+		t.Fatalf("send: got %d %q, want %d", status, resp.Message, http.StatusAccepted)
+	}
+	code, ok := fake.LastCode(testPhone)
+	if !ok {
+		t.Fatal("send: no code recorded")
+	}
+	return resp.Data.ID, code
+}
+
+func TestVerifyApprovesSentCode(t *testing.T) {
+	app, fake := newTestApp(t, SessionOptions{})
+	id, code := sendCode(t, app, fake)
+
+	status, resp := call(t, app, http.MethodPost, "/verifyOTP", data.VerifyData{VerificationID: id, Code: code})
+	if status != http.StatusAccepted {
+		t.Fatalf("verify: got %d %q, want %d", status, resp.Message, http.StatusAccepted)
+	}
+	if resp.Data.Status != data.StatusApproved {
+		t.Errorf("verify: got status %q, want %q", resp.Data.Status, data.StatusApproved)
+	}
+
+	// The code is used up
+	status, _ = call(t, app, http.MethodPost, "/verifyOTP", data.VerifyData{VerificationID: id, Code: code})
+	if status != http.StatusConflict {
+		t.Errorf("second verify: got %d, want %d", status, http.StatusConflict)
+	}
+}
+
+func TestVerifyRejectsWrongCode(t *testing.T) {
+	app, fake := newTestApp(t, SessionOptions{})
+	id, code := sendCode(t, app, fake)
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	status, resp := call(t, app, http.MethodPost, "/verifyOTP", data.VerifyData{VerificationID: id, Code: wrong})
+	if status != http.StatusBadRequest || resp.Message != ErrInvalidCode.Error() {
+		t.Fatalf("verify: got %d %q, want %d %q", status, resp.Message, http.StatusBadRequest, ErrInvalidCode)
+	}
+
+	// A wrong guess leaves the verification pending
+	status, resp = call(t, app, http.MethodPost, "/verifyOTP", data.VerifyData{VerificationID: id, Code: code})
+	if status != http.StatusAccepted {
+		t.Errorf("verify after wrong code: got %d %q, want %d", status, resp.Message, http.StatusAccepted)
+	}
+}
+
+func TestVerifyRejectsExpiredCode(t *testing.T) {
+	app, fake := newTestApp(t, SessionOptions{CodeTTL: time.Millisecond})
+	id, code := sendCode(t, app, fake)
+	time.Sleep(5 * time.Millisecond)
+
+	status, resp := call(t, app, http.MethodPost, "/verifyOTP", data.VerifyData{VerificationID: id, Code: code})
+	if status != http.StatusGone {
+		t.Fatalf("verify: got %d %q, want %d", status, resp.Message, http.StatusGone)
+	}
+}
+
+// replayingVerifier accepts a code as often as it is checked, like a provider accepting
+// concurrent checks that arrive before it marks the code used
+type replayingVerifier struct{ *FakeVerifier }
+
+func (v replayingVerifier) Check(ctx context.Context, to, code string) error {
+	if got, ok := v.LastCode(to); !ok || got != code {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+func TestVerifyApprovesConcurrentChecksOnce(t *testing.T) {
+	app, fake := newTestApp(t, SessionOptions{})
+	app.Verifier = replayingVerifier{fake}
+	id, code := sendCode(t, app, fake)
+
+	const checks = 20
+	statuses := make(chan int, checks)
+	var wg sync.WaitGroup
+	for i := 0; i < checks; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, _ := call(t, app, http.MethodPost, "/verifyOTP", data.VerifyData{VerificationID: id, Code: code})
+			statuses <- status
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	approved := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusAccepted:
+			approved++
+		case http.StatusConflict:
+		default:
+			t.Errorf("verify: got %d, want %d or %d", status, http.StatusAccepted, http.StatusConflict)
+		}
+	}
+	if approved != 1 {
+		t.Errorf("got %d approvals of one verification, want 1", approved)
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"     // Bounds the checks
+	"net/http"    // HTTP status codes
+	"sync"        // Runs the checks concurrently
+	"sync/atomic" // Tracks whether the service is draining
+	"time"        // Check deadlines and durations
+
+	"github.com/gin-gonic/gin" // Gin framework for HTTP handling
+)
+
+// defaultHealthTimeout bounds every check run by Health when no timeout is given
+const defaultHealthTimeout = 2 * time.Second
+
+// Pinger is implemented by providers and stores that can check they are reachable
+type Pinger interface {
+	// Ping returns an error when the dependency cannot be reached or rejects the credentials.
+	// It must not have side effects such as sending a message
+	Ping(ctx context.Context) error
+}
+
+// ping pings v when it is a Pinger; anything else has nothing to reach and passes
+func ping(ctx context.Context, v any) error {
+	if p, ok := v.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// HealthCheck is a dependency probed by the health endpoints
+type HealthCheck struct {
+	Name     string // Name the result is reported under, e.g. provider or redis
+	Pinger   Pinger
+	Optional bool // The service keeps working without it, so a failure does not make it unready
+}
+
+// HealthResult is the outcome of one HealthCheck
+type HealthResult struct {
+	Status    string `json:"status"`          // ok or unreachable
+	Error     string `json:"error,omitempty"` // Why the check failed
+	LatencyMS int64  `json:"latencyMs"`       // Duration of the check
+	Optional  bool   `json:"optional,omitempty"`
+}
+
+// HealthReport is the body of /healthz and /readyz
+type HealthReport struct {
+	Status string                  `json:"status"` // ok, degraded, unavailable or draining
+	Checks map[string]HealthResult `json:"checks"`
+}
+
+// Health runs the health checks and tracks whether the service is shutting down
+type Health struct {
+	checks   []HealthCheck
+	timeout  time.Duration
+	draining atomic.Bool
+}
+
+// NewHealth creates a Health probing checks, each bounded by timeout (default 2s)
+func NewHealth(timeout time.Duration, checks ...HealthCheck) *Health {
+	if timeout == 0 {
+		timeout = defaultHealthTimeout
+	}
+	return &Health{checks: checks, timeout: timeout}
+}
+
+// Drain marks the service as shutting down, so /readyz fails and load balancers stop
+// sending new requests while the in-flight ones finish
+func (h *Health) Drain() {
+	h.draining.Store(true)
+}
+
+// Check runs every check concurrently. The status is unavailable when a required check
+// failed, degraded when only optional ones did, and draining once Drain was called
+func (h *Health) Check(ctx context.Context) HealthReport {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	results := make([]HealthResult, len(h.checks))
+	var wg sync.WaitGroup
+	for i, check := range h.checks {
+		wg.Add(1)
+		go func(i int, check HealthCheck) {
+			defer wg.Done()
+			start := time.Now()
+			result := HealthResult{Status: "ok", Optional: check.Optional}
+			if err := check.Pinger.Ping(ctx); err != nil {
+				result.Status, result.Error = "unreachable", err.Error()
+			}
+			result.LatencyMS = time.Since(start).Milliseconds()
+			results[i] = result
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := HealthReport{Status: "ok", Checks: make(map[string]HealthResult, len(h.checks))}
+	for i, check := range h.checks {
+		report.Checks[check.Name] = results[i]
+		switch {
+		case results[i].Status == "ok":
+		case check.Optional && report.Status == "ok":
+			report.Status = "degraded"
+		case !check.Optional:
+			report.Status = "unavailable"
+		}
+	}
+	if h.draining.Load() {
+		report.Status = "draining"
+	}
+	return report
+}
+
+// healthz handles the liveness probe. It reports the checks but answers 200 whenever the
+// process can serve requests, so an outage of a dependency does not get it restarted
+func (app *Config) healthz() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		app.writeJSON(c, http.StatusOK, app.Health.Check(c.Request.Context()))
+	}
+}
+
+// readyz handles the readiness probe, answering 503 while a required dependency is
+// unreachable or the service is draining
+func (app *Config) readyz() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report := app.Health.Check(c.Request.Context())
+		if report.Status == "unavailable" || report.Status == "draining" {
+			c.JSON(http.StatusServiceUnavailable, jsonResponse{Status: http.StatusServiceUnavailable, Message: "not ready", Data: report})
+			return
+		}
+		app.writeJSON(c, http.StatusOK, report)
+	}
+}
@@ -3,8 +3,7 @@ package api
 import (
 	"net/http" // Provides HTTP status codes and functions
 
-	"github.com/gin-gonic/gin"               // Gin framework for HTTP handling
-	"github.com/go-playground/validator/v10" // Validation library for struct-based validation
+	"github.com/gin-gonic/gin" // Gin framework for HTTP handling
 )
 
 // jsonResponse defines the structure of JSON responses sent to the client
@@ -14,23 +13,6 @@ type jsonResponse struct {
 	Data    any    `json:"data"`    // Response data, flexible to hold any type
 }
 
-// Create a new instance of the validator library
-var validate = validator.New()
-
-// validateBody validates the incoming request body
-func (app *Config) validateBody(c *gin.Context, data any) error {
-	// Bind JSON data from the request body to the provided struct
-	if err := c.BindJSON(&data); err != nil {
-		return err // Return an error if binding fails
-	}
-	// Use the validator library to validate the struct fields
-	if err := validate.Struct(&data); err != nil {
-		return err // Return an error if validation fails
-	}
-
-	return nil // Return nil if validation is successful
-}
-
 // writeJSON sends a success response to the client with the given status and data
 func (app *Config) writeJSON(c *gin.Context, status int, data any) {
 	// Format the response using the jsonResponse structure
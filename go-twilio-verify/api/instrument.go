@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context" // Carries the active span to provider calls
+	"errors"  // Used to recognise rejected codes
+	"time"    // Used to time provider calls
+
+	"github.com/gin-gonic/gin"             // Gin framework for HTTP handling
+	"go.opentelemetry.io/otel"             // Global tracer provider and propagator
+	"go.opentelemetry.io/otel/attribute"   // Span attributes
+	"go.opentelemetry.io/otel/codes"       // Span status codes
+	"go.opentelemetry.io/otel/propagation" // Trace context extraction from request headers
+	"go.opentelemetry.io/otel/trace"       // Span kinds and options
+)
+
+// tracerName identifies the spans of this service; spans go nowhere until a tracer provider is installed
+const tracerName = "go-twilio-verify"
+
+// InstrumentedVerifier wraps a Verifier that calls a remote provider with a span and a
+// latency measurement for every call. Wrap it in a ResilientVerifier so each attempt is measured
+type InstrumentedVerifier struct {
+	name    string
+	next    Verifier
+	metrics *Metrics
+}
+
+// NewInstrumentedVerifier wraps next; name identifies the provider in spans and metrics.
+// metrics may be nil to record spans only
+func NewInstrumentedVerifier(name string, next Verifier, metrics *Metrics) *InstrumentedVerifier {
+	return &InstrumentedVerifier{name: name, next: next, metrics: metrics}
+}
+
+// Send sends a code through the wrapped Verifier
+func (v *InstrumentedVerifier) Send(ctx context.Context, channel, to, locale string) (string, error) {
+	var sid string
+	err := instrument(ctx, v.name, "send", v.metrics, func(ctx context.Context) error {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("otp.channel", channel))
+		var err error
+		sid, err = v.next.Send(ctx, channel, to, locale)
+		return err
+	})
+	return sid, err
+}
+
+// Check checks a code through the wrapped Verifier
+func (v *InstrumentedVerifier) Check(ctx context.Context, to, code string) error {
+	return instrument(ctx, v.name, "check", v.metrics, func(ctx context.Context) error {
+		return v.next.Check(ctx, to, code)
+	})
+}
+
+// Ping checks the wrapped Verifier; health checks are not measured
+func (v *InstrumentedVerifier) Ping(ctx context.Context) error {
+	return ping(ctx, v.next)
+}
+
+// InstrumentedSender wraps a MessageSender with the same spans and latency measurements as
+// InstrumentedVerifier. Status callbacks are passed on when the wrapped sender supports them
+type InstrumentedSender struct {
+	name    string
+	next    MessageSender
+	metrics *Metrics
+}
+
+// NewInstrumentedSender wraps next; name identifies the provider in spans and metrics.
+// metrics may be nil to record spans only
+func NewInstrumentedSender(name string, next MessageSender, metrics *Metrics) *InstrumentedSender {
+	return &InstrumentedSender{name: name, next: next, metrics: metrics}
+}
+
+// SendMessage delivers body through the wrapped sender
+func (s *InstrumentedSender) SendMessage(ctx context.Context, to, body string) (string, error) {
+	return s.SendTrackedMessage(ctx, to, body, "")
+}
+
+// SendTrackedMessage delivers body through the wrapped sender, requesting status callbacks
+// when it supports them
+func (s *InstrumentedSender) SendTrackedMessage(ctx context.Context, to, body, statusCallback string) (string, error) {
+	var id string
+	err := instrument(ctx, s.name, "send", s.metrics, func(ctx context.Context) error {
+		var err error
+		if tracked, ok := s.next.(TrackedSender); ok && statusCallback != "" {
+			id, err = tracked.SendTrackedMessage(ctx, to, body, statusCallback)
+		} else {
+			id, err = s.next.SendMessage(ctx, to, body)
+		}
+		return err
+	})
+	return id, err
+}
+
+// Ping checks the wrapped sender; health checks are not measured
+func (s *InstrumentedSender) Ping(ctx context.Context) error {
+	return ping(ctx, s.next)
+}
+
+// instrument runs a provider call inside a client span and records its duration. A rejected
+// code is the provider's answer, not a failed call
+func instrument(ctx context.Context, provider, operation string, metrics *Metrics, call func(context.Context) error) error {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, provider+" "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("otp.provider", provider), attribute.String("otp.operation", operation)),
+	)
+	defer span.End()
+
+	start := time.Now()
+	err := call(ctx)
+	failed := err != nil && !errors.Is(err, ErrInvalidCode)
+	if metrics != nil {
+		metrics.observeProvider(provider, operation, time.Since(start), failed)
+	}
+	if failed {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// traceRequests is middleware starting a server span for every request, continuing the
+// caller's trace when the request carries a traceparent header
+func (app *Config) traceRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := otel.Tracer(tracerName).Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attribute.String("http.request.method", c.Request.Method), attribute.String("http.route", route)),
+		)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, "")
+		}
+	}
+}
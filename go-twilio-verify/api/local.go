@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"       // Carries deadlines to the store
+	"crypto/hmac"   // Keyed hashing of stored codes
+	"crypto/rand"   // Cryptographically secure code generation
+	"crypto/sha256" // Hash function used by the HMAC
+	"encoding/hex"  // Encoding of hashes and verification IDs
+	"encoding/json" // Serialization of stored verifications
+	"errors"        // Used to inspect store errors
+	"fmt"           // Used to format codes and messages
+	"math/big"      // Used to draw a uniformly random code
+	"slices"        // Used to order channels in health reports
+	"time"          // Used for code expiry
+)
+
+// Defaults applied by NewLocalVerifier for zero LocalOptions fields
+const (
+	defaultCodeLength  = 6
+	defaultCodeTTL     = 10 * time.Minute
+	defaultMaxAttempts = 5
+)
+
+// LocalOptions configures the self-hosted OTP engine
+type LocalOptions struct {
+	HashKey     []byte        // Secret key the stored codes are hashed with; must be shared by every instance
+	CodeLength  int           // Number of digits in a code (default 6)
+	TTL         time.Duration // How long a code stays valid (default 10m)
+	MaxAttempts int           // Wrong guesses allowed before the code is discarded (default 5)
+	AppName     string        // Name shown in the message, e.g. "Your Acme verification code is ..."
+	Templates   *Templates    // Message wording per locale and channel (default: built-in English)
+
+	StatusCallbacks *StatusCallbacks // Where Twilio reports the delivery of each code; nil disables delivery tracking
+}
+
+// localVerification is the state kept for a pending code. Only a hash of the code is stored
+type localVerification struct {
+	SID      string `json:"sid"`       // Verification ID returned by Send
+	Channel  string `json:"channel"`   // Channel the code was delivered over
+	CodeHash string `json:"code_hash"` // HMAC-SHA256 of the recipient and code
+}
+
+// LocalVerifier is a Verifier that generates and checks codes itself instead of using
+// Twilio Verify. Codes are stored hashed with a TTL and an attempt counter, and are
+// delivered through the MessageSender registered for the requested channel
+type LocalVerifier struct {
+	store   Store
+	senders map[string]MessageSender // Sender per channel; channels without one are unavailable
+	opts    LocalOptions
+}
+
+// NewLocalVerifier creates a self-hosted Verifier delivering codes through senders,
+// keyed by channel (data.Channel*). opts.HashKey is required
+func NewLocalVerifier(store Store, senders map[string]MessageSender, opts LocalOptions) (*LocalVerifier, error) {
+	if len(senders) == 0 {
+		return nil, errors.New("local verifier: at least one sender is required")
+	}
+	if len(opts.HashKey) == 0 {
+		return nil, errors.New("local verifier: hash key is required")
+	}
+	if opts.CodeLength == 0 {
+		opts.CodeLength = defaultCodeLength
+	}
+	if opts.CodeLength < 4 || opts.CodeLength > 10 {
+		return nil, fmt.Errorf("local verifier: code length must be between 4 and 10, got %d", opts.CodeLength)
+	}
+	if opts.TTL == 0 {
+		opts.TTL = defaultCodeTTL
+	}
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+	if opts.MaxAttempts < 0 {
+		return nil, fmt.Errorf("local verifier: max attempts must not be negative, got %d", opts.MaxAttempts)
+	}
+	if opts.Templates == nil {
+		opts.Templates = NewTemplates()
+	}
+	return &LocalVerifier{store: store, senders: senders, opts: opts}, nil
+}
+
+// Send generates a new code for the recipient, stores its hash and delivers it over the channel,
+// worded for the locale. A new code replaces any code still pending for the same recipient
+func (v *LocalVerifier) Send(ctx context.Context, channel, to, locale string) (string, error) {
+	sender, ok := v.senders[channel]
+	if !ok {
+		return "", ErrChannelDisabled
+	}
+
+	code, err := v.generateCode()
+	if err != nil {
+		return "", err
+	}
+	sid, err := newVerificationSID()
+	if err != nil {
+		return "", err
+	}
+	message, err := v.message(locale, channel, code)
+	if err != nil {
+		return "", err
+	}
+
+	record, err := json.Marshal(localVerification{SID: sid, Channel: channel, CodeHash: v.hash(to, code)})
+	if err != nil {
+		return "", err
+	}
+	if err := v.store.Set(ctx, codeKey(to), string(record), v.opts.TTL); err != nil {
+		return "", err
+	}
+	// The new code starts with a fresh attempt budget
+	if err := v.store.Delete(ctx, attemptsKey(to)); err != nil {
+		return "", err
+	}
+
+	// Have the provider report whether the code arrived, when it can
+	if tracked, ok := sender.(TrackedSender); ok && v.opts.StatusCallbacks != nil {
+		_, err = tracked.SendTrackedMessage(ctx, to, message, v.opts.StatusCallbacks.URL(sid))
+	} else {
+		_, err = sender.SendMessage(ctx, to, message)
+	}
+	if err != nil {
+		// Do not leave a code behind that the user never received
+		_ = v.store.Delete(context.WithoutCancel(ctx), codeKey(to))
+		return "", err
+	}
+
+	return sid, nil
+}
+
+// Check verifies the code against the stored hash. Every call uses up an attempt;
+// once the attempts are exhausted the code is discarded and a new one must be requested.
+// A code is accepted once, also when it is checked concurrently
+func (v *LocalVerifier) Check(ctx context.Context, to, code string) error {
+	raw, err := v.store.Get(ctx, codeKey(to))
+	if errors.Is(err, ErrNotFound) {
+		return ErrInvalidCode // No code pending, or it expired
+	}
+	if err != nil {
+		return err
+	}
+	var record localVerification
+	if err := json.Unmarshal([]byte(raw), &record); err != nil {
+		return err
+	}
+
+	attempts, err := v.store.Incr(ctx, attemptsKey(to), v.opts.TTL)
+	if err != nil {
+		return err
+	}
+	if attempts > int64(v.opts.MaxAttempts) {
+		_ = v.store.Delete(ctx, codeKey(to), attemptsKey(to))
+		return ErrInvalidCode
+	}
+
+	if !hmac.Equal([]byte(record.CodeHash), []byte(v.hash(to, code))) {
+		return ErrInvalidCode
+	}
+
+	// Claim the code before accepting it: of concurrent checks that all matched the hash,
+	// only the one setting the marker succeeds
+	claimed, err := v.store.SetNX(ctx, usedKey(record.SID), "1", v.opts.TTL)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrInvalidCode
+	}
+	return v.store.Delete(ctx, codeKey(to), attemptsKey(to))
+}
+
+// Ping checks the sender of every channel
+func (v *LocalVerifier) Ping(ctx context.Context) error {
+	var errs []error
+	channels := make([]string, 0, len(v.senders))
+	for ch := range v.senders {
+		channels = append(channels, ch)
+	}
+	slices.Sort(channels)
+	for _, ch := range channels {
+		if err := ping(ctx, v.senders[ch]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", ch, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// generateCode returns a uniformly random numeric code of the configured length
+func (v *LocalVerifier) generateCode() (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(v.opts.CodeLength)), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", v.opts.CodeLength, n), nil
+}
+
+// hash returns the keyed hash a code is stored as. The recipient is included so a
+// hash cannot be matched against the codes of other recipients
+func (v *LocalVerifier) hash(to, code string) string {
+	mac := hmac.New(sha256.New, v.opts.HashKey)
+	mac.Write([]byte(to + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// message renders the text delivered to the user
+func (v *LocalVerifier) message(locale, channel, code string) (string, error) {
+	return v.opts.Templates.Render(locale, channel, TemplateData{
+		AppName:    v.opts.AppName,
+		Code:       code,
+		CodeLength: v.opts.CodeLength,
+		TTLMinutes: int(v.opts.TTL.Round(time.Minute) / time.Minute),
+	})
+}
+
+// newVerificationSID returns a random verification ID shaped like a Twilio verification SID
+func newVerificationSID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return "VE" + hex.EncodeToString(id), nil
+}
+
+// codeKey is the store key of the pending code for a recipient
+func codeKey(to string) string { return "otp:code:" + to }
+
+// attemptsKey is the store key of the attempt counter for a recipient
+func attemptsKey(to string) string { return "otp:attempts:" + to }
+
+// usedKey is the store key marking the code of verification sid as used
+func usedKey(sid string) string { return "otp:used:" + sid }
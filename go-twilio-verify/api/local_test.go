@@ -0,0 +1,184 @@
+package api
+
+import (
+	"context" // Test contexts
+	"errors"  // Used to inspect verifier errors
+	"regexp"  // Used to find the code in the message
+	"sync"    // Concurrent checks
+	"testing" // Go test framework
+	"time"    // Code lifetimes
+
+	"go-twilio-verify/data" // Channel names
+)
+
+// recordingSender is a MessageSender keeping every message instead of delivering it
+type recordingSender struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (s *recordingSender) SendMessage(_ context.Context, _, body string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, body)
+	return "SM1", nil
+}
+
+var localCodePattern = regexp.MustCompile(`\d{4,10}`)
+
+// lastCode returns the code in the most recent message
+func (s *recordingSender) lastCode(t *testing.T) string {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.messages) == 0 {
+		t.Fatal("no message sent")
+	}
+	code := localCodePattern.FindString(s.messages[len(s.messages)-1])
+	if code == "" {
+		t.Fatalf("no code in %q", s.messages[len(s.messages)-1])
+	}
+	return code
+}
+
+// newTestLocalVerifier returns a LocalVerifier sending SMS through a recordingSender
+func newTestLocalVerifier(t *testing.T, opts LocalOptions) (*LocalVerifier, *recordingSender) {
+	t.Helper()
+	sender := &recordingSender{}
+	opts.HashKey = []byte("test-key")
+	v, err := NewLocalVerifier(NewMemoryStore(), map[string]MessageSender{data.ChannelSMS: sender}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, sender
+}
+
+func TestNewLocalVerifierOptions(t *testing.T) {
+	senders := map[string]MessageSender{data.ChannelSMS: &recordingSender{}}
+	tests := []struct {
+		name    string
+		senders map[string]MessageSender
+		opts    LocalOptions
+		wantErr bool
+	}{
+		{name: "defaults", senders: senders, opts: LocalOptions{HashKey: []byte("k")}},
+		{name: "no senders", opts: LocalOptions{HashKey: []byte("k")}, wantErr: true},
+		{name: "no hash key", senders: senders, wantErr: true},
+		{name: "code too short", senders: senders, opts: LocalOptions{HashKey: []byte("k"), CodeLength: 3}, wantErr: true},
+		{name: "code too long", senders: senders, opts: LocalOptions{HashKey: []byte("k"), CodeLength: 11}, wantErr: true},
+		{name: "negative attempts", senders: senders, opts: LocalOptions{HashKey: []byte("k"), MaxAttempts: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewLocalVerifier(NewMemoryStore(), tt.senders, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewLocalVerifier: got %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLocalVerifierCheck(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  LocalOptions
+		check func(t *testing.T, v *LocalVerifier, code string)
+	}{
+		{
+			name: "right code once",
+			check: func(t *testing.T, v *LocalVerifier, code string) {
+				if err := v.Check(context.Background(), testPhone, code); err != nil {
+					t.Fatalf("first check: %v", err)
+				}
+				if err := v.Check(context.Background(), testPhone, code); !errors.Is(err, ErrInvalidCode) {
+					t.Errorf("second check: got %v, want %v", err, ErrInvalidCode)
+				}
+			},
+		},
+		{
+			name: "wrong code keeps the right one",
+			check: func(t *testing.T, v *LocalVerifier, code string) {
+				if err := v.Check(context.Background(), testPhone, wrongCode(code)); !errors.Is(err, ErrInvalidCode) {
+					t.Errorf("wrong code: got %v, want %v", err, ErrInvalidCode)
+				}
+				if err := v.Check(context.Background(), testPhone, code); err != nil {
+					t.Errorf("right code: %v", err)
+				}
+			},
+		},
+		{
+			name: "attempts exhausted",
+			opts: LocalOptions{MaxAttempts: 2},
+			check: func(t *testing.T, v *LocalVerifier, code string) {
+				for i := 0; i < 2; i++ {
+					_ = v.Check(context.Background(), testPhone, wrongCode(code))
+				}
+				if err := v.Check(context.Background(), testPhone, code); !errors.Is(err, ErrInvalidCode) {
+					t.Errorf("right code after the attempts: got %v, want %v", err, ErrInvalidCode)
+				}
+			},
+		},
+		{
+			name: "expired code",
+			opts: LocalOptions{TTL: time.Millisecond},
+			check: func(t *testing.T, v *LocalVerifier, code string) {
+				time.Sleep(5 * time.Millisecond)
+				if err := v.Check(context.Background(), testPhone, code); !errors.Is(err, ErrInvalidCode) {
+					t.Errorf("expired code: got %v, want %v", err, ErrInvalidCode)
+				}
+			},
+		},
+		{
+			name: "code of another recipient",
+			check: func(t *testing.T, v *LocalVerifier, code string) {
+				if err := v.Check(context.Background(), "+14155550100", code); !errors.Is(err, ErrInvalidCode) {
+					t.Errorf("other recipient: got %v, want %v", err, ErrInvalidCode)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, sender := newTestLocalVerifier(t, tt.opts)
+			if _, err := v.Send(context.Background(), data.ChannelSMS, testPhone, ""); err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, v, sender.lastCode(t))
+		})
+	}
+}
+
+func TestLocalVerifierConcurrentChecks(t *testing.T) {
+	v, sender := newTestLocalVerifier(t, LocalOptions{MaxAttempts: 100})
+	if _, err := v.Send(context.Background(), data.ChannelSMS, testPhone, ""); err != nil {
+		t.Fatal(err)
+	}
+	code := sender.lastCode(t)
+
+	const checks = 50
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	approved := 0
+	for i := 0; i < checks; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := v.Check(context.Background(), testPhone, code); err == nil {
+				mu.Lock()
+				approved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if approved != 1 {
+		t.Errorf("got %d approved checks of one code, want 1", approved)
+	}
+}
+
+// wrongCode returns a code of the same length that differs from code
+func wrongCode(code string) string {
+	b := []byte(code)
+	b[0] = '0' + (b[0]-'0'+1)%10
+	return string(b)
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"crypto/rand"  // Cryptographically secure random source for request IDs
+	"encoding/hex" // Encodes random bytes as a printable request ID
+	"fmt"          // Used to build descriptive errors
+	"io"           // Destination writer for log output
+	"log/slog"     // Structured logging from the standard library
+	"strings"      // Case-insensitive parsing of level and format names
+	"time"         // Used to measure request latency
+
+	"github.com/gin-gonic/gin" // Gin framework for HTTP handling
+)
+
+// requestIDHeader is the header a request ID is accepted from and returned in
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen caps client-supplied request IDs so they cannot bloat log lines
+const maxRequestIDLen = 128
+
+// loggerKey is the gin.Context key holding the request-scoped logger
+const loggerKey = "logger"
+
+// NewLogger creates a structured logger writing to w.
+// level is one of "debug", "info", "warn" or "error"; format is "json" or "text".
+// Empty values default to "info" and "json".
+func NewLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	switch strings.ToLower(level) {
+	case "debug":
+		lvl = slog.LevelDebug
+	case "", "info":
+		lvl = slog.LevelInfo
+	case "warn", "warning":
+		lvl = slog.LevelWarn
+	case "error":
+		lvl = slog.LevelError
+	default:
+		return nil, fmt.Errorf("unknown log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "", "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+// requestLogger assigns every request an ID, taken from the X-Request-ID header when the
+// client supplies a usable one and generated otherwise. The ID is returned in the response
+// header, attached to a request-scoped logger available through app.logger, and included
+// in the access log line written once the request completes.
+func (app *Config) requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		// Reuse the caller's request ID if it is safe to log, otherwise generate a new one
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Header(requestIDHeader, id)
+
+		// Store a logger that tags every line with the request ID
+		reqLogger := app.baseLogger().With("request_id", id)
+		c.Set(loggerKey, reqLogger)
+
+		c.Next()
+
+		// Log the completed request, escalating to error level for server failures
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		reqLogger.Log(c.Request.Context(), level, "request completed",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", status,
+			"latency_ms", time.Since(start).Milliseconds(),
+			"ip", c.ClientIP(),
+		)
+	}
+}
+
+// logger returns the request-scoped logger set by requestLogger,
+// or the application logger if the middleware did not run
+func (app *Config) logger(c *gin.Context) *slog.Logger {
+	if l, ok := c.Get(loggerKey); ok {
+		if reqLogger, ok := l.(*slog.Logger); ok {
+			return reqLogger
+		}
+	}
+	return app.baseLogger()
+}
+
+// baseLogger returns the configured application logger, falling back to slog's default
+func (app *Config) baseLogger() *slog.Logger {
+	if app.Logger != nil {
+		return app.Logger
+	}
+	return slog.Default()
+}
+
+// newRequestID generates a random 128-bit request ID encoded as hex
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// The system random source should never fail; fall back to a timestamp so the request still has an ID
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether a client-supplied request ID is safe to log and echo back:
+// non-empty, bounded in length and limited to printable ASCII without spaces
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
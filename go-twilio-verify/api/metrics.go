@@ -0,0 +1,93 @@
+package api
+
+import (
+	"net/http" // Metrics endpoint handler type
+	"time"     // Used to convert latencies to seconds
+
+	"github.com/gin-gonic/gin"                                  // Gin framework for HTTP handling
+	"github.com/prometheus/client_golang/prometheus"            // Metric collectors
+	"github.com/prometheus/client_golang/prometheus/collectors" // Go runtime and process metrics
+	"github.com/prometheus/client_golang/prometheus/promhttp"   // Exposition handler
+)
+
+// Metrics holds the Prometheus metrics of the service in a registry of its own
+type Metrics struct {
+	registry        *prometheus.Registry
+	sends           *prometheus.CounterVec   // Codes sent, by channel
+	approvals       *prometheus.CounterVec   // Codes approved, by channel
+	failures        *prometheus.CounterVec   // Send and verify calls that did not succeed, by operation and reason
+	rateLimited     *prometheus.CounterVec   // Requests refused by a rate limit or lockout, by reason
+	providerLatency *prometheus.HistogramVec // Duration of each provider call, by provider, operation and result
+}
+
+// NewMetrics creates the metrics, including the Go runtime and process metrics
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		sends: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "otp_sends_total",
+			Help: "Codes sent, by channel.",
+		}, []string{"channel"}),
+		approvals: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "otp_approvals_total",
+			Help: "Codes approved, by channel.",
+		}, []string{"channel"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "otp_failures_total",
+			Help: "Send and verify calls that did not succeed, by operation and reason.",
+		}, []string{"operation", "reason"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "otp_rate_limited_total",
+			Help: "Requests refused by a rate limit or lockout, by reason.",
+		}, []string{"reason"}),
+		providerLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "otp_provider_request_duration_seconds",
+			Help:    "Duration of each provider call attempt, by provider, operation and result.",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		}, []string{"provider", "operation", "result"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.sends, m.approvals, m.failures, m.rateLimited, m.providerLatency,
+	)
+	return m
+}
+
+// Handler returns the handler exposing the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// observeCall counts a send or verify call by its outcome; enrollment calls are not counted
+func (m *Metrics) observeCall(event AuditEvent) {
+	switch {
+	case event.Outcome == AuditOutcomeSent:
+		m.sends.WithLabelValues(event.Channel).Inc()
+	case event.Outcome == AuditOutcomeApproved:
+		m.approvals.WithLabelValues(event.Channel).Inc()
+	case event.Action == AuditOTPSend, event.Action == AuditEmailSend:
+		m.failures.WithLabelValues("send", event.Outcome).Inc()
+	case event.Action == AuditOTPVerify, event.Action == AuditTOTPVerify, event.Action == AuditRecoveryVerify, event.Action == AuditEmailVerify:
+		m.failures.WithLabelValues("verify", event.Outcome).Inc()
+	}
+}
+
+// observeRateLimited counts a request refused by a rate limit or lockout
+func (m *Metrics) observeRateLimited(reason string) {
+	m.rateLimited.WithLabelValues(reason).Inc()
+}
+
+// observeProvider records the duration of a provider call
+func (m *Metrics) observeProvider(provider, operation string, d time.Duration, failed bool) {
+	result := "ok"
+	if failed {
+		result = "error"
+	}
+	m.providerLatency.WithLabelValues(provider, operation, result).Observe(d.Seconds())
+}
+
+// metrics handles the Prometheus scrape endpoint
+func (app *Config) metrics() gin.HandlerFunc {
+	return gin.WrapH(app.Metrics.Handler())
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"errors"  // Used to define phone number errors
+	"fmt"     // Used to report an unknown default region
+	"strings" // Used to normalize region codes
+
+	"go-twilio-verify/data" // Phone number metadata model
+
+	"github.com/gin-gonic/gin"         // Gin framework for HTTP handling
+	"github.com/ttacon/libphonenumber" // Offline phone number metadata and parsing
+)
+
+// Phone number errors
+var (
+	ErrInvalidPhoneNumber = errors.New("invalid phone number")
+	ErrPremiumRateNumber  = errors.New("premium-rate numbers are not served")
+)
+
+// lineTypes names the line types reported by libphonenumber
+var lineTypes = map[libphonenumber.PhoneNumberType]string{
+	libphonenumber.FIXED_LINE:           "fixed_line",
+	libphonenumber.MOBILE:               "mobile",
+	libphonenumber.FIXED_LINE_OR_MOBILE: "fixed_line_or_mobile",
+	libphonenumber.TOLL_FREE:            "toll_free",
+	libphonenumber.PREMIUM_RATE:         "premium_rate",
+	libphonenumber.SHARED_COST:          "shared_cost",
+	libphonenumber.VOIP:                 "voip",
+	libphonenumber.PERSONAL_NUMBER:      "personal_number",
+	libphonenumber.PAGER:                "pager",
+	libphonenumber.UAN:                  "uan",
+	libphonenumber.VOICEMAIL:            "voicemail",
+}
+
+// PhoneParser parses phone numbers in national or international format and normalizes them
+// to E.164, using the phone number metadata compiled into the binary
+type PhoneParser struct {
+	region string // Region assumed for numbers without a country code; empty requires one
+}
+
+// NewPhoneParser creates a PhoneParser. defaultRegion is an ISO 3166-1 alpha-2 code such as
+// US or GB, used for numbers written without a country code; when empty, numbers must start with +
+func NewPhoneParser(defaultRegion string) (*PhoneParser, error) {
+	region := strings.ToUpper(defaultRegion)
+	if region != "" && libphonenumber.GetCountryCodeForRegion(region) == 0 {
+		return nil, fmt.Errorf("phone parser: unknown region %q", defaultRegion)
+	}
+	return &PhoneParser{region: region}, nil
+}
+
+// Parse returns the number in E.164 format together with its country and line type.
+// Numbers that cannot exist and premium-rate numbers are rejected
+func (p *PhoneParser) Parse(raw string) (string, *data.Phone, error) {
+	region := p.region
+	if region == "" {
+		region = libphonenumber.UNKNOWN_REGION
+	}
+	number, err := libphonenumber.Parse(raw, region)
+	if err != nil || !libphonenumber.IsValidNumber(number) {
+		return "", nil, ErrInvalidPhoneNumber
+	}
+
+	lineType := libphonenumber.GetNumberType(number)
+	if lineType == libphonenumber.PREMIUM_RATE {
+		return "", nil, ErrPremiumRateNumber
+	}
+	phone := &data.Phone{Country: libphonenumber.GetRegionCodeForNumber(number), LineType: "unknown"}
+	if name, ok := lineTypes[lineType]; ok {
+		phone.LineType = name
+	}
+	return libphonenumber.Format(number, libphonenumber.E164), phone, nil
+}
+
+// normalizePhone replaces the phone number of a recipient with its E.164 form and returns its
+// metadata; email recipients are left alone. It writes the error response and returns false
+// when the number is rejected. field is the JSON path of the recipient in the request
+func (app *Config) normalizePhone(c *gin.Context, field string, d *data.OTPData) (*data.Phone, bool) {
+	if d.DeliveryChannel() == data.ChannelEmail {
+		return nil, true
+	}
+	e164, phone, err := app.Phones.Parse(d.PhoneNumber)
+	switch {
+	case errors.Is(err, ErrPremiumRateNumber):
+		app.writeRequestError(c, invalidField(field, "premium_rate", "premium-rate numbers are not served"))
+		return nil, false
+	case err != nil:
+		app.writeRequestError(c, invalidField(field, "phone", "is not a valid phone number"))
+		return nil, false
+	}
+	d.PhoneNumber = e164
+	return phone, true
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"  // Used to inspect parse errors
+	"testing" // Go test framework
+)
+
+func TestNewPhoneParser(t *testing.T) {
+	tests := []struct {
+		region  string
+		wantErr bool
+	}{
+		{region: ""},
+		{region: "US"},
+		{region: "gb"},
+		{region: "ZZ", wantErr: true},
+		{region: "USA", wantErr: true},
+	}
+	for _, tt := range tests {
+		if _, err := NewPhoneParser(tt.region); (err != nil) != tt.wantErr {
+			t.Errorf("NewPhoneParser(%q): got %v, want error %v", tt.region, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPhoneParserParse(t *testing.T) {
+	tests := []struct {
+		name         string
+		region       string
+		raw          string
+		wantE164     string
+		wantCountry  string
+		wantLineType string
+		wantErr      error
+	}{
+		{name: "international", raw: "+14155552671", wantE164: "+14155552671", wantCountry: "US", wantLineType: "fixed_line_or_mobile"},
+		{name: "international with punctuation", raw: "+44 (0)7400 123-456", wantE164: "+447400123456", wantCountry: "GB", wantLineType: "mobile"},
+		{name: "national with a default region", region: "US", raw: "(415) 555-2671", wantE164: "+14155552671", wantCountry: "US", wantLineType: "fixed_line_or_mobile"},
+		{name: "international with another default region", region: "US", raw: "+447400123456", wantE164: "+447400123456", wantCountry: "GB", wantLineType: "mobile"},
+		{name: "national without a default region", raw: "(415) 555-2671", wantErr: ErrInvalidPhoneNumber},
+		{name: "too short", raw: "+1415555", wantErr: ErrInvalidPhoneNumber},
+		{name: "unassigned country code", raw: "+9991234567", wantErr: ErrInvalidPhoneNumber},
+		{name: "not a number", raw: "call me", wantErr: ErrInvalidPhoneNumber},
+		{name: "empty", raw: "", wantErr: ErrInvalidPhoneNumber},
+		{name: "premium rate", raw: "+19002345678", wantErr: ErrPremiumRateNumber},
+		{name: "national premium rate", region: "GB", raw: "09098790879", wantErr: ErrPremiumRateNumber},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPhoneParser(tt.region)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e164, phone, err := p.Parse(tt.raw)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("got %q %v, want %v", e164, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got %v", err)
+			}
+			if e164 != tt.wantE164 || phone.Country != tt.wantCountry || phone.LineType != tt.wantLineType {
+				t.Errorf("got %s %s %s, want %s %s %s", e164, phone.Country, phone.LineType, tt.wantE164, tt.wantCountry, tt.wantLineType)
+			}
+		})
+	}
+}
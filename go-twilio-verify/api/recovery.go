@@ -0,0 +1,321 @@
+package api
+
+import (
+	"context"       // Carries deadlines to the store
+	"crypto/hmac"   // Keyed hashing of stored codes
+	"crypto/rand"   // Code generation
+	"crypto/sha256" // Hash function of the HMAC
+	"encoding/hex"  // Encoding of code hashes
+	"encoding/json" // Serialization of code sets
+	"errors"        // Used to define recovery code errors
+	"math/big"      // Uniform choice of code characters
+	"net/http"      // HTTP status codes
+	"strconv"       // Used to build marker keys
+	"strings"       // Code normalization
+	"time"          // Creation times
+
+	"go-twilio-verify/data" // Request and response models
+
+	"github.com/gin-gonic/gin" // Gin framework for HTTP handling
+)
+
+// Defaults applied by NewRecoveryCodes for zero RecoveryOptions fields
+const defaultRecoveryCodeCount = 10
+
+// recoveryAlphabet leaves out characters that are easily confused when read back, such as
+// 0 and o or 1 and l
+const recoveryAlphabet = "23456789abcdefghjkmnpqrstuvwxyz"
+
+// recoveryCodeLength is the number of characters per code, shown as two groups of five
+const recoveryCodeLength = 10
+
+// Recovery code errors
+var (
+	ErrNoRecoveryCodes  = errors.New("no recovery codes generated for this user")
+	ErrRecoveryCodeUsed = errors.New("recovery code already used")
+)
+
+// RecoveryOptions configures RecoveryCodes
+type RecoveryOptions struct {
+	Count int // Codes per set (default 10)
+}
+
+// recoverySet is the state kept per user. Codes are only stored hashed
+type recoverySet struct {
+	ID        string    `json:"id"`         // Random ID, part of the keys marking used codes
+	Hashes    []string  `json:"hashes"`     // Keyed hashes of the codes, bound to the user ID
+	Used      []bool    `json:"used"`       // Whether the code at the same index was used
+	CreatedAt time.Time `json:"created_at"` // When the set was generated
+}
+
+// remaining returns the number of codes of the set not used yet
+func (s *recoverySet) remaining() int {
+	n := len(s.Hashes)
+	for _, used := range s.Used {
+		if used {
+			n--
+		}
+	}
+	return n
+}
+
+// RecoveryCodes issues sets of single-use recovery codes, for users who lost access to
+// their phone or authenticator. Generating a set invalidates the previous one
+type RecoveryCodes struct {
+	store   Store
+	hashKey []byte
+	opts    RecoveryOptions
+}
+
+// NewRecoveryCodes creates a RecoveryCodes keeping the code sets in store, hashed with
+// hashKey. Sets are stored without expiry, so the store must be durable for them to survive
+// restarts
+func NewRecoveryCodes(store Store, hashKey []byte, opts RecoveryOptions) (*RecoveryCodes, error) {
+	if len(hashKey) == 0 {
+		return nil, errors.New("recovery codes: hash key is required")
+	}
+	if opts.Count == 0 {
+		opts.Count = defaultRecoveryCodeCount
+	}
+	if opts.Count < 1 || opts.Count > 100 {
+		return nil, errors.New("recovery codes: count must be between 1 and 100")
+	}
+	return &RecoveryCodes{store: store, hashKey: hashKey, opts: opts}, nil
+}
+
+// Generate creates a new set of codes for the user, replacing any previous set, and returns
+// the codes. They cannot be retrieved again
+func (r *RecoveryCodes) Generate(ctx context.Context, userID string) (*data.RecoveryCodes, error) {
+	old, err := r.load(ctx, userID)
+	if err != nil && !errors.Is(err, ErrNoRecoveryCodes) {
+		return nil, err
+	}
+
+	set := recoverySet{ID: randomHex(8), Used: make([]bool, r.opts.Count), CreatedAt: time.Now().UTC()}
+	codes := make([]string, r.opts.Count)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+		set.Hashes = append(set.Hashes, r.hash(userID, code))
+	}
+	if err := r.save(ctx, userID, set); err != nil {
+		return nil, err
+	}
+
+	// The old codes no longer match the stored hashes; their markers are left over
+	if old != nil {
+		keys := make([]string, len(old.Hashes))
+		for i := range old.Hashes {
+			keys[i] = recoveryUsedKey(userID, old.ID, i)
+		}
+		if err := r.store.Delete(ctx, keys...); err != nil {
+			return nil, err
+		}
+	}
+	return &data.RecoveryCodes{UserID: userID, Codes: codes, CreatedAt: set.CreatedAt}, nil
+}
+
+// Use consumes one of the user's codes and returns how many are left. It returns
+// ErrInvalidCode for a code not in the current set and ErrRecoveryCodeUsed for a code
+// that was already used
+func (r *RecoveryCodes) Use(ctx context.Context, userID, code string) (int, error) {
+	set, err := r.load(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	hash := r.hash(userID, normalizeRecoveryCode(code))
+	matched := -1
+	for i, h := range set.Hashes {
+		if hmac.Equal([]byte(h), []byte(hash)) {
+			matched = i
+		}
+	}
+	if matched < 0 {
+		return 0, ErrInvalidCode
+	}
+
+	// The counter is what makes a code single-use, also under concurrent submissions; the
+	// flag in the set only keeps the count of remaining codes
+	uses, err := r.store.Incr(ctx, recoveryUsedKey(userID, set.ID, matched), 0)
+	if err != nil {
+		return 0, err
+	}
+	if uses > 1 {
+		return 0, ErrRecoveryCodeUsed
+	}
+
+	// Reload so a concurrent use of another code is not overwritten. A set generated in the
+	// meantime replaced the code, so it no longer lets the user in
+	setID := set.ID
+	set, err = r.load(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if set.ID != setID {
+		return 0, ErrInvalidCode
+	}
+	set.Used[matched] = true
+	if err := r.save(ctx, userID, *set); err != nil {
+		return 0, err
+	}
+	return set.remaining(), nil
+}
+
+// hash returns the keyed hash a code is stored as. The user ID is included so a hash
+// cannot be matched against the codes of other users
+func (r *RecoveryCodes) hash(userID, code string) string {
+	mac := hmac.New(sha256.New, r.hashKey)
+	mac.Write([]byte(userID + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// load returns the user's current set, or ErrNoRecoveryCodes
+func (r *RecoveryCodes) load(ctx context.Context, userID string) (*recoverySet, error) {
+	raw, err := r.store.Get(ctx, recoverySetKey(userID))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrNoRecoveryCodes
+	}
+	if err != nil {
+		return nil, err
+	}
+	var set recoverySet
+	if err := json.Unmarshal([]byte(raw), &set); err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+// save stores the user's set without expiry
+func (r *RecoveryCodes) save(ctx context.Context, userID string, set recoverySet) error {
+	raw, err := json.Marshal(set)
+	if err != nil {
+		return err
+	}
+	return r.store.Set(ctx, recoverySetKey(userID), string(raw), 0)
+}
+
+// newRecoveryCode returns a random code of recoveryCodeLength characters, without the dash
+func newRecoveryCode() (string, error) {
+	size := big.NewInt(int64(len(recoveryAlphabet)))
+	b := make([]byte, recoveryCodeLength)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", err
+		}
+		b[i] = recoveryAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// normalizeRecoveryCode lowercases a submitted code and drops the dashes and spaces users
+// type or paste with it
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
+
+// Store keys used by RecoveryCodes
+func recoverySetKey(userID string) string { return "recovery:set:" + userID }
+func recoveryUsedKey(userID, setID string, i int) string {
+	return "recovery:used:" + userID + ":" + setID + ":" + strconv.Itoa(i)
+}
+
+// recoveryGenerate handles the API endpoint generating a new set of recovery codes
+func (app *Config) recoveryGenerate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		audit := AuditEvent{Action: AuditRecoveryGenerate, Channel: data.FactorRecoveryCode}
+		defer app.recordCall(c, &audit)
+
+		var payload data.RecoveryUserData
+		if err := app.validateBody(c, &payload); err != nil {
+			app.writeRequestError(c, err)
+			return
+		}
+		audit.Recipient = payload.UserID
+
+		codes, err := app.Recovery.Generate(c.Request.Context(), payload.UserID)
+		if err != nil {
+			audit.Outcome = app.writeRecoveryError(c, err)
+			return
+		}
+		audit.Outcome = AuditOutcomeGenerated
+
+		// The codes must not end up in shared caches
+		c.Header("Cache-Control", "no-store")
+		app.writeJSON(c, http.StatusCreated, codes)
+	}
+}
+
+// recoveryVerify handles the API endpoint consuming a recovery code. The check runs behind
+// the brute-force guard, limited per user as there is no sent code to lock, and an accepted
+// code is answered with a signed token
+func (app *Config) recoveryVerify() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		audit := AuditEvent{Action: AuditRecoveryVerify, Channel: data.FactorRecoveryCode}
+		defer app.recordCall(c, &audit)
+
+		var payload data.RecoveryCodeData
+		if err := app.validateBody(c, &payload); err != nil {
+			app.writeRequestError(c, err)
+			return
+		}
+		audit.Recipient = payload.UserID
+
+		guardKey := data.FactorRecoveryCode + ":" + payload.UserID
+		if app.Guard != nil {
+			if err := app.Guard.Allow(ctx, guardKey, "", c.ClientIP()); err != nil {
+				app.writeThrottleError(c, err)
+				return
+			}
+		}
+
+		remaining, err := app.Recovery.Use(ctx, payload.UserID, payload.Code)
+		if errors.Is(err, ErrInvalidCode) && app.Guard != nil {
+			if _, gerr := app.Guard.RecordFailure(ctx, guardKey, "", c.ClientIP()); gerr != nil {
+				app.logger(c).Error("cannot record failed verification", "error", gerr)
+			}
+		}
+		if err != nil {
+			audit.Outcome = app.writeRecoveryError(c, err)
+			return
+		}
+		audit.Outcome = AuditOutcomeApproved
+
+		ctx = context.WithoutCancel(ctx)
+		if app.Guard != nil {
+			if err := app.Guard.RecordSuccess(ctx, guardKey, ""); err != nil {
+				app.logger(c).Error("cannot clear failed verifications", "error", err)
+			}
+		}
+		app.writeFactorApproval(c, data.FactorApproval{UserID: payload.UserID, Factor: data.FactorRecoveryCode, RemainingCodes: &remaining})
+	}
+}
+
+// writeRecoveryError responds to a failed recovery code call and returns its audit outcome
+func (app *Config) writeRecoveryError(c *gin.Context, err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidCode):
+		app.errorJSON(c, err)
+		return AuditOutcomeInvalidCode
+	case errors.Is(err, ErrRecoveryCodeUsed):
+		app.errorJSON(c, err)
+		return AuditOutcomeReplayed
+	case errors.Is(err, ErrNoRecoveryCodes):
+		app.errorJSON(c, err, http.StatusNotFound)
+		return AuditOutcomeNotFound
+	default:
+		app.logger(c).Error("recovery code check failed", "error", err)
+		app.errorJSON(c, errors.New("internal server error"), http.StatusInternalServerError)
+		return AuditOutcomeError
+	}
+}
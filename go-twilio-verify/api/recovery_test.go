@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"  // Test contexts
+	"errors"   // Used to inspect recovery code errors
+	"net/http" // HTTP methods and status codes
+	"strings"  // Code formatting
+	"sync"     // Concurrent uses
+	"testing"  // Go test framework
+	"time"     // Counter expiry
+
+	"go-twilio-verify/data" // Request bodies
+)
+
+// newTestRecovery returns a RecoveryCodes with a set of three codes for user u-42, and the codes
+func newTestRecovery(t *testing.T, store Store) (*RecoveryCodes, []string) {
+	t.Helper()
+	r, err := NewRecoveryCodes(store, []byte("recovery-key"), RecoveryOptions{Count: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := r.Generate(context.Background(), "u-42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, codes.Codes
+}
+
+func TestRecoveryCodesUse(t *testing.T) {
+	ctx := context.Background()
+	r, codes := newTestRecovery(t, NewMemoryStore())
+
+	if remaining, err := r.Use(ctx, "u-42", strings.ToUpper(strings.ReplaceAll(codes[0], "-", " "))); err != nil || remaining != 2 {
+		t.Fatalf("first use: got %d %v, want 2 codes left", remaining, err)
+	}
+	if _, err := r.Use(ctx, "u-42", codes[0]); !errors.Is(err, ErrRecoveryCodeUsed) {
+		t.Errorf("second use: got %v, want %v", err, ErrRecoveryCodeUsed)
+	}
+	if _, err := r.Use(ctx, "u-42", "aaaaa-aaaaa"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("wrong code: got %v, want %v", err, ErrInvalidCode)
+	}
+	if _, err := r.Use(ctx, "u-43", codes[1]); !errors.Is(err, ErrNoRecoveryCodes) {
+		t.Errorf("other user: got %v, want %v", err, ErrNoRecoveryCodes)
+	}
+	if remaining, err := r.Use(ctx, "u-42", codes[1]); err != nil || remaining != 1 {
+		t.Errorf("another code: got %d %v, want 1 code left", remaining, err)
+	}
+
+	// A new set replaces the old codes
+	if _, err := r.Generate(ctx, "u-42"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Use(ctx, "u-42", codes[2]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("code of a replaced set: got %v, want %v", err, ErrInvalidCode)
+	}
+}
+
+func TestRecoveryCodesConcurrentUse(t *testing.T) {
+	r, codes := newTestRecovery(t, NewMemoryStore())
+
+	const uses = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for i := 0; i < uses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.Use(context.Background(), "u-42", codes[0]); err == nil {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 1 {
+		t.Errorf("got %d accepted uses of one code, want 1", accepted)
+	}
+}
+
+// regeneratingStore generates a new set of codes the moment a code is claimed, as an
+// administrator could while the code is being checked
+type regeneratingStore struct {
+	Store
+	regenerate func()
+}
+
+func (s *regeneratingStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	n, err := s.Store.Incr(ctx, key, ttl)
+	if s.regenerate != nil {
+		regenerate := s.regenerate
+		s.regenerate = nil
+		regenerate()
+	}
+	return n, err
+}
+
+func TestRecoveryCodesUseDuringRegeneration(t *testing.T) {
+	store := &regeneratingStore{Store: NewMemoryStore()}
+	r, codes := newTestRecovery(t, store)
+	store.regenerate = func() {
+		if _, err := r.Generate(context.Background(), "u-42"); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err := r.Use(context.Background(), "u-42", codes[0]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("got %v, want %v", err, ErrInvalidCode)
+	}
+}
+
+func TestRecoveryVerifyLockout(t *testing.T) {
+	app, _ := newTestApp(t, SessionOptions{})
+	r, codes := newTestRecovery(t, NewMemoryStore())
+	app.Recovery = r
+	// The per-session limit is lower, but a factor without a sent code is only limited per user
+	app.Guard = NewVerifyGuard(NewMemoryStore(), nil, GuardOptions{MaxSessionFailures: 2, MaxRecipientFailures: 4})
+	app.Router.POST("/recovery-codes/verify", app.recoveryVerify())
+
+	for i := 1; i <= 4; i++ {
+		if status, resp := call(t, app, http.MethodPost, "/recovery-codes/verify", data.RecoveryCodeData{UserID: "u-42", Code: "aaaaa-aaaaa"}); status != http.StatusBadRequest {
+			t.Fatalf("wrong code %d: got %d %q, want %d", i, status, resp.Message, http.StatusBadRequest)
+		}
+	}
+	status, resp := call(t, app, http.MethodPost, "/recovery-codes/verify", data.RecoveryCodeData{UserID: "u-42", Code: codes[0]})
+	if status != http.StatusTooManyRequests {
+		t.Errorf("right code after the limit: got %d %q, want %d", status, resp.Message, http.StatusTooManyRequests)
+	}
+	if remaining, err := r.Use(context.Background(), "u-42", codes[0]); err != nil || remaining != 2 {
+		t.Errorf("code after the lockout: got %d %v, want it unused", remaining, err)
+	}
+}
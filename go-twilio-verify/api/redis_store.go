@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context" // Carries deadlines and cancellation to Redis
+	"errors"  // Used to translate redis.Nil into ErrNotFound
+	"time"    // Used for key expiry
+
+	"github.com/go-redis/redis/v8" // Redis client
+)
+
+// incrScript increments a counter and sets its expiry only when the increment created it,
+// so the window of a counter is not extended by later increments. A zero ttl sets none
+var incrScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 and tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return n
+`)
+
+// decrScript decrements an existing counter, not below zero. DECR keeps the expiry; a missing
+// key is left alone rather than created
+var decrScript = redis.NewScript(`
+local n = tonumber(redis.call("GET", KEYS[1]))
+if not n then
+	return 0
+end
+if n > 0 then
+	n = redis.call("DECR", KEYS[1])
+end
+return n
+`)
+
+// RedisStore is a Store backed by Redis, shared by every instance of the service
+type RedisStore struct {
+	client *redis.Client
+	prefix string // Prepended to every key so the service can share a Redis database
+}
+
+// NewRedisStore creates a RedisStore that namespaces its keys with prefix
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+// Get returns the value stored under key, or ErrNotFound
+func (s *RedisStore) Get(ctx context.Context, key string) (string, error) {
+	val, err := s.client.Get(ctx, s.prefix+key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNotFound
+	}
+	return val, err
+}
+
+// Set stores value under key for ttl
+func (s *RedisStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return s.client.Set(ctx, s.prefix+key, value, ttl).Err()
+}
+
+// SetNX stores value under key for ttl unless the key exists
+func (s *RedisStore) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, s.prefix+key, value, ttl).Result()
+}
+
+// Incr increments the counter under key, creating it with ttl when missing
+func (s *RedisStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return incrScript.Run(ctx, s.client, []string{s.prefix + key}, ttl.Milliseconds()).Int64()
+}
+
+// Decr decrements an existing counter under key, not below zero
+func (s *RedisStore) Decr(ctx context.Context, key string) (int64, error) {
+	return decrScript.Run(ctx, s.client, []string{s.prefix + key}).Int64()
+}
+
+// TTL returns how long the key has left to live, or ErrNotFound
+func (s *RedisStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(ctx, s.prefix+key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// go-redis reports the special replies -2 (no such key) and -1 (no expiry) unscaled
+	switch {
+	case ttl == -2:
+		return 0, ErrNotFound
+	case ttl < 0:
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// Delete removes the keys
+func (s *RedisStore) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = s.prefix + key
+	}
+	return s.client.Del(ctx, prefixed...).Err()
+}
+
+// Ping checks that Redis is reachable
+func (s *RedisStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
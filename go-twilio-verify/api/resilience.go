@@ -0,0 +1,358 @@
+package api
+
+import (
+	"context"       // Per-attempt deadlines and cancellation
+	"errors"        // Used to classify provider errors
+	"log/slog"      // Circuit state changes are logged
+	"math/rand"     // Retry jitter
+	"net"           // Network errors are transient
+	"net/http"      // Retry-After dates
+	"net/textproto" // SMTP reply codes
+	"strconv"       // Retry-After seconds
+	"strings"       // Retry-After parsing
+	"sync"          // Guards the circuit state
+	"time"          // Deadlines, backoff and open periods
+
+	"github.com/twilio/twilio-go/client" // Twilio API errors carry the HTTP status
+)
+
+// Defaults applied by NewResilientVerifier and NewResilientSender for zero ResilienceOptions fields
+const (
+	defaultProviderTimeout  = 5 * time.Second
+	defaultProviderAttempts = 3
+	defaultRetryBackoff     = 200 * time.Millisecond
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// ErrProviderUnavailable is returned without calling the provider while its circuit is open
+var ErrProviderUnavailable = errors.New("provider unavailable")
+
+// RateLimitError reports a provider response of 429 with a Retry-After header. The provider
+// refused the request without acting on it, so it may be repeated after RetryAfter
+type RateLimitError struct {
+	RetryAfter time.Duration // How long the provider asked to wait
+}
+
+func (e *RateLimitError) Error() string {
+	return "provider rate limit exceeded, retry after " + e.RetryAfter.String()
+}
+
+// parseRetryAfter reads a Retry-After header value, given either in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return max(at.Sub(now), 0), true
+}
+
+// ResilienceOptions configures how calls to an OTP provider are bounded, retried and cut off
+type ResilienceOptions struct {
+	Timeout          time.Duration // Deadline of each attempt (default 5s)
+	MaxAttempts      int           // Attempts per send, including the first; 1 disables retries (default 3)
+	RetryBackoff     time.Duration // Upper bound of the random wait before the first retry; doubles per retry (default 200ms)
+	BreakerThreshold int           // Consecutive transient failures that open the circuit (default 5)
+	BreakerCooldown  time.Duration // How long an open circuit fails fast before a trial call is let through (default 30s)
+	Logger           *slog.Logger  // Destination of circuit state changes; slog.Default() when nil
+}
+
+// withDefaults fills in zero fields
+func (o ResilienceOptions) withDefaults() ResilienceOptions {
+	if o.Timeout == 0 {
+		o.Timeout = defaultProviderTimeout
+	}
+	if o.MaxAttempts == 0 {
+		o.MaxAttempts = defaultProviderAttempts
+	}
+	if o.RetryBackoff == 0 {
+		o.RetryBackoff = defaultRetryBackoff
+	}
+	if o.BreakerThreshold == 0 {
+		o.BreakerThreshold = defaultBreakerThreshold
+	}
+	if o.BreakerCooldown == 0 {
+		o.BreakerCooldown = defaultBreakerCooldown
+	}
+	if o.Logger == nil {
+		o.Logger = slog.Default()
+	}
+	return o
+}
+
+// circuitBreaker stops calls to a provider after repeated transient failures. Once
+// BreakerCooldown has passed a single trial call is let through: success closes the
+// circuit, failure keeps it open for another cooldown
+type circuitBreaker struct {
+	name string
+	opts ResilienceOptions
+
+	mu        sync.Mutex
+	failures  int       // Consecutive transient failures
+	openUntil time.Time // Calls fail fast until then; zero when closed
+	probing   bool      // A trial call is in flight
+}
+
+// allow reports whether a call may go ahead
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record updates the circuit with the outcome of a call
+func (b *circuitBreaker) record(failed bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := !b.openUntil.IsZero()
+	b.probing = false
+
+	if !failed {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		if wasOpen {
+			b.opts.Logger.Info("provider recovered, circuit closed", "provider", b.name)
+		}
+		return
+	}
+
+	b.failures++
+	if wasOpen || b.failures >= b.opts.BreakerThreshold {
+		b.openUntil = now.Add(b.opts.BreakerCooldown)
+		if !wasOpen {
+			b.opts.Logger.Error("provider failing, circuit opened", "provider", b.name,
+				"failures", b.failures, "cooldown", b.opts.BreakerCooldown)
+		}
+	}
+}
+
+// abandon releases the trial call slot after a call whose outcome is unknown
+func (b *circuitBreaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// policy runs provider calls with deadlines, retries and a circuit breaker
+type policy struct {
+	opts    ResilienceOptions
+	breaker *circuitBreaker
+}
+
+// newPolicy creates the call policy of the named provider
+func newPolicy(name string, opts ResilienceOptions) *policy {
+	opts = opts.withDefaults()
+	return &policy{opts: opts, breaker: &circuitBreaker{name: name, opts: opts}}
+}
+
+// do calls fn until it succeeds, fails for good, runs out of attempts or ctx ends. Each
+// attempt gets its own deadline. Only failures that prove the provider never acted on the
+// request are retried, see retryDelay, so a call with side effects is not repeated
+func (p *policy) do(ctx context.Context, fn func(ctx context.Context) error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if !p.breaker.allow(time.Now()) {
+			if err != nil {
+				return err // Report the failure that opened the circuit
+			}
+			return ErrProviderUnavailable
+		}
+
+		err = p.attempt(ctx, fn)
+		if ctx.Err() != nil {
+			return err
+		}
+		wait, retry := retryDelay(err)
+		if !retry || attempt >= p.opts.MaxAttempts {
+			return err
+		}
+		if wait == 0 {
+			// Full jitter: wait a random time up to the exponentially growing backoff
+			backoff := p.opts.RetryBackoff << (attempt - 1)
+			wait = time.Duration(rand.Int63n(int64(backoff) + 1))
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return err // The provider asked for a longer wait than the request has left
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// once calls fn a single time, with the deadline and circuit breaker of do but without retries
+func (p *policy) once(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !p.breaker.allow(time.Now()) {
+		return ErrProviderUnavailable
+	}
+	return p.attempt(ctx, fn)
+}
+
+// attempt calls fn under its own deadline and records the outcome with the circuit breaker
+func (p *policy) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	attemptCtx, cancel := context.WithTimeout(ctx, p.opts.Timeout)
+	err := fn(attemptCtx)
+	cancel()
+
+	// The caller giving up says nothing about the provider
+	if ctx.Err() != nil {
+		p.breaker.abandon()
+		return ctx.Err()
+	}
+	p.breaker.record(err != nil && transientError(err), time.Now())
+	return err
+}
+
+// retryDelay reports whether a failed call may be repeated, and how long the provider asked
+// to wait first. Only failures that prove the provider never acted on the request qualify:
+// a connection that could not be established, and a 429 response with Retry-After.
+// Timeouts, dropped connections and 5xx responses may come after a message went out, so
+// repeating them could deliver it twice
+func retryDelay(err error) (time.Duration, bool) {
+	var rateErr *RateLimitError
+	if errors.As(err, &rateErr) {
+		return rateErr.RetryAfter, true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return 0, true
+	}
+	return 0, false
+}
+
+// transientError reports whether a provider error says the provider is struggling rather
+// than refusing the request: network errors, timeouts, rate limits, Twilio 5xx responses
+// and temporary SMTP failures. They count towards the circuit breaker
+func transientError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true // The attempt's own deadline
+	}
+	var rateErr *RateLimitError
+	if errors.As(err, &rateErr) {
+		return true
+	}
+	var twilioErr *client.TwilioRestError
+	if errors.As(err, &twilioErr) {
+		return twilioErr.Status >= 500 || twilioErr.Status == 429
+	}
+	var smtpErr *textproto.Error
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Code >= 400 && smtpErr.Code < 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// ResilientVerifier wraps a Verifier that calls a remote provider, such as TwilioVerifier,
+// bounding every call with a deadline, retrying sends that never reached the provider and
+// failing fast with ErrProviderUnavailable while the provider is down
+type ResilientVerifier struct {
+	next   Verifier
+	policy *policy
+}
+
+// NewResilientVerifier wraps next; name identifies the provider in logs
+func NewResilientVerifier(name string, next Verifier, opts ResilienceOptions) *ResilientVerifier {
+	return &ResilientVerifier{next: next, policy: newPolicy(name, opts)}
+}
+
+// Send sends a code through the wrapped Verifier
+func (v *ResilientVerifier) Send(ctx context.Context, channel, to, locale string) (string, error) {
+	var sid string
+	err := v.policy.do(ctx, func(ctx context.Context) error {
+		var err error
+		sid, err = v.next.Send(ctx, channel, to, locale)
+		return err
+	})
+	return sid, err
+}
+
+// Check checks a code through the wrapped Verifier. Checks are never retried: a check the
+// provider received counts as an attempt, and an approved one consumes the code
+func (v *ResilientVerifier) Check(ctx context.Context, to, code string) error {
+	return v.policy.once(ctx, func(ctx context.Context) error {
+		return v.next.Check(ctx, to, code)
+	})
+}
+
+// Ping checks the wrapped Verifier directly, so health checks neither retry nor trip the circuit
+func (v *ResilientVerifier) Ping(ctx context.Context) error {
+	return ping(ctx, v.next)
+}
+
+// ResilientSender wraps a MessageSender with the same deadlines, retries and circuit
+// breaker as ResilientVerifier. Status callbacks are passed on when the wrapped sender supports them
+type ResilientSender struct {
+	next   MessageSender
+	policy *policy
+}
+
+// NewResilientSender wraps next; name identifies the provider in logs
+func NewResilientSender(name string, next MessageSender, opts ResilienceOptions) *ResilientSender {
+	return &ResilientSender{next: next, policy: newPolicy(name, opts)}
+}
+
+// SendMessage delivers body through the wrapped sender
+func (s *ResilientSender) SendMessage(ctx context.Context, to, body string) (string, error) {
+	return s.SendTrackedMessage(ctx, to, body, "")
+}
+
+// SendTrackedMessage delivers body through the wrapped sender, requesting status callbacks
+// when it supports them
+func (s *ResilientSender) SendTrackedMessage(ctx context.Context, to, body, statusCallback string) (string, error) {
+	tracked, ok := s.next.(TrackedSender)
+	var id string
+	err := s.policy.do(ctx, func(ctx context.Context) error {
+		var err error
+		if ok && statusCallback != "" {
+			id, err = tracked.SendTrackedMessage(ctx, to, body, statusCallback)
+		} else {
+			id, err = s.next.SendMessage(ctx, to, body)
+		}
+		return err
+	})
+	return id, err
+}
+
+// Ping checks the wrapped sender directly, so health checks neither retry nor trip the circuit
+func (s *ResilientSender) Ping(ctx context.Context) error {
+	return ping(ctx, s.next)
+}
+
+// callWithContext runs a blocking provider call that cannot be canceled itself, returning
+// early with ctx's error when ctx ends first. The abandoned call finishes in the background,
+// bounded by the HTTP client's own timeout
+func callWithContext[T any](ctx context.Context, call func() (T, error)) (T, error) {
+	type result struct {
+		v   T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := call()
+		done <- result{v, err}
+	}()
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
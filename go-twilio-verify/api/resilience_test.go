@@ -0,0 +1,203 @@
+package api
+
+import (
+	"context"       // Deadlines and cancellation
+	"errors"        // Test errors
+	"fmt"           // Wrapped errors
+	"io"            // Discarded logs
+	"log/slog"      // Test logger
+	"net"           // Network errors
+	"net/textproto" // SMTP reply codes
+	"testing"       // Go test framework
+	"time"          // Retry-After and cooldowns
+
+	"github.com/twilio/twilio-go/client" // Twilio API errors
+)
+
+// timeoutError is a net.Error reporting a timeout
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var (
+	dialError = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	readError = &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantWait  time.Duration
+		wantRetry bool
+	}{
+		{name: "rate limited", err: &RateLimitError{RetryAfter: 2 * time.Second}, wantWait: 2 * time.Second, wantRetry: true},
+		{name: "wrapped rate limit", err: fmt.Errorf("send: %w", &RateLimitError{RetryAfter: time.Second}), wantWait: time.Second, wantRetry: true},
+		{name: "connection refused", err: dialError, wantRetry: true},
+		{name: "connection reset", err: readError},
+		{name: "timeout", err: context.DeadlineExceeded},
+		{name: "server error", err: &client.TwilioRestError{Status: 503}},
+		{name: "invalid number", err: &client.TwilioRestError{Status: 400, Code: 60200}},
+		{name: "other", err: errors.New("boom")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, retry := retryDelay(tt.err)
+			if wait != tt.wantWait || retry != tt.wantRetry {
+				t.Errorf("got %s %v, want %s %v", wait, retry, tt.wantWait, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "attempt deadline", err: context.DeadlineExceeded, want: true},
+		{name: "rate limited", err: &RateLimitError{RetryAfter: time.Second}, want: true},
+		{name: "twilio 500", err: &client.TwilioRestError{Status: 500}, want: true},
+		{name: "twilio 429", err: &client.TwilioRestError{Status: 429}, want: true},
+		{name: "twilio 400", err: &client.TwilioRestError{Status: 400}},
+		{name: "twilio 404", err: &client.TwilioRestError{Status: 404}},
+		{name: "smtp mailbox busy", err: &textproto.Error{Code: 450, Msg: "mailbox busy"}, want: true},
+		{name: "smtp no such user", err: &textproto.Error{Code: 550, Msg: "no such user"}},
+		{name: "dial", err: dialError, want: true},
+		{name: "net timeout", err: timeoutError{}, want: true},
+		{name: "wrapped net error", err: fmt.Errorf("send: %w", readError), want: true},
+		{name: "invalid code", err: ErrInvalidCode},
+		{name: "canceled by the client", err: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := transientError(tt.err); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{value: "", wantOK: false},
+		{value: "30", want: 30 * time.Second, wantOK: true},
+		{value: " 5 ", want: 5 * time.Second, wantOK: true},
+		{value: "-5", want: 0, wantOK: true},
+		{value: "Mon, 01 Jan 2024 12:01:30 GMT", want: 90 * time.Second, wantOK: true},
+		{value: "Mon, 01 Jan 2024 11:00:00 GMT", want: 0, wantOK: true},
+		{value: "soon", wantOK: false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRetryAfter(%q): got %s %v, want %s %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	b := &circuitBreaker{name: "test", opts: ResilienceOptions{
+		BreakerThreshold: 3,
+		BreakerCooldown:  time.Minute,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		b.record(true, now)
+	}
+	b.record(false, now)
+	b.record(true, now)
+	if !b.allow(now) {
+		t.Fatal("a success resets the failure count, so the circuit stays closed")
+	}
+
+	b.record(true, now)
+	b.record(true, now)
+	if b.allow(now) {
+		t.Fatal("three consecutive failures open the circuit")
+	}
+
+	later := now.Add(time.Minute)
+	if !b.allow(later) {
+		t.Fatal("after the cooldown a trial call is let through")
+	}
+	if b.allow(later) {
+		t.Fatal("only one trial call is let through at a time")
+	}
+	b.record(true, later)
+	if b.allow(later.Add(time.Second)) {
+		t.Fatal("a failed trial keeps the circuit open for another cooldown")
+	}
+
+	latest := later.Add(time.Minute)
+	if !b.allow(latest) {
+		t.Fatal("after the second cooldown a trial call is let through")
+	}
+	b.record(false, latest)
+	if !b.allow(latest) || !b.allow(latest) {
+		t.Fatal("a successful trial closes the circuit")
+	}
+}
+
+func TestPolicyRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		errs      []error // Outcome of each call in turn; nil after the last
+		wantCalls int
+		wantErr   error
+	}{
+		{name: "success", wantCalls: 1},
+		{name: "retried after a refused connection", errs: []error{dialError}, wantCalls: 2},
+		{name: "retried after a rate limit", errs: []error{&RateLimitError{RetryAfter: time.Millisecond}}, wantCalls: 2},
+		{name: "attempts used up", errs: []error{dialError, dialError, dialError, dialError}, wantCalls: 3, wantErr: dialError},
+		{name: "reset connection not repeated", errs: []error{readError}, wantCalls: 1, wantErr: readError},
+		{name: "refusal not repeated", errs: []error{ErrInvalidCode}, wantCalls: 1, wantErr: ErrInvalidCode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPolicy("test", ResilienceOptions{
+				RetryBackoff: time.Millisecond,
+				Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+			calls := 0
+			err := p.do(context.Background(), func(context.Context) error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if calls != tt.wantCalls || !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %d calls and %v, want %d and %v", calls, err, tt.wantCalls, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPolicyFailsFastWhileOpen(t *testing.T) {
+	p := newPolicy("test", ResilienceOptions{
+		MaxAttempts:      1,
+		BreakerThreshold: 2,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	failing := func(context.Context) error { return &client.TwilioRestError{Status: 503} }
+	for i := 0; i < 2; i++ {
+		_ = p.do(context.Background(), failing)
+	}
+
+	called := false
+	err := p.do(context.Background(), func(context.Context) error { called = true; return nil })
+	if called || !errors.Is(err, ErrProviderUnavailable) {
+		t.Errorf("got called %v and %v, want no call and %v", called, err, ErrProviderUnavailable)
+	}
+}
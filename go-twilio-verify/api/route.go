@@ -1,17 +1,147 @@
 package api
 
-import "github.com/gin-gonic/gin"
+import (
+	"log/slog" // Structured logger type
+	"slices"   // Used to look up enabled channels
+
+	"go-twilio-verify/data" // Channel names
+
+	"github.com/gin-gonic/gin"
+)
 
 // Config defines the configuration structure for the application, including the router
 type Config struct {
-	Router *gin.Engine // Gin engine for routing
+	Router     *gin.Engine        // Gin engine for routing
+	Logger     *slog.Logger       // Structured application logger; slog.Default() is used when nil
+	Verifier   Verifier           // Sends and checks OTPs (Twilio Verify, or FakeVerifier offline)
+	Channels   []string           // Delivery channels this deployment offers (data.Channel*); only SMS when empty
+	Throttle   *Throttler         // Limits how often codes are sent; nil disables throttling
+	Guard      *VerifyGuard       // Locks out brute-force attempts on code checks; nil disables the lockout
+	Sessions   *SessionStore      // Tracks every sent code; an in-memory store is used when nil
+	Tokens     *TokenIssuer       // Mints a signed token for every approved verification; nil disables tokens
+	Webhooks   *WebhookDispatcher // Notifies other services of verification events; nil disables webhooks
+	Templates  *Templates         // Offered message locales; only the built-in English when nil
+	Phones     *PhoneParser       // Parses and normalizes phone numbers; only international format is accepted when nil
+	Audit      *AuditTrail        // Records every send and verify call; nil disables the audit trail
+	Metrics    *Metrics           // Counts calls and serves them on /metrics; nil disables the endpoint
+	Health     *Health            // Dependencies reported by /healthz and /readyz; none are checked when nil
+	TOTP       *TOTPAuthenticator // Enrolls authenticator apps and verifies their codes; nil disables the routes
+	Recovery   *RecoveryCodes     // Issues and consumes single-use recovery codes; nil disables the routes
+	EmailLinks *EmailLinks        // Verifies email addresses by link or code; nil disables the routes
+
+	StatusCallbacks *StatusCallbacks // Accepts Twilio delivery status callbacks; nil disables the route
+
+	AdminToken string // Bearer token for the /admin endpoints, including TOTP enrollment and recovery code generation; empty disables them
+}
+
+// channelEnabled reports whether codes may be sent over the channel
+func (app *Config) channelEnabled(channel string) bool {
+	if len(app.Channels) == 0 {
+		return channel == data.ChannelSMS
+	}
+	return slices.Contains(app.Channels, channel)
 }
 
 // Routes sets up the API endpoints for the application
 func (app *Config) Routes() {
-	// Define a POST route for sending OTPs
-	app.Router.POST("/otp", app.sendSMS())
+	// Keep verification sessions in memory unless a shared store was configured
+	if app.Sessions == nil {
+		app.Sessions = NewSessionStore(NewMemoryStore(), SessionOptions{})
+	}
+	if app.Templates == nil {
+		app.Templates = NewTemplates()
+	}
+	if app.Phones == nil {
+		app.Phones, _ = NewPhoneParser("")
+	}
+	if app.Health == nil {
+		app.Health = NewHealth(0)
+	}
+
+	// Tag every request with an ID and write a structured access log line, and trace it
+	app.Router.Use(app.requestLogger(), app.traceRequests())
+
+	// Define the liveness and readiness probes
+	app.Router.GET("/healthz", app.healthz())
+	app.Router.GET("/readyz", app.readyz())
+
+	// Expose the metrics for Prometheus to scrape
+	if app.Metrics != nil {
+		app.Router.GET("/metrics", app.metrics())
+	}
+
+	// Define a POST route for sending OTPs, throttled per client IP
+	app.Router.POST("/otp", app.throttleIP(), app.sendSMS())
 
 	// Define a POST route for verifying OTPs
 	app.Router.POST("/verifyOTP", app.verifySMS())
+
+	// Define routes for confirming authenticator apps and verifying their codes; enrollment
+	// is an admin endpoint
+	if app.TOTP != nil {
+		app.Router.POST("/totp/confirm", app.totpConfirm())
+		app.Router.POST("/totp/verify", app.totpVerify())
+	}
+
+	// Define the route for using a recovery code; generating them is an admin endpoint
+	if app.Recovery != nil {
+		app.Router.POST("/recovery-codes/verify", app.recoveryVerify())
+	}
+
+	// Define routes for verifying email addresses by link or code, throttled per client IP
+	if app.EmailLinks != nil {
+		app.Router.POST("/email-verifications", app.throttleIP(), app.sendEmailLink())
+		app.Router.GET("/email-verifications/callback", app.emailLinkCallback())
+		app.Router.POST("/email-verifications/confirm", app.emailLinkConfirm())
+		app.Router.POST("/email-verifications/callback", app.verifyEmailCode())
+	}
+
+	// Define routes for looking up and canceling a verification by ID
+	app.Router.GET("/verifications/:id", app.getVerification())
+	app.Router.POST("/verifications/:id/cancel", app.cancelVerification())
+
+	// Publish the token signing keys so other services can validate tokens locally
+	if app.Tokens != nil {
+		app.Router.GET("/.well-known/jwks.json", app.jwks())
+	}
+
+	// Define the route Twilio reports message and call delivery to
+	if app.StatusCallbacks != nil {
+		app.Router.POST("/twilio/status", app.statusCallback())
+	}
+
+	// Define operator endpoints, only reachable with the admin token
+	if app.AdminToken != "" {
+		admin := app.Router.Group("/admin", app.adminAuth())
+		if app.TOTP != nil {
+			admin.POST("/totp/enroll", app.totpEnroll())
+		}
+		if app.Recovery != nil {
+			admin.POST("/recovery-codes", app.recoveryGenerate())
+		}
+		if app.Webhooks != nil {
+			admin.GET("/webhooks/deliveries", app.webhookDeliveries())
+		}
+		if app.EmailLinks != nil {
+			admin.GET("/email-verifications/status", app.emailStatus())
+		}
+		if app.Audit != nil {
+			admin.GET("/audit", app.auditRecords())
+			admin.GET("/audit/export", app.auditExport())
+		}
+	}
+}
+
+// publish sends a verification event to the webhooks, if configured
+func (app *Config) publish(eventType string, sess *Session, status, reason string) {
+	if app.Webhooks == nil {
+		return
+	}
+	app.Webhooks.Publish(eventType, WebhookEventData{
+		VerificationID: sess.ID,
+		Channel:        sess.Channel,
+		To:             sess.To,
+		Status:         status,
+		Reason:         reason,
+	})
 }
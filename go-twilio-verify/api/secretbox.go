@@ -0,0 +1,60 @@
+package api
+
+import (
+	"crypto/aes"      // Block cipher of the AEAD
+	"crypto/cipher"   // GCM mode
+	"crypto/rand"     // Nonce generation
+	"encoding/base64" // Encoding of sealed values
+	"errors"          // Used to report bad keys and values
+)
+
+// ErrUnsealable is returned by SecretBox.Open when a value was not sealed by the box's key
+// and context, or was tampered with
+var ErrUnsealable = errors.New("secret box: cannot open sealed value")
+
+// SecretBox encrypts secrets kept at rest, such as TOTP secrets, with AES-256-GCM. Every
+// value is bound to a context string, e.g. the user it belongs to, so a sealed value
+// copied to another record does not open
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox creates a SecretBox from a 32-byte key
+func NewSecretBox(key []byte) (*SecretBox, error) {
+	if len(key) != 32 {
+		return nil, errors.New("secret box: key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretBox{aead: aead}, nil
+}
+
+// Seal encrypts plaintext bound to context and returns the nonce and ciphertext, base64-encoded
+func (b *SecretBox) Seal(plaintext []byte, context string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, plaintext, []byte(context))
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value returned by Seal with the same context
+func (b *SecretBox) Open(sealed, context string) ([]byte, error) {
+	raw, err := base64.RawStdEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < b.aead.NonceSize() {
+		return nil, ErrUnsealable
+	}
+	nonce, ciphertext := raw[:b.aead.NonceSize()], raw[b.aead.NonceSize():]
+	plaintext, err := b.aead.Open(nil, nonce, ciphertext, []byte(context))
+	if err != nil {
+		return nil, ErrUnsealable
+	}
+	return plaintext, nil
+}
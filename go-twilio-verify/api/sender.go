@@ -0,0 +1,188 @@
+package api
+
+import (
+	"context"      // Bounds the API calls
+	"encoding/xml" // Used to escape text spoken in TwiML
+	"net/http"     // HTTP client of the Twilio SDK
+	"strings"      // Used to build the spoken message
+	"time"         // Request timeout
+
+	"github.com/twilio/twilio-go"                          // Twilio SDK for interacting with Twilio services
+	"github.com/twilio/twilio-go/client"                   // Twilio SDK HTTP client
+	twilioApi "github.com/twilio/twilio-go/rest/api/v2010" // Programmable Messaging and Voice API package from Twilio
+)
+
+// MessageSender delivers a text message over one channel. The self-hosted OTP
+// engine uses one sender per channel to deliver codes, so any gateway can be plugged in
+type MessageSender interface {
+	// SendMessage delivers body to the recipient and returns the provider's message ID
+	SendMessage(ctx context.Context, to, body string) (string, error)
+}
+
+// TrackedSender is a MessageSender that can have the provider report the delivery status of a
+// message to a callback URL
+type TrackedSender interface {
+	// SendTrackedMessage delivers body like SendMessage and has delivery updates posted to statusCallback
+	SendTrackedMessage(ctx context.Context, to, body, statusCallback string) (string, error)
+}
+
+// newTwilioClient creates a Twilio REST client for the account
+func newTwilioClient(accountSID, authToken string) *twilio.RestClient {
+	base := &client.Client{
+		Credentials: client.NewCredentials(accountSID, authToken),
+		// Like the SDK's default client, but reporting rate limits with their Retry-After
+		HTTPClient: &http.Client{
+			Transport: rateLimitTransport{next: http.DefaultTransport},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+			Timeout: 10 * time.Second,
+		},
+	}
+	base.SetAccountSid(accountSID)
+	return twilio.NewRestClientWithParams(twilio.ClientParams{Client: base})
+}
+
+// rateLimitTransport turns 429 responses carrying Retry-After into a *RateLimitError, since
+// the errors of the Twilio SDK do not expose response headers
+type rateLimitTransport struct {
+	next http.RoundTripper
+}
+
+func (t rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil || res.StatusCode != http.StatusTooManyRequests {
+		return res, err
+	}
+	wait, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return res, nil
+	}
+	res.Body.Close()
+	return nil, &RateLimitError{RetryAfter: wait}
+}
+
+// TwilioMessagingSender is a MessageSender that sends SMS or WhatsApp messages through Twilio Programmable Messaging
+type TwilioMessagingSender struct {
+	client     *twilio.RestClient // Authenticated Twilio REST client
+	accountSID string             // Account the client authenticates as
+	from       string             // Twilio phone number messages are sent from
+	prefix     string             // Address prefix selecting the channel: "" for SMS, "whatsapp:" for WhatsApp
+}
+
+// NewTwilioSMSSender creates an SMS sender for the given Twilio account and sending number
+func NewTwilioSMSSender(accountSID, authToken, from string) *TwilioMessagingSender {
+	return &TwilioMessagingSender{client: newTwilioClient(accountSID, authToken), accountSID: accountSID, from: from}
+}
+
+// NewTwilioWhatsAppSender creates a WhatsApp sender for the given Twilio account and WhatsApp-enabled sending number
+func NewTwilioWhatsAppSender(accountSID, authToken, from string) *TwilioMessagingSender {
+	return &TwilioMessagingSender{client: newTwilioClient(accountSID, authToken), accountSID: accountSID, from: from, prefix: "whatsapp:"}
+}
+
+// SendMessage sends body to the phone number
+func (s *TwilioMessagingSender) SendMessage(ctx context.Context, to, body string) (string, error) {
+	return s.SendTrackedMessage(ctx, to, body, "")
+}
+
+// SendTrackedMessage sends body to the phone number, with Twilio posting status updates to
+// statusCallback unless it is empty
+func (s *TwilioMessagingSender) SendTrackedMessage(ctx context.Context, to, body, statusCallback string) (string, error) {
+	params := &twilioApi.CreateMessageParams{}
+	params.SetTo(s.prefix + to)
+	params.SetFrom(s.prefix + s.from)
+	params.SetBody(body)
+	if statusCallback != "" {
+		params.SetStatusCallback(statusCallback)
+	}
+
+	resp, err := callWithContext(ctx, func() (*twilioApi.ApiV2010Message, error) {
+		return s.client.Api.CreateMessage(params)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return *resp.Sid, nil // Return the message SID on success
+}
+
+// Ping checks that Twilio is reachable and accepts the credentials
+func (s *TwilioMessagingSender) Ping(ctx context.Context) error {
+	return pingTwilioAccount(ctx, s.client, s.accountSID)
+}
+
+// TwilioVoiceSender is a MessageSender that places a Twilio voice call reading the message out
+type TwilioVoiceSender struct {
+	client     *twilio.RestClient // Authenticated Twilio REST client
+	accountSID string             // Account the client authenticates as
+	from       string             // Twilio phone number calls are placed from
+}
+
+// NewTwilioVoiceSender creates a voice sender for the given Twilio account and calling number
+func NewTwilioVoiceSender(accountSID, authToken, from string) *TwilioVoiceSender {
+	return &TwilioVoiceSender{client: newTwilioClient(accountSID, authToken), accountSID: accountSID, from: from}
+}
+
+// SendMessage calls the phone number and reads body out twice
+func (s *TwilioVoiceSender) SendMessage(ctx context.Context, to, body string) (string, error) {
+	return s.SendTrackedMessage(ctx, to, body, "")
+}
+
+// SendTrackedMessage calls the phone number and reads body out twice, with Twilio posting the
+// outcome of the call to statusCallback unless it is empty
+func (s *TwilioVoiceSender) SendTrackedMessage(ctx context.Context, to, body, statusCallback string) (string, error) {
+	var say strings.Builder
+	say.WriteString("<Response>")
+	for i := 0; i < 2; i++ {
+		say.WriteString(`<Say>`)
+		_ = xml.EscapeText(&say, []byte(spellDigits(body)))
+		say.WriteString(`</Say><Pause length="1"/>`)
+	}
+	say.WriteString("</Response>")
+
+	params := &twilioApi.CreateCallParams{}
+	params.SetTo(to)
+	params.SetFrom(s.from)
+	params.SetTwiml(say.String())
+	if statusCallback != "" {
+		params.SetStatusCallback(statusCallback)
+	}
+
+	resp, err := callWithContext(ctx, func() (*twilioApi.ApiV2010Call, error) {
+		return s.client.Api.CreateCall(params)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return *resp.Sid, nil // Return the call SID on success
+}
+
+// Ping checks that Twilio is reachable and accepts the credentials
+func (s *TwilioVoiceSender) Ping(ctx context.Context) error {
+	return pingTwilioAccount(ctx, s.client, s.accountSID)
+}
+
+// pingTwilioAccount fetches the account, which fails when Twilio is unreachable or rejects the credentials
+func pingTwilioAccount(ctx context.Context, client *twilio.RestClient, accountSID string) error {
+	_, err := callWithContext(ctx, func() (*twilioApi.ApiV2010Account, error) {
+		return client.Api.FetchAccount(accountSID)
+	})
+	return err
+}
+
+// spellDigits separates consecutive digits so text-to-speech reads a code
+// digit by digit ("1, 2, 3") instead of as a number ("one hundred twenty-three")
+func spellDigits(s string) string {
+	var b strings.Builder
+	prevDigit := false
+	for _, r := range s {
+		isDigit := r >= '0' && r <= '9'
+		if isDigit && prevDigit {
+			b.WriteString(", ")
+		}
+		b.WriteRune(r)
+		prevDigit = isDigit
+	}
+	return b.String()
+}
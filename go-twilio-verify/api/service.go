@@ -1,27 +1,44 @@
 package api
 
 import (
-	"errors" // Used to handle error messages
+	"context" // Bounds the API calls
 
 	"github.com/twilio/twilio-go"                          // Twilio SDK for interacting with Twilio services
 	twilioApi "github.com/twilio/twilio-go/rest/verify/v2" // Specific Verify API package from Twilio
 )
 
-// Initialize Twilio client with account credentials
-var client *twilio.RestClient = twilio.NewRestClientWithParams(twilio.ClientParams{
-	Username: envACCOUNTSID(), // Twilio Account SID from environment variables
-	Password: envAUTHTOKEN(),  // Twilio Auth Token from environment variables
-})
+// TwilioVerifier is a Verifier backed by the Twilio Verify API
+type TwilioVerifier struct {
+	client     *twilio.RestClient // Authenticated Twilio REST client
+	serviceSID string             // SID of the Verify service codes are sent from
 
-// twilioSendOTP sends an OTP to the provided phone number via SMS
-func (app *Config) twilioSendOTP(phoneNumber string) (string, error) {
+	appName string // Optional name shown in messages instead of the Verify service's friendly name
+}
+
+// NewTwilioVerifier creates a Verifier for the given Twilio account and Verify service. A
+// non-empty appName replaces the service's friendly name in messages
+func NewTwilioVerifier(accountSID, authToken, serviceSID, appName string) *TwilioVerifier {
+	return &TwilioVerifier{client: newTwilioClient(accountSID, authToken), serviceSID: serviceSID, appName: appName}
+}
+
+// Send sends an OTP to the recipient over the channel. Twilio Verify names its
+// channels like data.Channel*, so the channel is passed through unchanged
+func (v *TwilioVerifier) Send(ctx context.Context, channel, to, locale string) (string, error) {
 	// Set up parameters for the verification request
 	params := &twilioApi.CreateVerificationParams{}
-	params.SetTo(phoneNumber) // Set the recipient phone number
-	params.SetChannel("sms")  // Specify SMS as the delivery channel
+	params.SetTo(to)           // Set the recipient phone number or email address
+	params.SetChannel(channel) // Specify the delivery channel
+	if locale != "" {
+		params.SetLocale(locale) // Twilio words the message from its own template for the locale
+	}
+	if v.appName != "" {
+		params.SetCustomFriendlyName(v.appName) // Name shown in the message instead of the service's
+	}
 
 	// Make a request to Twilio's Verify API to create a verification
-	resp, err := client.VerifyV2.CreateVerification(envSERVICESID(), params)
+	resp, err := callWithContext(ctx, func() (*twilioApi.VerifyV2Verification, error) {
+		return v.client.VerifyV2.CreateVerification(v.serviceSID, params)
+	})
 	if err != nil {
 		return "", err // Return an error if the API call fails
 	}
@@ -29,23 +46,34 @@ func (app *Config) twilioSendOTP(phoneNumber string) (string, error) {
 	return *resp.Sid, nil // Return the verification SID on success
 }
 
-// twilioVerifyOTP verifies the OTP sent to the provided phone number
-func (app *Config) twilioVerifyOTP(phoneNumber string, code string) error {
+// Check verifies the OTP sent to the recipient
+func (v *TwilioVerifier) Check(ctx context.Context, to, code string) error {
 	// Set up parameters for the verification check
 	params := &twilioApi.CreateVerificationCheckParams{}
-	params.SetTo(phoneNumber) // Set the recipient phone number
-	params.SetCode(code)      // Set the OTP code to verify
+	params.SetTo(to)     // Set the recipient phone number or email address
+	params.SetCode(code) // Set the OTP code to verify
 
 	// Make a request to Twilio's Verify API to check the OTP
-	resp, err := client.VerifyV2.CreateVerificationCheck(envSERVICESID(), params)
+	resp, err := callWithContext(ctx, func() (*twilioApi.VerifyV2VerificationCheck, error) {
+		return v.client.VerifyV2.CreateVerificationCheck(v.serviceSID, params)
+	})
 	if err != nil {
 		return err // Return an error if the API call fails
 	}
 
 	// Twilio Verify API returns a status. If it's not "approved", the OTP is invalid.
 	if *resp.Status != "approved" {
-		return errors.New("not a valid code") // Return an error for invalid OTP
+		return ErrInvalidCode // Return an error for invalid OTP
 	}
 
 	return nil // Return nil if the OTP is verified successfully
 }
+
+// Ping fetches the Verify service, which fails when Twilio is unreachable or rejects the
+// credentials; no verification is created
+func (v *TwilioVerifier) Ping(ctx context.Context) error {
+	_, err := callWithContext(ctx, func() (*twilioApi.VerifyV2Service, error) {
+		return v.client.VerifyV2.FetchService(v.serviceSID)
+	})
+	return err
+}
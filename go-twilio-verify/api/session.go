@@ -0,0 +1,214 @@
+package api
+
+import (
+	"context"       // Carries deadlines to the store
+	"encoding/json" // Serialization of stored sessions
+	"errors"        // Used to define session errors
+	"time"          // Used for expiry and retention
+
+	"go-twilio-verify/data" // Verification statuses and response model
+)
+
+// Defaults applied by NewSessionStore for zero SessionOptions fields
+const (
+	defaultSessionCodeTTL   = 10 * time.Minute
+	defaultSessionRetention = 24 * time.Hour
+)
+
+// ErrSessionNotFound is returned when no verification session exists for an ID or recipient
+var ErrSessionNotFound = errors.New("verification not found")
+
+// ErrSessionNotPending is returned when a session left the pending status before it could be approved or canceled
+var ErrSessionNotPending = errors.New("verification is no longer pending")
+
+// SessionOptions configures a SessionStore
+type SessionOptions struct {
+	CodeTTL   time.Duration // How long a sent code can be checked; should match the provider's (default 10m)
+	Retention time.Duration // How long sessions are kept so their status can be looked up (default 24h)
+}
+
+// Session is the server-side state of one sent code
+type Session struct {
+	ID        string    `json:"id"`         // Verification ID returned by the Verifier
+	Channel   string    `json:"channel"`    // Channel the code was delivered over
+	To        string    `json:"to"`         // Recipient phone number or email address
+	Status    string    `json:"status"`     // One of the data.Status* constants
+	CreatedAt time.Time `json:"created_at"` // When the code was sent
+	ExpiresAt time.Time `json:"expires_at"` // When the code stops being accepted
+
+	Delivery *data.Delivery `json:"delivery,omitempty"` // Latest delivery status reported by the provider
+	Phone    *data.Phone    `json:"phone,omitempty"`    // Country and line type of a phone recipient
+}
+
+// CurrentStatus returns the session status, reporting a pending session past its expiry as expired
+func (s *Session) CurrentStatus(now time.Time) string {
+	if s.Status == data.StatusPending && !now.Before(s.ExpiresAt) {
+		return data.StatusExpired
+	}
+	return s.Status
+}
+
+// DeliveryFailed reports whether the provider said the code never reached the recipient
+func (s *Session) DeliveryFailed() bool {
+	return s.Delivery != nil && deliveryFailed(s.Delivery.Status)
+}
+
+// View returns the session as shown to clients, without the recipient
+func (s *Session) View(now time.Time) data.Verification {
+	status := s.CurrentStatus(now)
+	return data.Verification{
+		ID:        s.ID,
+		Channel:   s.Channel,
+		Status:    status,
+		CreatedAt: s.CreatedAt,
+		ExpiresAt: s.ExpiresAt,
+		Delivery:  s.Delivery,
+		CanResend: status == data.StatusPending && s.DeliveryFailed(),
+		Phone:     s.Phone,
+	}
+}
+
+// SessionStore keeps a verification session for every code sent, binding a check to the
+// send it answers. Only the latest session of a recipient can be pending: starting a new
+// one cancels the previous one. A session leaves the pending status once: of concurrent
+// approvals and cancellations only the first takes effect
+type SessionStore struct {
+	store Store
+	opts  SessionOptions
+}
+
+// NewSessionStore creates a SessionStore keeping its sessions in store
+func NewSessionStore(store Store, opts SessionOptions) *SessionStore {
+	if opts.CodeTTL == 0 {
+		opts.CodeTTL = defaultSessionCodeTTL
+	}
+	if opts.Retention == 0 {
+		opts.Retention = defaultSessionRetention
+	}
+	return &SessionStore{store: store, opts: opts}
+}
+
+// Start records a pending session for a code just sent, canceling the recipient's previous pending
+// session. phone is nil for email recipients
+func (s *SessionStore) Start(ctx context.Context, id, channel, to string, phone *data.Phone) (*Session, error) {
+	if prev, err := s.Current(ctx, to); err == nil && prev.Status == data.StatusPending && prev.ID != id {
+		if err := s.settle(ctx, prev, data.StatusCanceled); err != nil && !errors.Is(err, ErrSessionNotPending) {
+			return nil, err
+		}
+	} else if err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	sess := &Session{
+		ID:        id,
+		Channel:   channel,
+		To:        to,
+		Status:    data.StatusPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.opts.CodeTTL),
+		Phone:     phone,
+	}
+	if err := s.save(ctx, sess); err != nil {
+		return nil, err
+	}
+	return sess, s.store.Set(ctx, sessionRecipientKey(to), id, s.opts.Retention)
+}
+
+// Get returns the session with the ID, or ErrSessionNotFound
+func (s *SessionStore) Get(ctx context.Context, id string) (*Session, error) {
+	raw, err := s.store.Get(ctx, sessionKey(id))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sess Session
+	if err := json.Unmarshal([]byte(raw), &sess); err != nil {
+		return nil, err
+	}
+
+	// The settled marker is written first, so it is authoritative over a status not yet saved
+	// or overwritten by a concurrent update of the delivery status
+	if sess.Status == data.StatusPending {
+		status, err := s.store.Get(ctx, sessionSettledKey(id))
+		if err == nil {
+			sess.Status = status
+		} else if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+	}
+	return &sess, nil
+}
+
+// Current returns the latest session of the recipient, or ErrSessionNotFound
+func (s *SessionStore) Current(ctx context.Context, to string) (*Session, error) {
+	id, err := s.store.Get(ctx, sessionRecipientKey(to))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, id)
+}
+
+// Approve marks the pending session approved. It returns ErrSessionNotPending when the session
+// was approved or canceled in the meantime, e.g. by a concurrent check of the same code
+func (s *SessionStore) Approve(ctx context.Context, sess *Session) error {
+	return s.settle(ctx, sess, data.StatusApproved)
+}
+
+// Cancel marks the pending session canceled. It returns ErrSessionNotPending when the session
+// was approved or canceled in the meantime
+func (s *SessionStore) Cancel(ctx context.Context, sess *Session) error {
+	return s.settle(ctx, sess, data.StatusCanceled)
+}
+
+// RecordDelivery stores a delivery status reported for the session. Callbacks can arrive out
+// of order, so a final status is never replaced by an intermediate one
+func (s *SessionStore) RecordDelivery(ctx context.Context, sess *Session, status, errorCode string) error {
+	if sess.Delivery != nil && deliveryFinal(sess.Delivery.Status) && !deliveryFinal(status) {
+		return nil
+	}
+	sess.Delivery = &data.Delivery{Status: status, ErrorCode: errorCode, UpdatedAt: time.Now().UTC()}
+	return s.save(ctx, sess)
+}
+
+// settle moves the pending session to status. The move is claimed by setting the session's
+// settled marker, which only succeeds while none exists, so it is a compare-and-set of the
+// pending status; losing the claim returns ErrSessionNotPending
+func (s *SessionStore) settle(ctx context.Context, sess *Session, status string) error {
+	ttl := time.Until(sess.CreatedAt.Add(s.opts.Retention))
+	if ttl <= 0 {
+		return ErrSessionNotPending
+	}
+	claimed, err := s.store.SetNX(ctx, sessionSettledKey(sess.ID), status, ttl)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrSessionNotPending
+	}
+	sess.Status = status
+	return s.save(ctx, sess)
+}
+
+// save stores the session until its retention period, counted from creation, ends
+func (s *SessionStore) save(ctx context.Context, sess *Session) error {
+	raw, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(sess.CreatedAt.Add(s.opts.Retention))
+	if ttl <= 0 {
+		return s.store.Delete(ctx, sessionKey(sess.ID))
+	}
+	return s.store.Set(ctx, sessionKey(sess.ID), string(raw), ttl)
+}
+
+// Store keys used by SessionStore
+func sessionKey(id string) string          { return "session:" + id }
+func sessionSettledKey(id string) string   { return "session:settled:" + id }
+func sessionRecipientKey(to string) string { return "session:recipient:" + to }
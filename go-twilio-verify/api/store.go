@@ -0,0 +1,216 @@
+package api
+
+import (
+	"context" // Carries deadlines and cancellation to the backing store
+	"errors"  // Used to define the not-found sentinel error
+	"strconv" // Used to parse counters stored as strings
+	"sync"    // Guards the in-memory map against concurrent requests
+	"time"    // Used for key expiry
+)
+
+// ErrNotFound is returned by Store.Get when the key does not exist or has expired
+var ErrNotFound = errors.New("key not found")
+
+// Store is the key-value store the self-hosted OTP engine keeps its state in.
+// Short-lived state is written with a TTL so it expires on its own
+type Store interface {
+	// Get returns the value stored under key, or ErrNotFound
+	Get(ctx context.Context, key string) (string, error)
+
+	// Set stores value under key for ttl, replacing any previous value. A zero ttl keeps
+	// the key until it is deleted
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+
+	// SetNX stores value under key for ttl only when the key does not exist, and reports
+	// whether it did. Check and write are one atomic step
+	SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+
+	// Incr atomically increments the counter under key and returns the new value.
+	// A counter created by Incr expires after ttl, or never for a zero ttl; incrementing
+	// does not extend it
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+
+	// Decr atomically decrements an existing counter under key, not below zero, and returns
+	// the new value. A missing key stays missing and yields 0; the expiry is kept
+	Decr(ctx context.Context, key string) (int64, error)
+
+	// TTL returns how long the key has left to live, 0 for a key without expiry, or ErrNotFound
+	TTL(ctx context.Context, key string) (time.Duration, error)
+
+	// Delete removes the keys, ignoring any that do not exist
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// memoryEntry is a value held by MemoryStore together with its expiry time
+type memoryEntry struct {
+	value   string
+	expires time.Time // Zero for an entry without expiry
+}
+
+// expired reports whether the entry has expired by now
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// memorySweepInterval is how often MemoryStore drops expired entries
+const memorySweepInterval = time.Minute
+
+// MemoryStore is a Store kept in process memory. State is lost on restart and
+// is not shared between instances, so use RedisStore when running more than one
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]memoryEntry), lastSweep: time.Now()}
+}
+
+// Get returns the value stored under key, or ErrNotFound
+func (s *MemoryStore) Get(_ context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.lookup(key, time.Now())
+	if !ok {
+		return "", ErrNotFound
+	}
+	return e.value, nil
+}
+
+// Set stores value under key for ttl
+func (s *MemoryStore) Set(_ context.Context, key, value string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+	e := memoryEntry{value: value}
+	if ttl != 0 {
+		e.expires = now.Add(ttl)
+	}
+	s.entries[key] = e
+	return nil
+}
+
+// SetNX stores value under key for ttl unless the key exists
+func (s *MemoryStore) SetNX(_ context.Context, key, value string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+	if _, ok := s.lookup(key, now); ok {
+		return false, nil
+	}
+	e := memoryEntry{value: value}
+	if ttl != 0 {
+		e.expires = now.Add(ttl)
+	}
+	s.entries[key] = e
+	return true, nil
+}
+
+// Incr increments the counter under key, creating it with ttl when missing
+func (s *MemoryStore) Incr(_ context.Context, key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	e, ok := s.lookup(key, now)
+	if !ok {
+		e = memoryEntry{value: "0"}
+		if ttl != 0 {
+			e.expires = now.Add(ttl)
+		}
+	}
+	n, err := strconv.ParseInt(e.value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	n++
+	e.value = strconv.FormatInt(n, 10)
+	s.entries[key] = e
+
+	return n, nil
+}
+
+// Decr decrements an existing counter under key, not below zero
+func (s *MemoryStore) Decr(_ context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.lookup(key, time.Now())
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(e.value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		n--
+	}
+	e.value = strconv.FormatInt(n, 10)
+	s.entries[key] = e
+
+	return n, nil
+}
+
+// TTL returns how long the key has left to live, or ErrNotFound
+func (s *MemoryStore) TTL(_ context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	e, ok := s.lookup(key, now)
+	if !ok {
+		return 0, ErrNotFound
+	}
+	if e.expires.IsZero() {
+		return 0, nil
+	}
+	return e.expires.Sub(now), nil
+}
+
+// Delete removes the keys
+func (s *MemoryStore) Delete(_ context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+// lookup returns the live entry under key, dropping it if it has expired. s.mu must be held
+func (s *MemoryStore) lookup(key string, now time.Time) (memoryEntry, bool) {
+	e, ok := s.entries[key]
+	if !ok {
+		return memoryEntry{}, false
+	}
+	if e.expired(now) {
+		delete(s.entries, key)
+		return memoryEntry{}, false
+	}
+	return e, true
+}
+
+// sweep drops every expired entry at most once per memorySweepInterval,
+// so keys that are never read again do not accumulate. s.mu must be held
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < memorySweepInterval {
+		return
+	}
+	for key, e := range s.entries {
+		if e.expired(now) {
+			delete(s.entries, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"errors"        // Used to reject empty templates
+	"fmt"           // Used to annotate template errors
+	"os"            // Used to read the template directory
+	"path/filepath" // Used to find template files
+	"slices"        // Used to validate channel names
+	"strings"       // Used to split template file names
+	"text/template" // Message templates
+
+	"go-twilio-verify/data" // Channel names
+
+	"golang.org/x/text/language" // Locale parsing and Accept-Language matching
+)
+
+// defaultMessage is the built-in English template used when no template directory is configured
+const defaultMessage = `Your {{if .AppName}}{{.AppName}} {{end}}verification code is: {{.Code}}`
+
+// TemplateData is what message templates can refer to
+type TemplateData struct {
+	AppName    string // {{.AppName}}: the OTP_APP_NAME setting, possibly empty
+	Code       string // {{.Code}}: the code
+	CodeLength int    // {{.CodeLength}}: number of digits in the code
+	TTLMinutes int    // {{.TTLMinutes}}: minutes until the code expires
+}
+
+// Templates holds the message templates per locale, with optional per-channel variants,
+// and picks the best locale for a request
+type Templates struct {
+	locales   []language.Tag                // Offered locales, the default first
+	matcher   language.Matcher              // Matches requested locales against locales
+	templates map[string]*template.Template // Keyed by "<locale>" or "<locale>.<channel>"
+}
+
+// NewTemplates returns the built-in templates: English only
+func NewTemplates() *Templates {
+	t := &Templates{templates: map[string]*template.Template{}}
+	_ = t.add(language.English, "", "en", defaultMessage)
+	t.matcher = language.NewMatcher(t.locales)
+	return t
+}
+
+// LoadTemplates reads the message templates in dir. A file named <locale>.tmpl holds the
+// message of that locale (a BCP 47 tag such as en, fr or pt-BR); <locale>.<channel>.tmpl
+// overrides it for one channel, e.g. de.call.tmpl for voice calls. Templates use Go's
+// text/template syntax with the fields of TemplateData. defaultLocale must have a
+// template; it is used when none of the requested locales is offered. The built-in
+// English template is used for en unless the directory provides one
+func LoadTemplates(dir, defaultLocale string) (*Templates, error) {
+	fallback, err := language.Parse(defaultLocale)
+	if err != nil {
+		return nil, fmt.Errorf("templates: invalid default locale %q: %w", defaultLocale, err)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("templates: no *.tmpl files in %s", dir)
+	}
+
+	// The default locale is offered first, so it wins when nothing else matches
+	t := &Templates{templates: map[string]*template.Template{}, locales: []language.Tag{fallback}}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".tmpl")
+		localeName, channel, _ := strings.Cut(name, ".")
+		locale, err := language.Parse(localeName)
+		if err != nil {
+			return nil, fmt.Errorf("templates: %s: invalid locale %q", path, localeName)
+		}
+		if channel != "" && !slices.Contains([]string{data.ChannelSMS, data.ChannelCall, data.ChannelEmail, data.ChannelWhatsApp}, channel) {
+			return nil, fmt.Errorf("templates: %s: unknown channel %q", path, channel)
+		}
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.add(locale, channel, name, strings.TrimRight(string(text), "\r\n")); err != nil {
+			return nil, fmt.Errorf("templates: %s: %w", path, err)
+		}
+	}
+
+	if t.templates[fallback.String()] == nil {
+		if fallback != language.English {
+			return nil, fmt.Errorf("templates: no %s.tmpl for the default locale", fallback)
+		}
+		_ = t.add(language.English, "", "en", defaultMessage)
+	}
+	t.matcher = language.NewMatcher(t.locales)
+	return t, nil
+}
+
+// add parses a template and offers its locale. The template is rendered once with sample
+// data so mistakes such as unknown fields fail at startup
+func (t *Templates) add(locale language.Tag, channel, name, text string) error {
+	if strings.TrimSpace(text) == "" {
+		return errors.New("template is empty")
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return err
+	}
+	if err := tmpl.Execute(&strings.Builder{}, TemplateData{AppName: "Acme", Code: "123456", CodeLength: 6, TTLMinutes: 10}); err != nil {
+		return err
+	}
+	if !slices.Contains(t.locales, locale) {
+		t.locales = append(t.locales, locale)
+	}
+	key := locale.String()
+	if channel != "" {
+		key += "." + channel
+	}
+	t.templates[key] = tmpl
+	return nil
+}
+
+// Match returns the offered locale that best serves a request: the explicit locale when
+// given, otherwise the Accept-Language header. The default locale is returned when nothing matches
+func (t *Templates) Match(explicit, acceptLanguage string) string {
+	var requested []language.Tag
+	if explicit != "" {
+		if tag, err := language.Parse(explicit); err == nil {
+			requested = []language.Tag{tag}
+		}
+	} else {
+		requested, _, _ = language.ParseAcceptLanguage(acceptLanguage)
+	}
+	_, i, confidence := t.matcher.Match(requested...)
+	if confidence == language.No {
+		i = 0
+	}
+	return t.locales[i].String()
+}
+
+// Render renders the message for the locale and channel, falling back to the locale's
+// generic template and then to the default locale
+func (t *Templates) Render(locale, channel string, d TemplateData) (string, error) {
+	fallback := t.locales[0].String()
+	for _, key := range []string{locale + "." + channel, locale, fallback + "." + channel, fallback} {
+		if tmpl := t.templates[key]; tmpl != nil {
+			var b strings.Builder
+			if err := tmpl.Execute(&b, d); err != nil {
+				return "", err
+			}
+			return b.String(), nil
+		}
+	}
+	return "", fmt.Errorf("templates: no template for %s", locale)
+}
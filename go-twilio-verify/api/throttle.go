@@ -0,0 +1,211 @@
+package api
+
+import (
+	"context"  // Carries deadlines to the store
+	"errors"   // Used to inspect store errors
+	"math"     // Used to round Retry-After up to whole seconds
+	"net/http" // Provides HTTP status codes
+	"strconv"  // Used to format the Retry-After header
+	"strings"  // Used to match number prefixes
+	"time"     // Used for windows and cooldowns
+
+	"github.com/gin-gonic/gin" // Gin framework for HTTP handling
+)
+
+// Defaults applied by NewThrottler for zero ThrottleOptions fields
+const (
+	defaultCooldown        = 30 * time.Second
+	defaultMaxCooldown     = time.Hour
+	defaultPhoneDailyLimit = 10
+	defaultIPDailyLimit    = 50
+)
+
+// throttleWindow is the period the daily caps are counted over, starting at the first send
+const throttleWindow = 24 * time.Hour
+
+// ErrDestinationNotAllowed is returned when a phone number's country prefix is not allowed
+var ErrDestinationNotAllowed = errors.New("destination is not allowed")
+
+// ThrottleOptions configures a Throttler
+type ThrottleOptions struct {
+	Cooldown        time.Duration // Wait before the first resend to a recipient; doubles with each further send (default 30s)
+	MaxCooldown     time.Duration // Upper bound of the growing cooldown (default 1h)
+	PhoneDailyLimit int           // Codes per recipient per 24 hours (default 10)
+	IPDailyLimit    int           // Send requests per client IP per 24 hours (default 50)
+	AllowedPrefixes []string      // When set, only phone numbers starting with one of these (e.g. "+1", "+44") are served
+	DeniedPrefixes  []string      // Phone numbers starting with one of these are refused, e.g. premium-rate ranges
+}
+
+// ThrottleError is returned when a request is throttled
+type ThrottleError struct {
+	Reason     string        // Which limit was hit: "cooldown", "recipient_daily_limit" or "ip_daily_limit"
+	RetryAfter time.Duration // How long until the request may be retried
+}
+
+// Error implements the error interface
+func (e *ThrottleError) Error() string { return "too many requests: " + e.Reason }
+
+// Throttler limits how often codes are sent, to contain abuse such as SMS pumping fraud:
+// a cooldown between sends to the same recipient that grows exponentially with every send,
+// daily caps per recipient and per client IP, and country-prefix allow and deny lists
+type Throttler struct {
+	store Store
+	opts  ThrottleOptions
+}
+
+// NewThrottler creates a Throttler keeping its counters in store
+func NewThrottler(store Store, opts ThrottleOptions) *Throttler {
+	if opts.Cooldown == 0 {
+		opts.Cooldown = defaultCooldown
+	}
+	if opts.MaxCooldown == 0 {
+		opts.MaxCooldown = defaultMaxCooldown
+	}
+	if opts.PhoneDailyLimit == 0 {
+		opts.PhoneDailyLimit = defaultPhoneDailyLimit
+	}
+	if opts.IPDailyLimit == 0 {
+		opts.IPDailyLimit = defaultIPDailyLimit
+	}
+	return &Throttler{store: store, opts: opts}
+}
+
+// AllowDestination checks a phone number against the prefix allow and deny lists
+func (t *Throttler) AllowDestination(phoneNumber string) error {
+	for _, p := range t.opts.DeniedPrefixes {
+		if strings.HasPrefix(phoneNumber, p) {
+			return ErrDestinationNotAllowed
+		}
+	}
+	if len(t.opts.AllowedPrefixes) == 0 {
+		return nil
+	}
+	for _, p := range t.opts.AllowedPrefixes {
+		if strings.HasPrefix(phoneNumber, p) {
+			return nil
+		}
+	}
+	return ErrDestinationNotAllowed
+}
+
+// AllowIP counts a send request from the client IP against its daily cap
+func (t *Throttler) AllowIP(ctx context.Context, ip string) error {
+	_, err := t.count(ctx, "throttle:ip:"+ip, t.opts.IPDailyLimit, "ip_daily_limit")
+	return err
+}
+
+// AllowSend checks the cooldown and daily cap of the recipient and, when the send is
+// allowed, starts the next cooldown. The n-th send within the window is followed by a
+// cooldown of Cooldown * 2^(n-1), capped at MaxCooldown
+func (t *Throttler) AllowSend(ctx context.Context, to string) error {
+	cooldownKey := "throttle:cooldown:" + to
+
+	// Reserve the cooldown before anything else, so of concurrent sends to the recipient
+	// only one gets through
+	reserved, err := t.store.SetNX(ctx, cooldownKey, "1", t.opts.Cooldown)
+	if err != nil {
+		return err
+	}
+	if !reserved {
+		ttl, err := t.store.TTL(ctx, cooldownKey)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if ttl <= 0 {
+			ttl = t.opts.Cooldown
+		}
+		return &ThrottleError{Reason: "cooldown", RetryAfter: ttl}
+	}
+
+	n, err := t.count(ctx, "throttle:recipient:"+to, t.opts.PhoneDailyLimit, "recipient_daily_limit")
+	if err != nil {
+		// Nothing is sent, so release the reservation
+		_ = t.store.Delete(context.WithoutCancel(ctx), cooldownKey)
+		return err
+	}
+
+	// Later sends within the window wait longer than the reserved base cooldown
+	if d := t.cooldown(int(n)); d > t.opts.Cooldown {
+		return t.store.Set(ctx, cooldownKey, "1", d)
+	}
+	return nil
+}
+
+// ClearCooldown lifts the resend cooldown of the recipient, e.g. after the provider reported the
+// last code undelivered. Daily caps still apply
+func (t *Throttler) ClearCooldown(ctx context.Context, to string) error {
+	return t.store.Delete(ctx, "throttle:cooldown:"+to)
+}
+
+// ReleaseSend gives back what AllowSend took for a send that never went out: the cooldown
+// and one count of the recipient's daily cap
+func (t *Throttler) ReleaseSend(ctx context.Context, to string) error {
+	if err := t.ClearCooldown(ctx, to); err != nil {
+		return err
+	}
+	_, err := t.store.Decr(ctx, "throttle:recipient:"+to)
+	return err
+}
+
+// cooldown returns the wait after the n-th send within the window
+func (t *Throttler) cooldown(n int) time.Duration {
+	d := t.opts.Cooldown
+	for i := 1; i < n && d < t.opts.MaxCooldown; i++ {
+		d *= 2
+	}
+	return min(d, t.opts.MaxCooldown)
+}
+
+// count increments the counter under key and returns the new count, failing once it
+// exceeds limit within the window
+func (t *Throttler) count(ctx context.Context, key string, limit int, reason string) (int64, error) {
+	n, err := t.store.Incr(ctx, key, throttleWindow)
+	if err != nil {
+		return 0, err
+	}
+	if n <= int64(limit) {
+		return n, nil
+	}
+
+	retryAfter, err := t.store.TTL(ctx, key)
+	if err != nil || retryAfter <= 0 {
+		retryAfter = throttleWindow
+	}
+	return n, &ThrottleError{Reason: reason, RetryAfter: retryAfter}
+}
+
+// throttleIP is middleware counting each request against the client IP's daily cap
+func (app *Config) throttleIP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if app.Throttle == nil {
+			return
+		}
+		if err := app.Throttle.AllowIP(c.Request.Context(), c.ClientIP()); err != nil {
+			app.writeThrottleError(c, err)
+			c.Abort()
+		}
+	}
+}
+
+// writeThrottleError responds to a throttled request with 429 and Retry-After.
+// Store failures are reported as 503 so abuse is not let through unchecked
+func (app *Config) writeThrottleError(c *gin.Context, err error) {
+	var terr *ThrottleError
+	if !errors.As(err, &terr) {
+		app.logger(c).Error("cannot check rate limits", "error", err)
+		app.errorJSON(c, errors.New("service unavailable"), http.StatusServiceUnavailable)
+		return
+	}
+
+	seconds := int(math.Ceil(terr.RetryAfter.Seconds()))
+	app.logger(c).Warn("request throttled", "reason", terr.Reason, "retry_after_seconds", seconds)
+	if app.Metrics != nil {
+		app.Metrics.observeRateLimited(terr.Reason)
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, jsonResponse{
+		Status:  http.StatusTooManyRequests,
+		Message: "too many requests",
+		Data:    gin.H{"reason": terr.Reason, "retryAfter": seconds},
+	})
+}
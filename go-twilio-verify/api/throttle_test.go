@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"  // Test contexts
+	"errors"   // Used to inspect throttle errors
+	"io"       // Discarded logs
+	"log/slog" // Test logger
+	"net"      // Dial errors
+	"net/http" // HTTP methods and status codes
+	"testing"  // Go test framework
+	"time"     // Cooldowns
+
+	"go-twilio-verify/data" // Request bodies
+
+	"github.com/gin-gonic/gin" // Gin framework for HTTP handling
+)
+
+// throttleReason returns the reason of a ThrottleError, or "" when err is nil
+func throttleReason(t *testing.T, err error) string {
+	t.Helper()
+	if err == nil {
+		return ""
+	}
+	var terr *ThrottleError
+	if !errors.As(err, &terr) {
+		t.Fatalf("got %v, want a ThrottleError", err)
+	}
+	return terr.Reason
+}
+
+func TestThrottlerAllowSend(t *testing.T) {
+	tests := []struct {
+		name string
+		opts ThrottleOptions
+		// steps are run in order: "send" calls AllowSend, "clear" lifts the cooldown and
+		// "release" hands a send back
+		steps      []string
+		wantReason string // Reason of the last step; empty when it is allowed
+	}{
+		{name: "first send", steps: []string{"send"}},
+		{name: "resend within cooldown", steps: []string{"send", "send"}, wantReason: "cooldown"},
+		{name: "resend after cooldown cleared", steps: []string{"send", "clear", "send"}},
+		{
+			name:       "daily cap",
+			opts:       ThrottleOptions{PhoneDailyLimit: 2},
+			steps:      []string{"send", "clear", "send", "clear", "send"},
+			wantReason: "recipient_daily_limit",
+		},
+		{
+			name:       "capped send does not start a cooldown",
+			opts:       ThrottleOptions{PhoneDailyLimit: 1},
+			steps:      []string{"send", "clear", "send", "send"},
+			wantReason: "recipient_daily_limit",
+		},
+		{
+			name:  "released send is not counted",
+			opts:  ThrottleOptions{PhoneDailyLimit: 1},
+			steps: []string{"send", "release", "send"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			th := NewThrottler(NewMemoryStore(), tt.opts)
+			var err error
+			for _, step := range tt.steps {
+				switch step {
+				case "send":
+					err = th.AllowSend(ctx, testPhone)
+				case "clear":
+					err = th.ClearCooldown(ctx, testPhone)
+				case "release":
+					err = th.ReleaseSend(ctx, testPhone)
+				}
+			}
+			if got := throttleReason(t, err); got != tt.wantReason {
+				t.Errorf("got reason %q, want %q", got, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestThrottlerCooldownBackoff(t *testing.T) {
+	th := NewThrottler(NewMemoryStore(), ThrottleOptions{Cooldown: 30 * time.Second, MaxCooldown: time.Hour})
+	tests := []struct {
+		send int
+		want time.Duration
+	}{
+		{send: 1, want: 30 * time.Second},
+		{send: 2, want: time.Minute},
+		{send: 3, want: 2 * time.Minute},
+		{send: 7, want: 32 * time.Minute},
+		{send: 8, want: time.Hour},
+		{send: 50, want: time.Hour},
+	}
+	for _, tt := range tests {
+		if got := th.cooldown(tt.send); got != tt.want {
+			t.Errorf("cooldown after send %d: got %s, want %s", tt.send, got, tt.want)
+		}
+	}
+
+	// The grown cooldown is what the next request is told to wait
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_ = th.ClearCooldown(ctx, testPhone)
+		if err := th.AllowSend(ctx, testPhone); err != nil {
+			t.Fatalf("send %d: %v", i+1, err)
+		}
+	}
+	var terr *ThrottleError
+	if err := th.AllowSend(ctx, testPhone); !errors.As(err, &terr) || terr.RetryAfter <= time.Minute {
+		t.Errorf("resend after three sends: got %v, want a cooldown over 1m", err)
+	}
+}
+
+func TestThrottlerAllowIP(t *testing.T) {
+	ctx := context.Background()
+	th := NewThrottler(NewMemoryStore(), ThrottleOptions{IPDailyLimit: 2})
+	for i, want := range []string{"", "", "ip_daily_limit"} {
+		if got := throttleReason(t, th.AllowIP(ctx, "192.0.2.1")); got != want {
+			t.Errorf("request %d: got reason %q, want %q", i+1, got, want)
+		}
+	}
+	if err := th.AllowIP(ctx, "192.0.2.2"); err != nil {
+		t.Errorf("other IP: %v", err)
+	}
+}
+
+func TestThrottlerAllowDestination(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    ThrottleOptions
+		phone   string
+		allowed bool
+	}{
+		{name: "no lists", phone: testPhone, allowed: true},
+		{name: "allowed prefix", opts: ThrottleOptions{AllowedPrefixes: []string{"+44", "+1"}}, phone: testPhone, allowed: true},
+		{name: "not an allowed prefix", opts: ThrottleOptions{AllowedPrefixes: []string{"+44"}}, phone: testPhone},
+		{name: "denied prefix", opts: ThrottleOptions{DeniedPrefixes: []string{"+1415"}}, phone: testPhone},
+		{name: "denied wins over allowed", opts: ThrottleOptions{AllowedPrefixes: []string{"+1"}, DeniedPrefixes: []string{"+1415"}}, phone: testPhone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewThrottler(NewMemoryStore(), tt.opts).AllowDestination(tt.phone)
+			if allowed := err == nil; allowed != tt.allowed {
+				t.Errorf("got %v, want allowed %v", err, tt.allowed)
+			}
+		})
+	}
+}
+
+// failingVerifier is a Verifier whose sends fail with err
+type failingVerifier struct{ err error }
+
+func (v failingVerifier) Send(context.Context, string, string, string) (string, error) {
+	return "", v.err
+}
+
+func (v failingVerifier) Check(context.Context, string, string) error { return ErrInvalidCode }
+
+func TestSendReleasesThrottleWhenNothingSent(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantResend int // Status of an immediate second send
+	}{
+		{name: "circuit open", err: ErrProviderUnavailable, wantResend: http.StatusServiceUnavailable},
+		{name: "provider unreachable", err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, wantResend: http.StatusServiceUnavailable},
+		{name: "timeout may have sent", err: context.DeadlineExceeded, wantResend: http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			app := &Config{
+				Router:   gin.New(),
+				Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+				Verifier: failingVerifier{err: tt.err},
+				Throttle: NewThrottler(NewMemoryStore(), ThrottleOptions{}),
+			}
+			app.Routes()
+
+			body := data.OTPData{PhoneNumber: testPhone}
+			if status, resp := call(t, app, http.MethodPost, "/otp", body); status != http.StatusServiceUnavailable {
+				t.Fatalf("send: got %d %q, want %d", status, resp.Message, http.StatusServiceUnavailable)
+			}
+			if status, resp := call(t, app, http.MethodPost, "/otp", body); status != tt.wantResend {
+				t.Errorf("resend: got %d %q, want %d", status, resp.Message, tt.wantResend)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package api
+
+import (
+	"crypto"          // Generic signer and public key types
+	"crypto/ecdsa"    // ES256/ES384/ES512 keys
+	"crypto/ed25519"  // EdDSA keys
+	"crypto/elliptic" // Curve parameters for EC keys
+	"crypto/rsa"      // RS256 keys
+	"crypto/sha256"   // Key thumbprints
+	"crypto/x509"     // PEM key parsing
+	"encoding/base64" // JWK member encoding
+	"encoding/json"   // Canonical JWK for thumbprints
+	"encoding/pem"    // PEM decoding
+	"errors"          // Used to report unsupported keys
+	"fmt"             // Used to format errors
+	"math/big"        // RSA exponent encoding
+	"time"            // Token lifetimes
+
+	"go-twilio-verify/data" // Channel names
+
+	"github.com/golang-jwt/jwt/v5" // JWT signing
+)
+
+// defaultTokenTTL is the lifetime of a token when TokenOptions.TTL is zero
+const defaultTokenTTL = 5 * time.Minute
+
+// TokenOptions configures a TokenIssuer
+type TokenOptions struct {
+	KeyID      string             // Key ID put in the token header and JWKS; the RFC 7638 thumbprint when empty
+	Issuer     string             // "iss" claim; omitted when empty
+	Audience   []string           // "aud" claim; omitted when empty
+	TTL        time.Duration      // Token lifetime (default 5m)
+	PublicKeys []crypto.PublicKey // Extra keys published in the JWKS, e.g. the previous key during rotation
+}
+
+// VerificationClaims are the claims of a token minted after a successful verification
+type VerificationClaims struct {
+	jwt.RegisteredClaims
+	PhoneNumber string           `json:"phone_number,omitempty"` // Verified phone number, for phone channels
+	Email       string           `json:"email,omitempty"`        // Verified email address, for the email channel
+	Channel     string           `json:"channel"`                // Channel the code was delivered over, or the factor, e.g. totp
+	VerifiedAt  *jwt.NumericDate `json:"verified_at"`            // When the code was approved
+}
+
+// TokenIssuer mints short-lived signed JWTs proving that a recipient was verified.
+// Other services validate them locally against the keys published by JWKS
+type TokenIssuer struct {
+	key    crypto.Signer
+	method jwt.SigningMethod
+	kid    string
+	opts   TokenOptions
+	jwks   JWKSet
+}
+
+// NewTokenIssuer creates a TokenIssuer signing with key, which must be an RSA (RS256),
+// ECDSA P-256/P-384/P-521 (ES256/ES384/ES512) or Ed25519 (EdDSA) private key
+func NewTokenIssuer(key crypto.Signer, opts TokenOptions) (*TokenIssuer, error) {
+	method, err := signingMethod(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	if opts.TTL == 0 {
+		opts.TTL = defaultTokenTTL
+	}
+
+	signing, err := newJWK(key.Public(), opts.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	t := &TokenIssuer{key: key, method: method, kid: signing.Kid, opts: opts}
+	t.jwks.Keys = append(t.jwks.Keys, signing)
+	for _, pub := range opts.PublicKeys {
+		jwk, err := newJWK(pub, "")
+		if err != nil {
+			return nil, err
+		}
+		t.jwks.Keys = append(t.jwks.Keys, jwk)
+	}
+	return t, nil
+}
+
+// Issue mints a token for an approved verification and returns it with its expiry
+func (t *TokenIssuer) Issue(sess *Session, verifiedAt time.Time) (string, time.Time, error) {
+	expires := verifiedAt.Add(t.opts.TTL)
+	claims := VerificationClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    t.opts.Issuer,
+			Subject:   sess.To,
+			Audience:  t.opts.Audience,
+			IssuedAt:  jwt.NewNumericDate(verifiedAt),
+			NotBefore: jwt.NewNumericDate(verifiedAt),
+			ExpiresAt: jwt.NewNumericDate(expires),
+			ID:        sess.ID,
+		},
+		Channel:    sess.Channel,
+		VerifiedAt: jwt.NewNumericDate(verifiedAt),
+	}
+	switch sess.Channel {
+	case data.ChannelEmail:
+		claims.Email = sess.To
+	case data.FactorTOTP, data.FactorRecoveryCode:
+		// The user ID is the caller's own, so it is namespaced to never pass for a verified
+		// address, such as a user ID that looks like a phone number
+		claims.Subject = userSubject(sess.To)
+	default:
+		claims.PhoneNumber = sess.To
+	}
+
+	token := jwt.NewWithClaims(t.method, claims)
+	token.Header["kid"] = t.kid
+	signed, err := token.SignedString(t.key)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expires, nil
+}
+
+// userSubject returns the token subject of a user ID
+func userSubject(userID string) string {
+	return "user:" + userID
+}
+
+// JWKS returns the public keys tokens can be validated with
+func (t *TokenIssuer) JWKS() JWKSet {
+	return t.jwks
+}
+
+// JWKSet is a JSON Web Key Set (RFC 7517)
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK is a public JSON Web Key
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"` // EC and OKP keys
+	X   string `json:"x,omitempty"`   // EC and OKP keys
+	Y   string `json:"y,omitempty"`   // EC keys
+	N   string `json:"n,omitempty"`   // RSA keys
+	E   string `json:"e,omitempty"`   // RSA keys
+}
+
+// newJWK describes a public key as a JWK, deriving the key ID from its thumbprint when kid is empty
+func newJWK(pub crypto.PublicKey, kid string) (JWK, error) {
+	method, err := signingMethod(pub)
+	if err != nil {
+		return JWK{}, err
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	jwk := JWK{Use: "sig", Alg: method.Alg()}
+	var members any // Required members in lexicographic order, for the RFC 7638 thumbprint
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty, jwk.N, jwk.E = "RSA", b64(k.N.Bytes()), b64(big.NewInt(int64(k.E)).Bytes())
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk.Kty, jwk.Crv = "EC", k.Curve.Params().Name
+		jwk.X, jwk.Y = b64(k.X.FillBytes(make([]byte, size))), b64(k.Y.FillBytes(make([]byte, size)))
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	case ed25519.PublicKey:
+		jwk.Kty, jwk.Crv, jwk.X = "OKP", "Ed25519", b64(k)
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+
+	if kid == "" {
+		canonical, err := json.Marshal(members)
+		if err != nil {
+			return JWK{}, err
+		}
+		sum := sha256.Sum256(canonical)
+		kid = b64(sum[:])
+	}
+	jwk.Kid = kid
+	return jwk, nil
+}
+
+// signingMethod returns the JWT algorithm used with a public key
+func signingMethod(pub crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("token key: RSA keys must be at least 2048 bits")
+		}
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, nil
+		case elliptic.P521():
+			return jwt.SigningMethodES512, nil
+		}
+		return nil, fmt.Errorf("token key: unsupported curve %s", k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("token key: unsupported key type %T", pub)
+	}
+}
+
+// ParsePrivateKeyPEM parses a PKCS #8, PKCS #1 (RSA) or SEC 1 (EC) private key in PEM form
+func ParsePrivateKeyPEM(pemData []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New("token key: no PEM block found")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("token key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("token key: unsupported key type %T", key)
+	}
+	return signer, nil
+}
+
+// ParsePublicKeyPEM parses a PKIX public key in PEM form
+func ParsePublicKeyPEM(pemData []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New("token key: no PEM block found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("token key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"crypto"          // Generic signer and public key types
+	"crypto/ecdsa"    // EC test keys
+	"crypto/ed25519"  // Ed25519 test keys
+	"crypto/elliptic" // Curves
+	"crypto/rand"     // Key generation
+	"crypto/rsa"      // RSA test keys
+	"encoding/base64" // Known-answer JWK members
+	"fmt"             // Used to report key lookups
+	"math/big"        // Known-answer RSA modulus
+	"testing"         // Go test framework
+	"time"            // Token lifetimes
+
+	"go-twilio-verify/data" // Channel names
+
+	"github.com/golang-jwt/jwt/v5" // Token validation
+)
+
+// generateKey returns a new private key of the kind named: rsa, p256, p384, p521 or ed25519
+func generateKey(t *testing.T, kind string) crypto.Signer {
+	t.Helper()
+	var key crypto.Signer
+	var err error
+	switch kind {
+	case "rsa":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "p256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "p384":
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "p521":
+		key, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("unknown key kind %q", kind)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// parseToken validates a token the way a relying service would: the key is looked up in the
+// issuer's JWKS by the token's kid, and must be published for the token's algorithm
+func parseToken(t *testing.T, issuer *TokenIssuer, key crypto.Signer, token string) *VerificationClaims {
+	t.Helper()
+	claims := &VerificationClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(tok *jwt.Token) (any, error) {
+		for _, jwk := range issuer.JWKS().Keys {
+			if jwk.Kid == tok.Header["kid"] && jwk.Alg == tok.Method.Alg() {
+				return key.Public(), nil
+			}
+		}
+		return nil, fmt.Errorf("no %s key %v in the JWKS", tok.Method.Alg(), tok.Header["kid"])
+	})
+	if err != nil {
+		t.Fatalf("token does not validate: %v", err)
+	}
+	return claims
+}
+
+func TestTokenIssuerIssue(t *testing.T) {
+	tests := []struct {
+		key     string
+		alg     string
+		channel string
+		to      string
+		wantSub string
+	}{
+		{key: "rsa", alg: "RS256", channel: data.ChannelSMS, to: testPhone, wantSub: testPhone},
+		{key: "p256", alg: "ES256", channel: data.ChannelSMS, to: testPhone, wantSub: testPhone},
+		{key: "p384", alg: "ES384", channel: data.ChannelEmail, to: "ann@example.com", wantSub: "ann@example.com"},
+		{key: "p521", alg: "ES512", channel: data.ChannelEmail, to: "ann@example.com", wantSub: "ann@example.com"},
+		{key: "ed25519", alg: "EdDSA", channel: data.FactorTOTP, to: testPhone, wantSub: "user:" + testPhone},
+		{key: "ed25519", alg: "EdDSA", channel: data.FactorRecoveryCode, to: "42", wantSub: "user:42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"/"+tt.channel, func(t *testing.T) {
+			key := generateKey(t, tt.key)
+			issuer, err := NewTokenIssuer(key, TokenOptions{Issuer: "https://verify.acme.test", Audience: []string{"acme"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Now().Truncate(time.Second)
+			sess := &Session{ID: "VE1", Channel: tt.channel, To: tt.to}
+			token, expires, err := issuer.Issue(sess, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := now.Add(defaultTokenTTL); !expires.Equal(want) {
+				t.Errorf("expiry: got %s, want %s", expires, want)
+			}
+
+			claims := parseToken(t, issuer, key, token)
+			if got := issuer.JWKS().Keys[0].Alg; got != tt.alg {
+				t.Errorf("JWKS alg: got %s, want %s", got, tt.alg)
+			}
+			if claims.Subject != tt.wantSub || claims.ID != "VE1" || claims.Channel != tt.channel {
+				t.Errorf("claims: got sub %q jti %q channel %q, want %q VE1 %q", claims.Subject, claims.ID, claims.Channel, tt.wantSub, tt.channel)
+			}
+			if claims.Issuer != "https://verify.acme.test" || len(claims.Audience) != 1 || claims.Audience[0] != "acme" {
+				t.Errorf("claims: got iss %q aud %v", claims.Issuer, claims.Audience)
+			}
+
+			// Only a verified address is named by type; a user ID never passes for one
+			wantPhone, wantEmail := "", ""
+			switch tt.channel {
+			case data.ChannelSMS:
+				wantPhone = tt.to
+			case data.ChannelEmail:
+				wantEmail = tt.to
+			}
+			if claims.PhoneNumber != wantPhone || claims.Email != wantEmail {
+				t.Errorf("claims: got phone_number %q email %q, want %q %q", claims.PhoneNumber, claims.Email, wantPhone, wantEmail)
+			}
+		})
+	}
+}
+
+func TestTokenIssuerKeyIDs(t *testing.T) {
+	key := generateKey(t, "p256")
+	previous := generateKey(t, "rsa")
+
+	issuer, err := NewTokenIssuer(key, TokenOptions{KeyID: "2024-01", PublicKeys: []crypto.PublicKey{previous.Public()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := issuer.JWKS().Keys
+	if len(keys) != 2 {
+		t.Fatalf("JWKS: got %d keys, want 2", len(keys))
+	}
+	if keys[0].Kid != "2024-01" {
+		t.Errorf("signing key: got kid %q, want 2024-01", keys[0].Kid)
+	}
+	derived, err := newJWK(previous.Public(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys[1].Kid != derived.Kid || keys[1].Alg != "RS256" {
+		t.Errorf("previous key: got kid %q alg %s, want the thumbprint %q and RS256", keys[1].Kid, keys[1].Alg, derived.Kid)
+	}
+
+	token, _, err := issuer.Issue(&Session{ID: "VE1", Channel: data.ChannelSMS, To: testPhone}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &VerificationClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header["kid"] != "2024-01" {
+		t.Errorf("token header: got kid %v, want 2024-01", parsed.Header["kid"])
+	}
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	b64 := func(s string) []byte {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	tests := []struct {
+		name    string
+		key     crypto.PublicKey
+		wantKid string
+	}{
+		{
+			// RFC 7638, section 3.1
+			name: "rsa",
+			key: &rsa.PublicKey{
+				N: new(big.Int).SetBytes(b64("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")),
+				E: 65537,
+			},
+			wantKid: "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs",
+		},
+		{
+			// RFC 8037, appendix A.3
+			name:    "ed25519",
+			key:     ed25519.PublicKey(b64("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")),
+			wantKid: "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jwk, err := newJWK(tt.key, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if jwk.Kid != tt.wantKid {
+				t.Errorf("kid: got %s, want %s", jwk.Kid, tt.wantKid)
+			}
+		})
+	}
+}
+
+func TestNewTokenIssuerRejectsWeakKeys(t *testing.T) {
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		key  crypto.Signer
+		opts TokenOptions
+	}{
+		{name: "short RSA key", key: small},
+		{name: "unsupported curve", key: p224},
+		{name: "unsupported published key", key: generateKey(t, "p256"), opts: TokenOptions{PublicKeys: []crypto.PublicKey{small.Public()}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewTokenIssuer(tt.key, tt.opts); err == nil {
+				t.Error("NewTokenIssuer: got no error")
+			}
+		})
+	}
+}
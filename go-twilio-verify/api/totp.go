@@ -0,0 +1,386 @@
+package api
+
+import (
+	"bytes"           // Buffer for the QR code image
+	"context"         // Carries deadlines to the store
+	"crypto/subtle"   // Constant-time code comparison
+	"encoding/base64" // Encoding of the QR code data URI
+	"encoding/json"   // Serialization of enrollments
+	"errors"          // Used to define TOTP errors
+	"image/png"       // QR code image format
+	"net/http"        // HTTP status codes
+	"strconv"         // Used to store time steps
+	"time"            // Time steps and enrollment expiry
+
+	"go-twilio-verify/data" // Request and response models
+
+	"github.com/gin-gonic/gin"    // Gin framework for HTTP handling
+	"github.com/pquerna/otp"      // Key URIs and QR codes
+	"github.com/pquerna/otp/totp" // RFC 6238 secret generation and codes
+)
+
+// Defaults applied by NewTOTPAuthenticator for zero TOTPOptions fields
+const (
+	defaultTOTPIssuer        = "go-twilio-verify"
+	defaultTOTPDigits        = 6
+	defaultTOTPPeriod        = 30 * time.Second
+	defaultTOTPSkew          = 1
+	defaultTOTPEnrollmentTTL = 10 * time.Minute
+)
+
+// TOTP errors
+var (
+	ErrTOTPNotEnrolled     = errors.New("no authenticator enrolled for this user")
+	ErrTOTPAlreadyEnrolled = errors.New("an authenticator is already enrolled for this user")
+	ErrCodeReplayed        = errors.New("code already used, wait for the next one")
+)
+
+// TOTPOptions configures a TOTPAuthenticator
+type TOTPOptions struct {
+	Issuer        string        // Name shown in authenticator apps (default go-twilio-verify)
+	Digits        int           // Digits per code, 6 or 8 (default 6)
+	Period        time.Duration // Time step, in whole seconds (default 30s)
+	Skew          int           // Steps accepted before and after the current one, for clock drift (default 1)
+	EnrollmentTTL time.Duration // How long an enrollment waits for confirmation (default 10m)
+}
+
+// totpRecord is the state kept per user. The secret is only stored sealed
+type totpRecord struct {
+	Secret    string    `json:"secret"`     // Base32 secret sealed by the SecretBox, bound to the user ID
+	Confirmed bool      `json:"confirmed"`  // Set once the user proved the app generates codes
+	CreatedAt time.Time `json:"created_at"` // When the secret was generated
+}
+
+// TOTPAuthenticator enrolls authenticator apps (RFC 6238) and verifies their codes. Secrets
+// are encrypted at rest; a code is accepted within Skew steps of the current time and only
+// once, and never after a later code was accepted
+type TOTPAuthenticator struct {
+	store Store
+	box   *SecretBox
+	opts  TOTPOptions
+}
+
+// NewTOTPAuthenticator creates a TOTPAuthenticator keeping enrollments in store with their
+// secrets sealed by box. Confirmed enrollments are stored without expiry, so the store must
+// be durable for them to survive restarts
+func NewTOTPAuthenticator(store Store, box *SecretBox, opts TOTPOptions) (*TOTPAuthenticator, error) {
+	if box == nil {
+		return nil, errors.New("totp authenticator: secret box is required")
+	}
+	if opts.Issuer == "" {
+		opts.Issuer = defaultTOTPIssuer
+	}
+	if opts.Digits == 0 {
+		opts.Digits = defaultTOTPDigits
+	}
+	if opts.Digits != 6 && opts.Digits != 8 {
+		return nil, errors.New("totp authenticator: digits must be 6 or 8")
+	}
+	if opts.Period == 0 {
+		opts.Period = defaultTOTPPeriod
+	}
+	if opts.Period < time.Second || opts.Period%time.Second != 0 {
+		return nil, errors.New("totp authenticator: period must be a whole number of seconds")
+	}
+	if opts.Skew == 0 {
+		opts.Skew = defaultTOTPSkew
+	}
+	if opts.EnrollmentTTL == 0 {
+		opts.EnrollmentTTL = defaultTOTPEnrollmentTTL
+	}
+	return &TOTPAuthenticator{store: store, box: box, opts: opts}, nil
+}
+
+// Enroll generates a new secret for the user, replacing an unconfirmed one. It returns
+// ErrTOTPAlreadyEnrolled when the user has a confirmed authenticator
+func (a *TOTPAuthenticator) Enroll(ctx context.Context, userID, accountName string) (*data.TOTPEnrollment, error) {
+	rec, err := a.load(ctx, userID)
+	if err != nil && !errors.Is(err, ErrTOTPNotEnrolled) {
+		return nil, err
+	}
+	if rec != nil && rec.Confirmed {
+		return nil, ErrTOTPAlreadyEnrolled
+	}
+
+	if accountName == "" {
+		accountName = userID
+	}
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      a.opts.Issuer,
+		AccountName: accountName,
+		Period:      uint(a.opts.Period / time.Second),
+		Digits:      otp.Digits(a.opts.Digits),
+		Algorithm:   otp.AlgorithmSHA1, // The only algorithm every authenticator app supports
+	})
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := a.box.Seal([]byte(key.Secret()), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if err := a.save(ctx, userID, totpRecord{Secret: sealed, CreatedAt: now}, a.opts.EnrollmentTTL); err != nil {
+		return nil, err
+	}
+
+	qr, err := qrDataURI(key)
+	if err != nil {
+		return nil, err
+	}
+	return &data.TOTPEnrollment{
+		UserID:    userID,
+		Secret:    key.Secret(),
+		URI:       key.URL(),
+		QRCode:    qr,
+		ExpiresAt: now.Add(a.opts.EnrollmentTTL),
+	}, nil
+}
+
+// Confirm activates a pending enrollment once the user submits a code from the app
+func (a *TOTPAuthenticator) Confirm(ctx context.Context, userID, code string) error {
+	rec, err := a.load(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if rec.Confirmed {
+		return ErrTOTPAlreadyEnrolled
+	}
+	if err := a.check(ctx, userID, rec, code); err != nil {
+		return err
+	}
+	rec.Confirmed = true
+	return a.save(ctx, userID, *rec, 0)
+}
+
+// Verify checks a code of a confirmed authenticator. It returns ErrInvalidCode for a wrong
+// code and ErrCodeReplayed for a code that was already used
+func (a *TOTPAuthenticator) Verify(ctx context.Context, userID, code string) error {
+	rec, err := a.load(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !rec.Confirmed {
+		return ErrTOTPNotEnrolled
+	}
+	return a.check(ctx, userID, rec, code)
+}
+
+// check matches the code against the steps within the drift window and consumes the
+// matching step. Every step is accepted once; steps up to the last accepted one are refused
+func (a *TOTPAuthenticator) check(ctx context.Context, userID string, rec *totpRecord, code string) error {
+	secret, err := a.box.Open(rec.Secret, userID)
+	if err != nil {
+		return err
+	}
+
+	period := int64(a.opts.Period / time.Second)
+	current := time.Now().Unix() / period
+	opts := totp.ValidateOpts{
+		Period:    uint(period),
+		Digits:    otp.Digits(a.opts.Digits),
+		Algorithm: otp.AlgorithmSHA1,
+	}
+	matched := int64(-1)
+	for step := current - int64(a.opts.Skew); step <= current+int64(a.opts.Skew); step++ {
+		want, err := totp.GenerateCodeCustom(string(secret), time.Unix(step*period, 0), opts)
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			matched = step
+		}
+	}
+	if matched < 0 {
+		return ErrInvalidCode
+	}
+
+	// Steps leave the window after 2*Skew+1 periods; keep the markers a little longer
+	window := time.Duration(2*a.opts.Skew+2) * a.opts.Period
+	last, err := a.store.Get(ctx, totpLastStepKey(userID))
+	switch {
+	case errors.Is(err, ErrNotFound):
+	case err != nil:
+		return err
+	default:
+		if n, perr := strconv.ParseInt(last, 10, 64); perr == nil && matched <= n {
+			return ErrCodeReplayed
+		}
+	}
+	// The counter makes concurrent submissions of the same code race safely
+	uses, err := a.store.Incr(ctx, totpStepKey(userID, matched), window)
+	if err != nil {
+		return err
+	}
+	if uses > 1 {
+		return ErrCodeReplayed
+	}
+	return a.store.Set(ctx, totpLastStepKey(userID), strconv.FormatInt(matched, 10), window)
+}
+
+// load returns the user's enrollment, or ErrTOTPNotEnrolled
+func (a *TOTPAuthenticator) load(ctx context.Context, userID string) (*totpRecord, error) {
+	raw, err := a.store.Get(ctx, totpKey(userID))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec totpRecord
+	if err := json.Unmarshal([]byte(raw), &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// save stores the user's enrollment for ttl, or without expiry when ttl is zero
+func (a *TOTPAuthenticator) save(ctx context.Context, userID string, rec totpRecord, ttl time.Duration) error {
+	raw, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return a.store.Set(ctx, totpKey(userID), string(raw), ttl)
+}
+
+// qrDataURI renders the key URI as a PNG QR code in a data: URI
+func qrDataURI(key *otp.Key) (string, error) {
+	img, err := key.Image(256, 256)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// Store keys used by TOTPAuthenticator
+func totpKey(userID string) string         { return "totp:user:" + userID }
+func totpLastStepKey(userID string) string { return "totp:last:" + userID }
+func totpStepKey(userID string, step int64) string {
+	return "totp:step:" + userID + ":" + strconv.FormatInt(step, 10)
+}
+
+// totpEnroll handles the API endpoint starting an authenticator enrollment
+func (app *Config) totpEnroll() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		audit := AuditEvent{Action: AuditTOTPEnroll, Channel: data.FactorTOTP}
+		defer app.recordCall(c, &audit)
+
+		var payload data.TOTPEnrollData
+		if err := app.validateBody(c, &payload); err != nil {
+			app.writeRequestError(c, err)
+			return
+		}
+		audit.Recipient = payload.UserID
+
+		enrollment, err := app.TOTP.Enroll(c.Request.Context(), payload.UserID, payload.AccountName)
+		if err != nil {
+			audit.Outcome = app.writeTOTPError(c, err)
+			return
+		}
+		audit.Outcome = AuditOutcomeEnrollmentStarted
+
+		// The secret must not end up in shared caches
+		c.Header("Cache-Control", "no-store")
+		app.writeJSON(c, http.StatusCreated, enrollment)
+	}
+}
+
+// totpConfirm handles the API endpoint activating an enrollment with a first code
+func (app *Config) totpConfirm() gin.HandlerFunc {
+	return app.totpCheck(AuditTOTPConfirm, AuditOutcomeEnrolled, (*TOTPAuthenticator).Confirm)
+}
+
+// totpVerify handles the API endpoint verifying an authenticator code
+func (app *Config) totpVerify() gin.HandlerFunc {
+	return app.totpCheck(AuditTOTPVerify, AuditOutcomeApproved, (*TOTPAuthenticator).Verify)
+}
+
+// totpCheck builds the handlers that accept a code: the check runs behind the brute-force
+// guard, and an accepted code is answered with a signed token
+func (app *Config) totpCheck(action, outcome string, check func(*TOTPAuthenticator, context.Context, string, string) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		audit := AuditEvent{Action: action, Channel: data.FactorTOTP}
+		defer app.recordCall(c, &audit)
+
+		var payload data.TOTPCodeData
+		if err := app.validateBody(c, &payload); err != nil {
+			app.writeRequestError(c, err)
+			return
+		}
+		audit.Recipient = payload.UserID
+
+		// Wrong codes count against the user like wrong delivered codes against a recipient.
+		// There is no sent code to lock, so only the per-recipient limit applies
+		guardKey := data.FactorTOTP + ":" + payload.UserID
+		if app.Guard != nil {
+			if err := app.Guard.Allow(ctx, guardKey, "", c.ClientIP()); err != nil {
+				app.writeThrottleError(c, err)
+				return
+			}
+		}
+
+		err := check(app.TOTP, ctx, payload.UserID, payload.Code)
+		if errors.Is(err, ErrInvalidCode) && app.Guard != nil {
+			if _, gerr := app.Guard.RecordFailure(ctx, guardKey, "", c.ClientIP()); gerr != nil {
+				app.logger(c).Error("cannot record failed verification", "error", gerr)
+			}
+		}
+		if err != nil {
+			audit.Outcome = app.writeTOTPError(c, err)
+			return
+		}
+		audit.Outcome = outcome
+
+		ctx = context.WithoutCancel(ctx)
+		if app.Guard != nil {
+			if err := app.Guard.RecordSuccess(ctx, guardKey, ""); err != nil {
+				app.logger(c).Error("cannot clear failed verifications", "error", err)
+			}
+		}
+		app.writeFactorApproval(c, data.FactorApproval{UserID: payload.UserID, Factor: data.FactorTOTP})
+	}
+}
+
+// writeFactorApproval responds to an accepted factor, adding the time and a token for the user
+func (app *Config) writeFactorApproval(c *gin.Context, approval data.FactorApproval) {
+	now := time.Now().UTC()
+	approval.VerifiedAt = now
+	if app.Tokens != nil {
+		token, expires, err := app.Tokens.Issue(&Session{ID: "FA" + randomHex(16), Channel: approval.Factor, To: approval.UserID}, now)
+		if err != nil {
+			app.logger(c).Error("cannot issue token", "factor", approval.Factor, "error", err)
+			app.errorJSON(c, errors.New("internal server error"), http.StatusInternalServerError)
+			return
+		}
+		approval.Token, approval.TokenExpiresAt = token, &expires
+	}
+	app.writeJSON(c, http.StatusOK, approval)
+}
+
+// writeTOTPError responds to a failed TOTP call and returns its audit outcome
+func (app *Config) writeTOTPError(c *gin.Context, err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidCode):
+		app.errorJSON(c, err)
+		return AuditOutcomeInvalidCode
+	case errors.Is(err, ErrCodeReplayed):
+		app.errorJSON(c, err)
+		return AuditOutcomeReplayed
+	case errors.Is(err, ErrTOTPNotEnrolled):
+		app.errorJSON(c, err, http.StatusNotFound)
+		return AuditOutcomeNotFound
+	case errors.Is(err, ErrTOTPAlreadyEnrolled):
+		app.errorJSON(c, err, http.StatusConflict)
+		return AuditOutcomeAlreadyEnrolled
+	default:
+		app.logger(c).Error("authenticator check failed", "error", err)
+		app.errorJSON(c, errors.New("internal server error"), http.StatusInternalServerError)
+		return AuditOutcomeError
+	}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"  // Test contexts
+	"errors"   // Used to inspect TOTP errors
+	"net/http" // HTTP methods and status codes
+	"sync"     // Concurrent checks
+	"testing"  // Go test framework
+	"time"     // Time steps
+
+	"go-twilio-verify/data" // Request bodies
+
+	"github.com/pquerna/otp"      // Code parameters
+	"github.com/pquerna/otp/totp" // Codes as an authenticator app computes them
+)
+
+// newTestTOTP returns a TOTPAuthenticator with a confirmed enrollment for user u-42, and its secret
+func newTestTOTP(t *testing.T) (*TOTPAuthenticator, string) {
+	t.Helper()
+	box, err := NewSecretBox(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewTOTPAuthenticator(NewMemoryStore(), box, TOTPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enrollment, err := a.Enroll(context.Background(), "u-42", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Confirm without a code, so every step is still unused
+	rec, err := a.load(context.Background(), "u-42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Confirmed = true
+	if err := a.save(context.Background(), "u-42", *rec, 0); err != nil {
+		t.Fatal(err)
+	}
+	return a, enrollment.Secret
+}
+
+// totpCode returns the code an authenticator app shows steps periods from now
+func totpCode(t *testing.T, secret string, steps int) string {
+	t.Helper()
+	code, err := totp.GenerateCodeCustom(secret, time.Now().Add(time.Duration(steps)*defaultTOTPPeriod), totp.ValidateOpts{
+		Period:    uint(defaultTOTPPeriod / time.Second),
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestTOTPSkew(t *testing.T) {
+	tests := []struct {
+		steps   int
+		wantErr error
+	}{
+		{steps: -2, wantErr: ErrInvalidCode},
+		{steps: -1},
+		{steps: 0},
+		{steps: 1},
+		{steps: 2, wantErr: ErrInvalidCode},
+	}
+	for _, tt := range tests {
+		a, secret := newTestTOTP(t)
+		code := totpCode(t, secret, tt.steps)
+		if err := a.Verify(context.Background(), "u-42", code); !errors.Is(err, tt.wantErr) {
+			t.Errorf("code %d steps away: got %v, want %v", tt.steps, err, tt.wantErr)
+		}
+	}
+}
+
+func TestTOTPReplay(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []int // Codes submitted in turn, as steps from now
+		wantErr error // Outcome of the last one
+	}{
+		{name: "same code twice", steps: []int{0, 0}, wantErr: ErrCodeReplayed},
+		{name: "older code after a newer one", steps: []int{0, -1}, wantErr: ErrCodeReplayed},
+		{name: "newer code after an older one", steps: []int{-1, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, secret := newTestTOTP(t)
+			var err error
+			for _, step := range tt.steps {
+				err = a.Verify(context.Background(), "u-42", totpCode(t, secret, step))
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTOTPConcurrentCode(t *testing.T) {
+	a, secret := newTestTOTP(t)
+	code := totpCode(t, secret, 0)
+
+	const checks = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for i := 0; i < checks; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := a.Verify(context.Background(), "u-42", code); err == nil {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 1 {
+		t.Errorf("got %d accepted uses of one code, want 1", accepted)
+	}
+}
+
+func TestTOTPVerifyLockout(t *testing.T) {
+	app, _ := newTestApp(t, SessionOptions{})
+	a, secret := newTestTOTP(t)
+	app.TOTP = a
+	// The per-session limit is lower, but a factor without a sent code is only limited per user
+	app.Guard = NewVerifyGuard(NewMemoryStore(), nil, GuardOptions{MaxSessionFailures: 2, MaxRecipientFailures: 4})
+	app.Router.POST("/totp/verify", app.totpVerify())
+
+	wrong := totpCode(t, secret, 5)
+	for i := 1; i <= 4; i++ {
+		if status, resp := call(t, app, http.MethodPost, "/totp/verify", data.TOTPCodeData{UserID: "u-42", Code: wrong}); status != http.StatusBadRequest {
+			t.Fatalf("wrong code %d: got %d %q, want %d", i, status, resp.Message, http.StatusBadRequest)
+		}
+	}
+	status, resp := call(t, app, http.MethodPost, "/totp/verify", data.TOTPCodeData{UserID: "u-42", Code: totpCode(t, secret, 0)})
+	if status != http.StatusTooManyRequests {
+		t.Errorf("right code after the limit: got %d %q, want %d", status, resp.Message, http.StatusTooManyRequests)
+	}
+
+	// Other users are not affected
+	if status, resp := call(t, app, http.MethodPost, "/totp/verify", data.TOTPCodeData{UserID: "u-43", Code: wrong}); status == http.StatusTooManyRequests {
+		t.Errorf("other user: got %d %q", status, resp.Message)
+	}
+}
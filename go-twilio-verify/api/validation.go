@@ -0,0 +1,138 @@
+package api
+
+import (
+	"errors"   // Used to classify binding and validation errors
+	"fmt"      // Used to format field error messages
+	"net/http" // Provides HTTP status codes
+	"reflect"  // Used to name fields after their JSON tags
+	"strings"  // Used to build field paths
+
+	"github.com/gin-gonic/gin"               // Gin framework for HTTP handling
+	"github.com/go-playground/validator/v10" // Validation library for struct-based validation
+)
+
+// Create a new instance of the validator library, reporting fields by their JSON names
+var validate = newValidator()
+
+// newValidator creates the validator used for request bodies
+func newValidator() *validator.Validate {
+	v := validator.New()
+
+	// Report "phoneNumber" rather than "PhoneNumber" so errors match the request body
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return f.Name
+		}
+		return name
+	})
+
+	// digits accepts strings made only of ASCII digits, as OTP codes are
+	_ = v.RegisterValidation("digits", func(fl validator.FieldLevel) bool {
+		s := fl.Field().String()
+		for i := 0; i < len(s); i++ {
+			if s[i] < '0' || s[i] > '9' {
+				return false
+			}
+		}
+		return true
+	})
+
+	return v
+}
+
+// fieldError describes why one field of a request body was rejected
+type fieldError struct {
+	Field   string `json:"field"`   // JSON path of the field, e.g. "user.phoneNumber"
+	Rule    string `json:"rule"`    // Validation rule that failed, e.g. "phone"
+	Message string `json:"message"` // Human-readable explanation
+}
+
+// requestError is returned by validateBody when the body cannot be accepted.
+// Malformed JSON is a 400; a well-formed body with invalid fields is a 422
+type requestError struct {
+	Status int          // HTTP status to respond with
+	Msg    string       // Summary message
+	Fields []fieldError // Field-level details, empty for malformed bodies
+}
+
+// Error implements the error interface
+func (e *requestError) Error() string { return e.Msg }
+
+// invalidField returns a 422 requestError for a single field
+func invalidField(field, rule, message string) *requestError {
+	return &requestError{
+		Status: http.StatusUnprocessableEntity,
+		Msg:    "invalid request",
+		Fields: []fieldError{{Field: field, Rule: rule, Message: message}},
+	}
+}
+
+// validateBody decodes the JSON request body into data, which must be a pointer
+// to a struct, and validates it. It returns a *requestError describing the problem
+func (app *Config) validateBody(c *gin.Context, data any) error {
+	// Bind JSON data from the request body to the provided struct
+	if err := c.ShouldBindJSON(data); err != nil {
+		return &requestError{Status: http.StatusBadRequest, Msg: "request body must be a valid JSON object"}
+	}
+
+	// Use the validator library to validate the struct fields
+	err := validate.Struct(data)
+	var verrs validator.ValidationErrors
+	if errors.As(err, &verrs) {
+		fields := make([]fieldError, 0, len(verrs))
+		for _, fe := range verrs {
+			fields = append(fields, fieldError{Field: fieldPath(fe), Rule: fe.Tag(), Message: fieldMessage(fe)})
+		}
+		return &requestError{Status: http.StatusUnprocessableEntity, Msg: "invalid request", Fields: fields}
+	}
+
+	return err // nil on success; any other error means the validator was misused
+}
+
+// writeRequestError responds to a request rejected by validateBody
+func (app *Config) writeRequestError(c *gin.Context, err error) {
+	var reqErr *requestError
+	if !errors.As(err, &reqErr) {
+		app.logger(c).Error("cannot validate request", "error", err)
+		app.errorJSON(c, errors.New("internal server error"), http.StatusInternalServerError)
+		return
+	}
+	c.JSON(reqErr.Status, jsonResponse{Status: reqErr.Status, Message: reqErr.Msg, Data: reqErr.Fields})
+}
+
+// fieldPath returns the JSON path of the failed field without the root struct name
+func fieldPath(fe validator.FieldError) string {
+	_, path, found := strings.Cut(fe.Namespace(), ".")
+	if !found {
+		return fe.Field()
+	}
+	return path
+}
+
+// fieldMessage explains a failed validation rule in plain words
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required", "required_if", "required_unless", "required_without":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "oneof":
+		return "must be one of: " + fe.Param()
+	case "bcp47_language_tag":
+		return "must be a BCP 47 language tag, e.g. en or pt-BR"
+	case "digits":
+		return "must contain only digits"
+	case "min":
+		return fmt.Sprintf("must be at least %s characters long", fe.Param())
+	case "max":
+		return fmt.Sprintf("must be at most %s characters long", fe.Param())
+	case "len":
+		return fmt.Sprintf("must be exactly %s characters long", fe.Param())
+	default:
+		return "is invalid"
+	}
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"context" // Carries the request's deadline and cancellation to the provider
+	"errors"  // Used to define sentinel errors shared by every verifier
+)
+
+// ErrInvalidCode is returned by Verifier.Check when the code does not match the one sent
+var ErrInvalidCode = errors.New("not a valid code")
+
+// ErrChannelDisabled is returned when a code is requested over a channel this deployment does not offer
+var ErrChannelDisabled = errors.New("channel is not enabled")
+
+// Verifier sends one-time passwords and checks the codes users submit.
+// The handlers only depend on this interface, so the provider can be swapped
+// (Twilio Verify in production, FakeVerifier in tests and local development)
+type Verifier interface {
+	// Send delivers a new code over the channel (one of the data.Channel* constants) to the
+	// recipient, a phone number or an email address, worded for the locale (a BCP 47 tag), and
+	// returns the provider's ID for the verification
+	Send(ctx context.Context, channel, to, locale string) (string, error)
+
+	// Check verifies the code submitted for the recipient.
+	// It returns ErrInvalidCode when the code is wrong or has expired
+	Check(ctx context.Context, to, code string) error
+}
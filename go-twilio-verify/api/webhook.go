@@ -0,0 +1,397 @@
+package api
+
+import (
+	"bytes"           // Request bodies
+	"context"         // Per-attempt deadlines
+	"crypto/hmac"     // Payload signatures
+	"crypto/rand"     // Event and delivery IDs
+	"crypto/sha256"   // Hash function used by the HMAC
+	"encoding/hex"    // Encoding of IDs and signatures
+	"encoding/json"   // Event serialization
+	"errors"          // Used to report closed dispatchers
+	"fmt"             // Used to format errors
+	"io"              // Used to drain response bodies
+	"log/slog"        // Delivery failures are logged
+	mrand "math/rand" // Backoff jitter
+	"net/http"        // Outbound HTTP client
+	"strconv"         // Timestamp header
+	"sync"            // Guards the delivery log and shutdown
+	"time"            // Backoff and timestamps
+)
+
+// Webhook event types
+const (
+	EventOTPSent     = "otp.sent"     // A code was sent
+	EventOTPApproved = "otp.approved" // A code was approved
+	EventOTPFailed   = "otp.failed"   // A wrong code was submitted
+	EventOTPLocked   = "otp.locked"   // A recipient or session was locked after too many failures
+)
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"   // Waiting for its first attempt
+	DeliveryRetrying  = "retrying"  // An attempt failed and another is scheduled
+	DeliveryDelivered = "delivered" // The endpoint answered with a 2xx status
+	DeliveryFailed    = "failed"    // All attempts failed, or the endpoint rejected the event permanently
+)
+
+// Defaults applied by NewWebhookDispatcher for zero WebhookOptions fields
+const (
+	defaultWebhookAttempts   = 8
+	defaultWebhookBackoff    = time.Second
+	defaultWebhookMaxBackoff = 5 * time.Minute
+	defaultWebhookTimeout    = 10 * time.Second
+	defaultWebhookQueueSize  = 1000
+	defaultWebhookLogSize    = 1000
+	defaultWebhookWorkers    = 4
+)
+
+// WebhookOptions configures a WebhookDispatcher
+type WebhookOptions struct {
+	URLs           []string      // Endpoints every event is delivered to
+	Secret         []byte        // Key the Webhook-Signature HMAC is computed with
+	MaxAttempts    int           // Attempts per delivery before it is marked failed (default 8)
+	InitialBackoff time.Duration // Wait before the first retry; doubles for each further retry (default 1s)
+	MaxBackoff     time.Duration // Upper bound of the wait between retries (default 5m)
+	Timeout        time.Duration // Deadline of each attempt (default 10s)
+	QueueSize      int           // Deliveries waiting for a worker before new events are dropped (default 1000)
+	LogSize        int           // Deliveries kept in the delivery log (default 1000)
+	Workers        int           // Concurrent deliveries (default 4)
+	Client         *http.Client  // HTTP client; a client without its own timeout is used when nil
+	Logger         *slog.Logger  // Destination of delivery errors; slog.Default() when nil
+}
+
+// WebhookEvent is the JSON body delivered to webhook endpoints
+type WebhookEvent struct {
+	ID        string           `json:"id"`        // Unique event ID; also sent as Webhook-Id for deduplication
+	Type      string           `json:"type"`      // One of the Event* constants
+	CreatedAt time.Time        `json:"createdAt"` // When the event happened
+	Data      WebhookEventData `json:"data"`      // What happened
+}
+
+// WebhookEventData describes the verification an event is about
+type WebhookEventData struct {
+	VerificationID string `json:"verificationId"`   // ID of the verification
+	Channel        string `json:"channel"`          // Delivery channel
+	To             string `json:"to"`               // Recipient phone number or email address
+	Status         string `json:"status"`           // Verification status after the event
+	Reason         string `json:"reason,omitempty"` // Why a check failed or what was locked
+}
+
+// WebhookAttempt records one delivery attempt
+type WebhookAttempt struct {
+	At         time.Time `json:"at"`                   // When the attempt was made
+	StatusCode int       `json:"statusCode,omitempty"` // HTTP status returned by the endpoint
+	Error      string    `json:"error,omitempty"`      // Why the attempt failed
+	DurationMs int64     `json:"durationMs"`           // How long the attempt took
+}
+
+// WebhookDelivery is the delivery of one event to one endpoint, as shown in the delivery log
+type WebhookDelivery struct {
+	ID            string           `json:"id"`                      // Unique delivery ID
+	EventID       string           `json:"eventId"`                 // ID of the delivered event
+	EventType     string           `json:"eventType"`               // Type of the delivered event
+	URL           string           `json:"url"`                     // Endpoint the event is delivered to
+	Status        string           `json:"status"`                  // One of the Delivery* constants
+	Attempts      []WebhookAttempt `json:"attempts"`                // Attempts made so far, oldest first
+	NextAttemptAt *time.Time       `json:"nextAttemptAt,omitempty"` // When the next retry is due
+	CreatedAt     time.Time        `json:"createdAt"`               // When the delivery was queued
+
+	body []byte // Serialized event
+}
+
+// WebhookDispatcher delivers events to the configured endpoints in the background.
+// Each delivery is HMAC-signed and retried with exponential backoff until it succeeds
+// or runs out of attempts; every delivery and attempt is kept in a bounded log
+type WebhookDispatcher struct {
+	opts   WebhookOptions
+	client *http.Client
+	logger *slog.Logger
+	queue  chan *WebhookDelivery
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+	log    []*WebhookDelivery // Oldest first, at most opts.LogSize entries
+	timers map[string]*time.Timer
+}
+
+// NewWebhookDispatcher creates a dispatcher and starts its workers. Call Close to stop them
+func NewWebhookDispatcher(opts WebhookOptions) (*WebhookDispatcher, error) {
+	if len(opts.URLs) == 0 {
+		return nil, errors.New("webhooks: at least one URL is required")
+	}
+	if len(opts.Secret) == 0 {
+		return nil, errors.New("webhooks: a signing secret is required")
+	}
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = defaultWebhookAttempts
+	}
+	if opts.InitialBackoff == 0 {
+		opts.InitialBackoff = defaultWebhookBackoff
+	}
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = defaultWebhookMaxBackoff
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultWebhookTimeout
+	}
+	if opts.QueueSize == 0 {
+		opts.QueueSize = defaultWebhookQueueSize
+	}
+	if opts.LogSize == 0 {
+		opts.LogSize = defaultWebhookLogSize
+	}
+	if opts.Workers == 0 {
+		opts.Workers = defaultWebhookWorkers
+	}
+
+	d := &WebhookDispatcher{
+		opts:   opts,
+		client: opts.Client,
+		logger: opts.Logger,
+		queue:  make(chan *WebhookDelivery, opts.QueueSize),
+		timers: make(map[string]*time.Timer),
+	}
+	if d.client == nil {
+		d.client = &http.Client{}
+	}
+	if d.logger == nil {
+		d.logger = slog.Default()
+	}
+
+	for i := 0; i < opts.Workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	return d, nil
+}
+
+// Publish queues an event for delivery to every endpoint. It never blocks: when the
+// queue is full the event is dropped and logged
+func (d *WebhookDispatcher) Publish(eventType string, eventData WebhookEventData) {
+	event := WebhookEvent{ID: "evt_" + randomHex(12), Type: eventType, CreatedAt: time.Now().UTC(), Data: eventData}
+	body, err := json.Marshal(event)
+	if err != nil {
+		d.logger.Error("cannot encode webhook event", "event", eventType, "error", err)
+		return
+	}
+
+	for _, url := range d.opts.URLs {
+		delivery := &WebhookDelivery{
+			ID:        "whd_" + randomHex(12),
+			EventID:   event.ID,
+			EventType: event.Type,
+			URL:       url,
+			Status:    DeliveryPending,
+			CreatedAt: event.CreatedAt,
+			body:      body,
+		}
+		d.mu.Lock()
+		if d.closed {
+			d.mu.Unlock()
+			return
+		}
+		d.record(delivery)
+		d.mu.Unlock()
+		d.enqueue(delivery)
+	}
+}
+
+// Deliveries returns the most recent deliveries first, optionally filtered by status and
+// event type, up to limit entries
+func (d *WebhookDispatcher) Deliveries(status, eventType string, limit int) []WebhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	out := []WebhookDelivery{}
+	for i := len(d.log) - 1; i >= 0 && len(out) < limit; i-- {
+		del := d.log[i]
+		if (status != "" && del.Status != status) || (eventType != "" && del.EventType != eventType) {
+			continue
+		}
+		cp := *del
+		cp.Attempts = append([]WebhookAttempt(nil), del.Attempts...)
+		out = append(out, cp)
+	}
+	return out
+}
+
+// Close stops accepting events, cancels scheduled retries and waits for in-flight
+// deliveries to finish or ctx to end. Queued deliveries that were not attempted are dropped
+func (d *WebhookDispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	for id, t := range d.timers {
+		t.Stop()
+		delete(d.timers, id)
+	}
+	close(d.queue)
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// work delivers queued events until the queue is closed
+func (d *WebhookDispatcher) work() {
+	defer d.wg.Done()
+	for delivery := range d.queue {
+		if d.isClosed() {
+			continue // Drain without delivering once shutdown started
+		}
+		d.attempt(delivery)
+	}
+}
+
+// attempt makes one delivery attempt and schedules a retry when it fails
+func (d *WebhookDispatcher) attempt(delivery *WebhookDelivery) {
+	start := time.Now()
+	statusCode, err := d.post(delivery)
+	result := WebhookAttempt{At: start.UTC(), StatusCode: statusCode, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delivery.Attempts = append(delivery.Attempts, result)
+	delivery.NextAttemptAt = nil
+	switch {
+	case err == nil:
+		delivery.Status = DeliveryDelivered
+		return
+	case !retryable(statusCode) || len(delivery.Attempts) >= d.opts.MaxAttempts || d.closed:
+		delivery.Status = DeliveryFailed
+		d.logger.Warn("webhook delivery failed", "delivery_id", delivery.ID, "url", delivery.URL,
+			"event", delivery.EventType, "attempts", len(delivery.Attempts), "error", err)
+		return
+	}
+
+	delivery.Status = DeliveryRetrying
+	wait := d.backoff(len(delivery.Attempts))
+	next := time.Now().Add(wait).UTC()
+	delivery.NextAttemptAt = &next
+	d.timers[delivery.ID] = time.AfterFunc(wait, func() {
+		d.mu.Lock()
+		_, scheduled := d.timers[delivery.ID]
+		delete(d.timers, delivery.ID)
+		d.mu.Unlock()
+		if scheduled {
+			d.enqueue(delivery)
+		}
+	})
+}
+
+// post sends the signed event and returns the response status
+func (d *WebhookDispatcher) post(delivery *WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.opts.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-twilio-verify-webhooks")
+	req.Header.Set("Webhook-Id", delivery.EventID)
+	req.Header.Set("Webhook-Timestamp", timestamp)
+	req.Header.Set("Webhook-Signature", "v1="+SignWebhook(d.opts.Secret, timestamp, delivery.body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // Allow the connection to be reused
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// enqueue hands a delivery to the workers, dropping it when the queue is full or closed
+func (d *WebhookDispatcher) enqueue(delivery *WebhookDelivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	select {
+	case d.queue <- delivery:
+	default:
+		delivery.Status = DeliveryFailed
+		d.logger.Error("webhook queue full, dropping delivery", "delivery_id", delivery.ID, "event", delivery.EventType)
+	}
+}
+
+// record appends a delivery to the log, evicting the oldest beyond LogSize. d.mu must be held
+func (d *WebhookDispatcher) record(delivery *WebhookDelivery) {
+	d.log = append(d.log, delivery)
+	if over := len(d.log) - d.opts.LogSize; over > 0 {
+		clear(d.log[:over])
+		d.log = d.log[over:]
+	}
+}
+
+// isClosed reports whether Close was called
+func (d *WebhookDispatcher) isClosed() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.closed
+}
+
+// backoff returns the wait before the next attempt after n failed ones: InitialBackoff
+// doubled n-1 times, capped at MaxBackoff, with up to 20% random jitter so retries to a
+// recovering endpoint are spread out
+func (d *WebhookDispatcher) backoff(n int) time.Duration {
+	wait := d.opts.InitialBackoff
+	for i := 1; i < n && wait < d.opts.MaxBackoff; i++ {
+		wait *= 2
+	}
+	wait = min(wait, d.opts.MaxBackoff)
+	return wait - time.Duration(mrand.Int63n(int64(wait)/5+1))
+}
+
+// retryable reports whether a failed attempt may succeed when repeated. Client errors other
+// than timeouts and rate limiting mean the endpoint rejected the event and will do so again
+func retryable(statusCode int) bool {
+	if statusCode >= 400 && statusCode < 500 {
+		return statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// SignWebhook computes the hex HMAC-SHA256 signature of a webhook: the secret applied to
+// the timestamp, a dot and the body. Receivers recompute it to authenticate the event and
+// should reject timestamps more than a few minutes old to prevent replays
+func SignWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b) // The system random source does not fail in practice
+	return hex.EncodeToString(b)
+}
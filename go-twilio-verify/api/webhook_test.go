@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"           // Dispatcher shutdown
+	"encoding/json"     // Used to read delivered events
+	"io"                // Request bodies and discarded logs
+	"log/slog"          // Test logger
+	"net/http"          // HTTP status codes
+	"net/http/httptest" // Fake webhook endpoint
+	"strings"           // Signature header parsing
+	"sync"              // Guards the endpoint's responses
+	"testing"           // Go test framework
+	"time"              // Backoff and polling
+)
+
+func TestSignWebhook(t *testing.T) {
+	// Computed independently: HMAC-SHA256 of "1700000000.{"id":"evt_1"}" keyed with "whsec_test"
+	const want = "c89214b5b5da833daed6f0b8c5bb6bd58cea9022bd80ccc78230f3942d632925"
+	if got := SignWebhook([]byte("whsec_test"), "1700000000", []byte(`{"id":"evt_1"}`)); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got := SignWebhook([]byte("whsec_other"), "1700000000", []byte(`{"id":"evt_1"}`)); got == want {
+		t.Error("signature does not depend on the secret")
+	}
+	if got := SignWebhook([]byte("whsec_test"), "1700000001", []byte(`{"id":"evt_1"}`)); got == want {
+		t.Error("signature does not depend on the timestamp")
+	}
+}
+
+func TestWebhookRetryable(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{status: 0, want: true}, // No response at all
+		{status: http.StatusBadRequest},
+		{status: http.StatusUnauthorized},
+		{status: http.StatusNotFound},
+		{status: http.StatusRequestTimeout, want: true},
+		{status: http.StatusTooManyRequests, want: true},
+		{status: http.StatusInternalServerError, want: true},
+		{status: http.StatusServiceUnavailable, want: true},
+	}
+	for _, tt := range tests {
+		if got := retryable(tt.status); got != tt.want {
+			t.Errorf("retryable(%d): got %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	d := &WebhookDispatcher{opts: WebhookOptions{InitialBackoff: time.Second, MaxBackoff: time.Minute}}
+	tests := []struct {
+		failures int
+		max      time.Duration // Backoff before jitter
+	}{
+		{failures: 1, max: time.Second},
+		{failures: 2, max: 2 * time.Second},
+		{failures: 4, max: 8 * time.Second},
+		{failures: 7, max: time.Minute},
+		{failures: 30, max: time.Minute},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			// Jitter takes off up to a fifth
+			if got := d.backoff(tt.failures); got > tt.max || got < tt.max*4/5 {
+				t.Fatalf("backoff after %d failures: got %s, want between %s and %s", tt.failures, got, tt.max*4/5, tt.max)
+			}
+		}
+	}
+}
+
+// webhookEndpoint is a fake webhook receiver answering with a fixed sequence of statuses
+type webhookEndpoint struct {
+	mu       sync.Mutex
+	statuses []int // Status of each request in turn; the last one repeats
+	requests int
+	badSigs  int // Requests whose signature did not verify
+}
+
+func (e *webhookEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	sig, _ := strings.CutPrefix(r.Header.Get("Webhook-Signature"), "v1=")
+
+	var event WebhookEvent
+	e.mu.Lock()
+	if sig != SignWebhook([]byte("whsec_test"), r.Header.Get("Webhook-Timestamp"), body) ||
+		json.Unmarshal(body, &event) != nil || event.ID != r.Header.Get("Webhook-Id") {
+		e.badSigs++
+	}
+	status := e.statuses[min(e.requests, len(e.statuses)-1)]
+	e.requests++
+	e.mu.Unlock()
+	w.WriteHeader(status)
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantStatus   string
+		wantAttempts int
+	}{
+		{name: "delivered", statuses: []int{http.StatusNoContent}, wantStatus: DeliveryDelivered, wantAttempts: 1},
+		{name: "retried until delivered", statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}, wantStatus: DeliveryDelivered, wantAttempts: 3},
+		{name: "attempts used up", statuses: []int{http.StatusInternalServerError}, wantStatus: DeliveryFailed, wantAttempts: 4},
+		{name: "rejected without retry", statuses: []int{http.StatusGone}, wantStatus: DeliveryFailed, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint := &webhookEndpoint{statuses: tt.statuses}
+			server := httptest.NewServer(endpoint)
+			defer server.Close()
+
+			d, err := NewWebhookDispatcher(WebhookOptions{
+				URLs:           []string{server.URL},
+				Secret:         []byte("whsec_test"),
+				MaxAttempts:    4,
+				InitialBackoff: time.Millisecond,
+				Logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close(context.Background())
+
+			d.Publish(EventOTPApproved, WebhookEventData{VerificationID: "VE1", To: testPhone, Status: "approved"})
+
+			var delivery WebhookDelivery
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				deliveries := d.Deliveries("", "", 10)
+				if len(deliveries) == 1 && (deliveries[0].Status == DeliveryDelivered || deliveries[0].Status == DeliveryFailed) {
+					delivery = deliveries[0]
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("delivery did not finish: %+v", deliveries)
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			if delivery.Status != tt.wantStatus || len(delivery.Attempts) != tt.wantAttempts {
+				t.Errorf("got %s after %d attempts, want %s after %d", delivery.Status, len(delivery.Attempts), tt.wantStatus, tt.wantAttempts)
+			}
+			endpoint.mu.Lock()
+			defer endpoint.mu.Unlock()
+			if endpoint.badSigs != 0 {
+				t.Errorf("%d of %d requests were not signed correctly", endpoint.badSigs, endpoint.requests)
+			}
+		})
+	}
+}
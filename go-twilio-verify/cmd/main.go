@@ -1,22 +1,596 @@
 package main
 
 import (
-	"go-twilio-verify/api" // Importing the API package containing the app configuration and routes
+	"context"         // Used to set up the trace exporter
+	"crypto"          // Signer interface of token keys
+	"crypto/ecdsa"    // Used to generate a temporary token key
+	"crypto/elliptic" // Curve of the temporary token key
+	"crypto/rand"     // Used to generate a hash key for the in-memory engine and a temporary token key
+	"encoding/base64" // Used to decode the TOTP encryption key
+	"errors"          // Used to recognise a help request
+	"flag"            // Used to recognise a help request
+	"fmt"             // Used to report configuration errors before logging is available
+	"log/slog"        // Structured logging from the standard library
+	"net/http"        // HTTP server with timeouts and graceful shutdown
+	"net/url"         // Used to split the OTLP endpoint
+	"os"              // Access to standard streams and key files
+	"os/signal"       // Used to shut down on SIGINT and SIGTERM
+	"strings"         // Used to build the OTLP traces path
+	"syscall"         // SIGTERM
 
-	"github.com/gin-gonic/gin" // Importing the Gin web framework
+	"go-twilio-verify/api"    // Importing the API package containing the app configuration and routes
+	"go-twilio-verify/config" // Typed settings loaded once at startup
+	"go-twilio-verify/data"   // Channel names
+
+	"github.com/gin-gonic/gin"                                        // Importing the Gin web framework
+	"github.com/go-redis/redis/v8"                                    // Redis client for the self-hosted engine's store
+	"go.opentelemetry.io/otel"                                        // Global tracer provider and propagator
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp" // Exports spans to an OTLP collector
+	"go.opentelemetry.io/otel/propagation"                            // W3C trace context propagation
+	"go.opentelemetry.io/otel/sdk/resource"                           // Identifies the service in exported spans
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"                     // Tracer provider with batching export
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"                // Standard resource attribute names
 )
 
 func main() {
-	// Create a new Gin router instance
-	router := gin.Default()
+	// Load and validate every setting from .env, the environment and the flags before anything starts
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// Create the structured logger
+	logger, err := api.NewLogger(os.Stdout, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	// Count sends, approvals, failures and provider latency when METRICS_ENABLED is set
+	var metrics *api.Metrics
+	if cfg.Telemetry.Metrics {
+		metrics = api.NewMetrics()
+	}
+
+	// Export spans of requests and provider calls when OTEL_EXPORTER_OTLP_ENDPOINT is set
+	var tracerProvider *sdktrace.TracerProvider
+	if cfg.Telemetry.OTLPEndpoint != "" {
+		if tracerProvider, err = newTracerProvider(cfg.Telemetry); err != nil {
+			logger.Error("cannot set up tracing", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("exporting traces", "endpoint", cfg.Telemetry.OTLPEndpoint)
+	}
+
+	// Redis is shared by the self-hosted engine and the throttle counters; nil when REDIS_ADDR is unset
+	redisClient := newRedisClient(cfg.Redis)
+
+	// Twilio reports the delivery of every code to TWILIO_STATUS_CALLBACK_URL when it is set
+	var callbacks *api.StatusCallbacks
+	if cfg.Twilio.StatusCallbackURL != "" {
+		if callbacks, err = api.NewStatusCallbacks(cfg.Twilio.StatusCallbackURL, cfg.Twilio.AuthToken); err != nil {
+			logger.Error("invalid TWILIO_STATUS_CALLBACK_URL", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Load the message wording per locale
+	templates := api.NewTemplates()
+	if cfg.Messages.TemplateDir != "" {
+		if templates, err = api.LoadTemplates(cfg.Messages.TemplateDir, cfg.Messages.DefaultLocale); err != nil {
+			logger.Error("invalid message templates", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Parse phone numbers in national format as numbers of PHONE_DEFAULT_REGION
+	phones, err := api.NewPhoneParser(cfg.PhoneRegion)
+	if err != nil {
+		logger.Error("invalid PHONE_DEFAULT_REGION", "error", err)
+		os.Exit(1)
+	}
+
+	// Choose the OTP engine: Twilio Verify, the self-hosted engine or the fake
+	verifier, err := newVerifier(logger, cfg, redisClient, callbacks, templates, metrics)
+	if err != nil {
+		logger.Error("cannot create verifier", "error", err)
+		os.Exit(1)
+	}
+
+	// Verification sessions, throttle counters and lockouts live in Redis when it is configured,
+	// falling back to memory while Redis is down
+	var limitStore api.Store = api.NewMemoryStore()
+	if redisClient != nil {
+		limitStore = api.NewFallbackStore(api.NewRedisStore(redisClient, "verify:"), limitStore, logger)
+	}
+
+	// Limit how often codes are sent per recipient and per client IP
+	throttle := api.NewThrottler(limitStore, api.ThrottleOptions{
+		Cooldown:        cfg.Throttle.Cooldown,
+		MaxCooldown:     cfg.Throttle.MaxCooldown,
+		PhoneDailyLimit: cfg.Throttle.RecipientDailyLimit,
+		IPDailyLimit:    cfg.Throttle.IPDailyLimit,
+		AllowedPrefixes: cfg.Throttle.AllowedPrefixes,
+		DeniedPrefixes:  cfg.Throttle.DeniedPrefixes,
+	})
+
+	// Keep an audit trail of every send and verify call when AUDIT_DIR is set
+	audit, err := newAuditTrail(cfg.Audit)
+	if err != nil {
+		logger.Error("cannot open audit trail", "error", err)
+		os.Exit(1)
+	}
+	var guardAudit api.AuditLog = api.LogAuditLog{Logger: logger}
+	if audit != nil {
+		guardAudit = api.AuditLogs{guardAudit, audit}
+	}
+
+	// Lock out brute-force attempts on code checks and audit the lockouts
+	guard := api.NewVerifyGuard(limitStore, guardAudit, api.GuardOptions{
+		MaxRecipientFailures: cfg.Lockout.MaxFailures,
+		MaxSessionFailures:   cfg.Lockout.MaxSessionFailures,
+		FailureWindow:        cfg.Lockout.FailureWindow,
+		LockoutDuration:      cfg.Lockout.Duration,
+	})
+
+	// Track a session for every code sent. OTP_TTL should match the provider's code lifetime
+	sessions := api.NewSessionStore(limitStore, api.SessionOptions{CodeTTL: cfg.OTP.TTL, Retention: cfg.SessionRetention})
+
+	// Sign a token for every approved verification
+	tokens, err := newTokenIssuer(logger, cfg.Token)
+	if err != nil {
+		logger.Error("invalid token settings", "error", err)
+		os.Exit(1)
+	}
+
+	// Notify other services of verification events
+	webhooks, err := newWebhookDispatcher(logger, cfg.Webhook)
+	if err != nil {
+		logger.Error("invalid webhook settings", "error", err)
+		os.Exit(1)
+	}
+
+	// Enroll authenticator apps when TOTP_ENCRYPTION_KEY is set
+	totp, err := newTOTPAuthenticator(logger, cfg.TOTP, redisClient)
+	if err != nil {
+		logger.Error("invalid TOTP settings", "error", err)
+		os.Exit(1)
+	}
+
+	// Issue recovery codes when RECOVERY_HASH_KEY is set
+	recovery, err := newRecoveryCodes(logger, cfg.Recovery, redisClient)
+	if err != nil {
+		logger.Error("invalid recovery code settings", "error", err)
+		os.Exit(1)
+	}
+
+	// Verify email addresses by link or code when EMAIL_LINK_KEY is set
+	emailLinks, err := newEmailLinks(logger, cfg, redisClient)
+	if err != nil {
+		logger.Error("invalid email link settings", "error", err)
+		os.Exit(1)
+	}
+
+	// Report provider, mail server and Redis reachability on /healthz and /readyz
+	health := api.NewHealth(cfg.Server.HealthTimeout, healthChecks(cfg, verifier, emailLinks, redisClient)...)
+
+	// Create a new Gin router instance without the default text logger;
+	// requests are logged as structured lines by the API middleware instead
+	router := gin.New()
+	router.Use(gin.Recovery())
+
+	// Per-IP limits rely on the client IP, so only trust X-Forwarded-For from the
+	// proxies listed in TRUSTED_PROXIES (comma-separated IPs or CIDRs)
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		logger.Error("invalid TRUSTED_PROXIES", "error", err)
+		os.Exit(1)
+	}
 
 	// Initialize application configuration
 	// The Config struct from the api package is used to set up the router and other configurations
-	app := api.Config{Router: router}
+	app := api.Config{
+		Router:          router,
+		Logger:          logger,
+		Verifier:        verifier,
+		Channels:        cfg.Channels,
+		Throttle:        throttle,
+		Guard:           guard,
+		Sessions:        sessions,
+		Tokens:          tokens,
+		Webhooks:        webhooks,
+		Templates:       templates,
+		Phones:          phones,
+		Audit:           audit,
+		Metrics:         metrics,
+		Health:          health,
+		TOTP:            totp,
+		Recovery:        recovery,
+		EmailLinks:      emailLinks,
+		StatusCallbacks: callbacks,
+		AdminToken:      cfg.AdminToken,
+	}
 
 	// Set up application routes
 	app.Routes()
 
-	// Start the server on port 8000
-	router.Run(":8000")
+	// Start the server on the configured port
+	server := &http.Server{
+		Addr:              cfg.Addr(),
+		Handler:           router,
+		ReadHeaderTimeout: cfg.Server.ReadTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("server starting", "addr", cfg.Addr())
+		serveErr <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-serveErr:
+		logger.Error("server failed", "error", err)
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+	// A second signal kills the process instead of waiting for the drain
+	stop()
+
+	// Fail readiness, stop accepting connections and let the in-flight requests finish
+	logger.Info("shutting down", "drain_timeout", cfg.Server.DrainTimeout.String())
+	health.Drain()
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.DrainTimeout)
+	defer cancel()
+	if err := server.Shutdown(drainCtx); err != nil {
+		logger.Warn("drain timeout reached, dropping open connections", "error", err)
+		_ = server.Close()
+	}
+	release(drainCtx, logger, webhooks, tracerProvider, audit, redisClient)
+	logger.Info("server stopped")
+}
+
+// release flushes and closes what outlives the requests once the server has stopped: queued
+// webhook deliveries, buffered spans, the audit file and the Redis connections
+func release(ctx context.Context, logger *slog.Logger, webhooks *api.WebhookDispatcher, tracerProvider *sdktrace.TracerProvider, audit *api.AuditTrail, redisClient *redis.Client) {
+	if webhooks != nil {
+		if err := webhooks.Close(ctx); err != nil {
+			logger.Warn("webhook deliveries abandoned", "error", err)
+		}
+	}
+	if tracerProvider != nil {
+		if err := tracerProvider.Shutdown(ctx); err != nil {
+			logger.Warn("cannot flush spans", "error", err)
+		}
+	}
+	if audit != nil {
+		if err := audit.Close(); err != nil {
+			logger.Error("cannot close audit trail", "error", err)
+		}
+	}
+	if redisClient != nil {
+		_ = redisClient.Close()
+	}
+}
+
+// healthChecks lists the dependencies probed by /healthz and /readyz. Redis only makes the
+// service unready when it holds the codes; the limit store falls back to memory without it.
+// The mail server of email links never does
+func healthChecks(cfg *config.Config, verifier api.Verifier, emailLinks *api.EmailLinks, redisClient *redis.Client) []api.HealthCheck {
+	var checks []api.HealthCheck
+	if p, ok := verifier.(api.Pinger); ok {
+		checks = append(checks, api.HealthCheck{Name: "provider", Pinger: p})
+	}
+	if emailLinks != nil {
+		// Codes are still sent while only email links are down
+		checks = append(checks, api.HealthCheck{Name: "mail", Pinger: emailLinks, Optional: true})
+	}
+	if redisClient != nil {
+		checks = append(checks, api.HealthCheck{
+			Name:     "redis",
+			Pinger:   api.NewRedisStore(redisClient, ""),
+			Optional: cfg.Verifier != "local" || cfg.OTP.Store != "redis",
+		})
+	}
+	return checks
+}
+
+// newVerifier builds the Verifier selected by OTP_VERIFIER:
+//   - twilio (default): Twilio Verify generates, sends and checks the codes
+//   - local: codes are generated and checked here and sent as SMS through Twilio Messaging
+//   - fake: codes are logged instead of sent, so the service runs without Twilio credentials
+func newVerifier(logger *slog.Logger, cfg *config.Config, redisClient *redis.Client, callbacks *api.StatusCallbacks, templates *api.Templates, metrics *api.Metrics) (api.Verifier, error) {
+	if callbacks != nil && cfg.Verifier == "fake" {
+		logger.Warn("the fake verifier delivers nothing, so no delivery status callbacks will arrive")
+	}
+	switch cfg.Verifier {
+	case "local":
+		return newLocalVerifier(logger, cfg, redisClient, callbacks, templates, metrics)
+	case "fake":
+		logger.Warn("using the fake verifier: codes are logged, not delivered")
+		fake := api.NewFakeVerifier()
+		fake.Logger = logger
+		return fake, nil
+	default:
+		twilioVerifier := api.NewTwilioVerifier(cfg.Twilio.AccountSID, cfg.Twilio.AuthToken, cfg.Twilio.ServiceSID, cfg.OTP.AppName)
+		instrumented := api.NewInstrumentedVerifier("twilio-verify", twilioVerifier, metrics)
+		return api.NewResilientVerifier("twilio-verify", instrumented, resilienceOptions(logger, cfg.Provider)), nil
+	}
+}
+
+// resilienceOptions converts the provider settings
+func resilienceOptions(logger *slog.Logger, cfg config.Provider) api.ResilienceOptions {
+	return api.ResilienceOptions{
+		Timeout:          cfg.Timeout,
+		MaxAttempts:      cfg.MaxAttempts,
+		RetryBackoff:     cfg.RetryBackoff,
+		BreakerThreshold: cfg.BreakerThreshold,
+		BreakerCooldown:  cfg.BreakerCooldown,
+		Logger:           logger,
+	}
+}
+
+// newLocalVerifier builds the self-hosted engine from the OTP_*, REDIS_*, TWILIO_* and SMTP_* settings
+func newLocalVerifier(logger *slog.Logger, cfg *config.Config, redisClient *redis.Client, callbacks *api.StatusCallbacks, templates *api.Templates, metrics *api.Metrics) (api.Verifier, error) {
+	opts := api.LocalOptions{
+		HashKey:         []byte(cfg.OTP.HashKey),
+		CodeLength:      cfg.OTP.CodeLength,
+		TTL:             cfg.OTP.TTL,
+		MaxAttempts:     cfg.OTP.MaxAttempts,
+		AppName:         cfg.OTP.AppName,
+		Templates:       templates,
+		StatusCallbacks: callbacks,
+	}
+
+	var store api.Store
+	if cfg.OTP.Store == "redis" {
+		store = api.NewRedisStore(redisClient, "verify:")
+	} else {
+		store = api.NewMemoryStore()
+		// Codes do not outlive the process, so a per-process key is good enough
+		if len(opts.HashKey) == 0 {
+			opts.HashKey = make([]byte, 32)
+			if _, err := rand.Read(opts.HashKey); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	senders, err := newSenders(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// Every channel gets its own circuit, so an SMTP outage does not stop SMS; each attempt is measured
+	for ch, sender := range senders {
+		instrumented := api.NewInstrumentedSender(ch, sender, metrics)
+		senders[ch] = api.NewResilientSender(ch, instrumented, resilienceOptions(logger, cfg.Provider))
+	}
+
+	logger.Info("using the self-hosted OTP engine", "store", cfg.OTP.Store, "channels", cfg.Channels)
+	return api.NewLocalVerifier(store, senders, opts)
+}
+
+// newTracerProvider installs a global tracer provider exporting spans in batches over OTLP/HTTP,
+// and the W3C trace context propagator so incoming traces are continued
+func newTracerProvider(cfg config.Telemetry) (*sdktrace.TracerProvider, error) {
+	endpoint, err := url.Parse(cfg.OTLPEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(endpoint.Host),
+		otlptracehttp.WithURLPath(strings.TrimSuffix(endpoint.Path, "/") + "/v1/traces"),
+	}
+	if endpoint.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider, nil
+}
+
+// newAuditTrail opens the audit trail, or returns nil when no directory is configured
+func newAuditTrail(cfg config.Audit) (*api.AuditTrail, error) {
+	if cfg.Dir == "" {
+		return nil, nil
+	}
+	store, err := api.NewFileAuditStore(cfg.Dir, cfg.Retention)
+	if err != nil {
+		return nil, err
+	}
+	return api.NewAuditTrail(store, []byte(cfg.HashKey))
+}
+
+// newTOTPAuthenticator builds the authenticator app support, or returns nil when no encryption
+// key is configured. Enrollments are kept in Redis when it is configured
+func newTOTPAuthenticator(logger *slog.Logger, cfg config.TOTP, redisClient *redis.Client) (*api.TOTPAuthenticator, error) {
+	if cfg.EncryptionKey == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(cfg.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	box, err := api.NewSecretBox(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var store api.Store
+	if redisClient != nil {
+		store = api.NewRedisStore(redisClient, "verify:")
+	} else {
+		logger.Warn("REDIS_ADDR not set, authenticator enrollments are kept in memory and lost on restart")
+		store = api.NewMemoryStore()
+	}
+	return api.NewTOTPAuthenticator(store, box, api.TOTPOptions{
+		Issuer:        cfg.Issuer,
+		Digits:        cfg.Digits,
+		Period:        cfg.Period,
+		Skew:          cfg.Skew,
+		EnrollmentTTL: cfg.EnrollmentTTL,
+	})
+}
+
+// newRecoveryCodes builds the recovery code support, or returns nil when no hash key is
+// configured. Code sets are kept in Redis when it is configured
+func newRecoveryCodes(logger *slog.Logger, cfg config.Recovery, redisClient *redis.Client) (*api.RecoveryCodes, error) {
+	if cfg.HashKey == "" {
+		return nil, nil
+	}
+	var store api.Store
+	if redisClient != nil {
+		store = api.NewRedisStore(redisClient, "verify:")
+	} else {
+		logger.Warn("REDIS_ADDR not set, recovery codes are kept in memory and lost on restart")
+		store = api.NewMemoryStore()
+	}
+	return api.NewRecoveryCodes(store, []byte(cfg.HashKey), api.RecoveryOptions{Count: cfg.Count})
+}
+
+// newEmailLinks builds the email link verification, or returns nil when no key is
+// configured. Pending verifications are kept in Redis when it is configured
+func newEmailLinks(logger *slog.Logger, cfg *config.Config, redisClient *redis.Client) (*api.EmailLinks, error) {
+	if cfg.EmailLink.Key == "" {
+		return nil, nil
+	}
+	mail, err := newSMTPSender(cfg.SMTP)
+	if err != nil {
+		return nil, err
+	}
+	var store api.Store
+	if redisClient != nil {
+		store = api.NewRedisStore(redisClient, "verify:")
+	} else {
+		logger.Warn("REDIS_ADDR not set, email verifications are kept in memory and lost on restart")
+		store = api.NewMemoryStore()
+	}
+	return api.NewEmailLinks(store, mail, []byte(cfg.EmailLink.Key), api.EmailLinkOptions{
+		CallbackURL: cfg.EmailLink.CallbackURL,
+		RedirectURL: cfg.EmailLink.RedirectURL,
+		TTL:         cfg.EmailLink.TTL,
+		MaxAttempts: cfg.OTP.MaxAttempts,
+		Subject:     cfg.EmailLink.Subject,
+		AppName:     cfg.OTP.AppName,
+	})
+}
+
+// newRedisClient creates a Redis client, or returns nil when no address is configured
+func newRedisClient(cfg config.Redis) *redis.Client {
+	if cfg.Addr == "" {
+		return nil
+	}
+	return redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+}
+
+// newTokenIssuer builds the token issuer. Without a signing key file a throwaway key is
+// generated, so tokens stop validating when the process restarts
+func newTokenIssuer(logger *slog.Logger, cfg config.Token) (*api.TokenIssuer, error) {
+	opts := api.TokenOptions{
+		KeyID:    cfg.KeyID,
+		Issuer:   cfg.Issuer,
+		Audience: cfg.Audience,
+		TTL:      cfg.TTL,
+	}
+
+	// Previous keys stay published during rotation so tokens they signed keep validating
+	for _, path := range cfg.PublicKeyFiles {
+		pemData, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		pub, err := api.ParsePublicKeyPEM(pemData)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		opts.PublicKeys = append(opts.PublicKeys, pub)
+	}
+
+	var key crypto.Signer
+	var err error
+	if cfg.SigningKeyFile != "" {
+		pemData, err := os.ReadFile(cfg.SigningKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if key, err = api.ParsePrivateKeyPEM(pemData); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.SigningKeyFile, err)
+		}
+	} else {
+		logger.Warn("TOKEN_SIGNING_KEY_FILE not set, signing tokens with a temporary key")
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, err
+		}
+	}
+	return api.NewTokenIssuer(key, opts)
+}
+
+// newWebhookDispatcher builds the webhook dispatcher; it returns nil when no URL is configured
+func newWebhookDispatcher(logger *slog.Logger, cfg config.Webhook) (*api.WebhookDispatcher, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, nil
+	}
+	return api.NewWebhookDispatcher(api.WebhookOptions{
+		URLs:           cfg.URLs,
+		Secret:         []byte(cfg.Secret),
+		MaxAttempts:    cfg.MaxAttempts,
+		InitialBackoff: cfg.InitialBackoff,
+		MaxBackoff:     cfg.MaxBackoff,
+		Timeout:        cfg.Timeout,
+		Logger:         logger,
+	})
+}
+
+// newSenders creates a message sender for each enabled channel
+func newSenders(cfg *config.Config) (map[string]api.MessageSender, error) {
+	tw := cfg.Twilio
+	senders := make(map[string]api.MessageSender, len(cfg.Channels))
+	for _, ch := range cfg.Channels {
+		switch ch {
+		case data.ChannelSMS:
+			senders[ch] = api.NewTwilioSMSSender(tw.AccountSID, tw.AuthToken, tw.FromNumber)
+		case data.ChannelCall:
+			senders[ch] = api.NewTwilioVoiceSender(tw.AccountSID, tw.AuthToken, tw.FromNumber)
+		case data.ChannelWhatsApp:
+			senders[ch] = api.NewTwilioWhatsAppSender(tw.AccountSID, tw.AuthToken, tw.WhatsAppFrom)
+		case data.ChannelEmail:
+			sender, err := newSMTPSender(cfg.SMTP)
+			if err != nil {
+				return nil, err
+			}
+			senders[ch] = sender
+		}
+	}
+	return senders, nil
+}
+
+// newSMTPSender creates the email sender from the SMTP_* settings
+func newSMTPSender(cfg config.SMTP) (*api.SMTPSender, error) {
+	return api.NewSMTPSender(api.SMTPOptions{
+		Addr:     cfg.Addr,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		From:     cfg.From,
+		Subject:  cfg.Subject,
+	})
 }
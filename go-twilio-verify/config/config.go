@@ -0,0 +1,719 @@
+package config
+
+import (
+	"encoding/base64" // Used to validate encryption keys
+	"errors"          // Used to collect validation errors
+	"flag"            // Command-line flags
+	"fmt"             // Used to format validation errors
+	"net/url"         // Used to validate URLs
+	"os"              // Access to environment variables and secret files
+	"slices"          // Used to validate enumerated settings
+	"strconv"         // Used to parse numeric settings
+	"strings"         // Used to parse list settings
+	"time"            // Used to parse duration settings
+
+	"github.com/joho/godotenv" // Loads settings from a .env file
+)
+
+// Channel names, as used by data.Channel*
+var channels = []string{"sms", "call", "email", "whatsapp"}
+
+// Config holds every setting of the OTP service. It is loaded once at startup by Load
+// and handed to the components that need it; nothing reads the environment afterwards.
+// Zero numbers and durations leave the component's own default in place
+type Config struct {
+	Port      int    // TCP port the HTTP server listens on (PORT, -port; default 8000)
+	LogLevel  string // debug, info, warn or error (LOG_LEVEL, -log-level; default info)
+	LogFormat string // json or text (LOG_FORMAT, -log-format; default json)
+
+	Verifier       string   // OTP engine: twilio, local or fake (OTP_VERIFIER, -verifier; default twilio)
+	Channels       []string // Delivery channels offered (OTP_CHANNELS; default sms)
+	PhoneRegion    string   // Region assumed for phone numbers without a country code, e.g. US (PHONE_DEFAULT_REGION)
+	TrustedProxies []string // IPs or CIDRs whose X-Forwarded-For is trusted (TRUSTED_PROXIES)
+	AdminToken     string   // Bearer token of the /admin endpoints; empty disables them (ADMIN_TOKEN)
+
+	Server    Server    // HTTP server timeouts and shutdown
+	Twilio    Twilio    // Twilio account and senders
+	Provider  Provider  // Deadlines, retries and circuit breaking of provider calls
+	SMTP      SMTP      // Mail server for the email channel
+	Redis     Redis     // Shared store; memory only when Addr is empty
+	OTP       OTP       // Self-hosted engine
+	Messages  Messages  // Message templates
+	Throttle  Throttle  // Send limits
+	Lockout   Lockout   // Brute-force protection of code checks
+	Token     Token     // Verification tokens
+	Webhook   Webhook   // Outbound event webhooks
+	Audit     Audit     // Audit trail of send and verify calls
+	TOTP      TOTP      // Authenticator app enrollment
+	Recovery  Recovery  // Single-use recovery codes
+	EmailLink EmailLink // Email verification links
+	Telemetry Telemetry // Metrics and tracing
+
+	SessionRetention time.Duration // How long verification sessions are kept (SESSION_RETENTION)
+}
+
+// Server holds the HTTP server timeouts
+type Server struct {
+	ReadTimeout   time.Duration // Time to read a whole request (SERVER_READ_TIMEOUT; default 10s)
+	WriteTimeout  time.Duration // Time to handle a request and write the response (SERVER_WRITE_TIMEOUT; default 1m)
+	IdleTimeout   time.Duration // How long an idle keep-alive connection is kept (SERVER_IDLE_TIMEOUT; default 2m)
+	DrainTimeout  time.Duration // How long in-flight requests may finish on shutdown (SERVER_DRAIN_TIMEOUT; default 30s)
+	HealthTimeout time.Duration // Deadline of each /healthz and /readyz check (HEALTH_CHECK_TIMEOUT; default 2s)
+}
+
+// Twilio holds the Twilio account settings
+type Twilio struct {
+	AccountSID        string // Account SID, AC... (TWILIO_ACCOUNT_SID)
+	AuthToken         string // Auth token (TWILIO_AUTHTOKEN)
+	ServiceSID        string // Verify service SID, VA...; required by the twilio engine (TWILIO_SERVICES_ID)
+	FromNumber        string // Number SMS and calls are sent from by the local engine (TWILIO_FROM_NUMBER)
+	WhatsAppFrom      string // WhatsApp sender; FromNumber when empty (TWILIO_WHATSAPP_FROM)
+	StatusCallbackURL string // Public URL of /twilio/status; empty disables delivery tracking (TWILIO_STATUS_CALLBACK_URL)
+}
+
+// Provider holds how calls to Twilio and the mail server are bounded and retried
+type Provider struct {
+	Timeout          time.Duration // Deadline of each attempt (PROVIDER_TIMEOUT)
+	MaxAttempts      int           // Attempts per send including the first; 1 disables retries (PROVIDER_MAX_ATTEMPTS)
+	RetryBackoff     time.Duration // Upper bound of the random wait before the first retry (PROVIDER_RETRY_BACKOFF)
+	BreakerThreshold int           // Consecutive failures that open the circuit (PROVIDER_BREAKER_THRESHOLD)
+	BreakerCooldown  time.Duration // How long an open circuit fails fast (PROVIDER_BREAKER_COOLDOWN)
+}
+
+// SMTP holds the mail server settings of the email channel
+type SMTP struct {
+	Addr     string // host:port (SMTP_ADDR)
+	Username string // Empty disables authentication (SMTP_USERNAME)
+	Password string // (SMTP_PASSWORD)
+	From     string // Sender address (SMTP_FROM)
+	Subject  string // Message subject (SMTP_SUBJECT)
+}
+
+// Redis holds the Redis connection settings
+type Redis struct {
+	Addr     string // host:port; empty keeps all state in memory (REDIS_ADDR)
+	Password string // (REDIS_PASSWORD)
+	DB       int    // Database number (REDIS_DB)
+}
+
+// OTP holds the settings of the self-hosted engine
+type OTP struct {
+	Store       string        // memory or redis (OTP_STORE; default memory)
+	HashKey     string        // Key stored codes are hashed with; required with the redis store (OTP_HASH_KEY)
+	CodeLength  int           // Digits per code, 4 to 10 (OTP_CODE_LENGTH)
+	TTL         time.Duration // Code lifetime; also bounds verification sessions (OTP_TTL)
+	MaxAttempts int           // Checks per code (OTP_MAX_ATTEMPTS)
+	AppName     string        // Name shown in messages; also replaces the Verify service name (OTP_APP_NAME)
+}
+
+// Messages holds where the message templates of the self-hosted engine come from
+type Messages struct {
+	TemplateDir   string // Directory of <locale>[.<channel>].tmpl files; built-in English when empty (TEMPLATE_DIR)
+	DefaultLocale string // Locale used when no requested one is offered (DEFAULT_LOCALE; default en)
+}
+
+// Audit holds where and how long the audit trail is kept
+type Audit struct {
+	Dir       string        // Directory of the audit files; empty disables the trail (AUDIT_DIR)
+	HashKey   string        // Key recipients are hashed with; required with AUDIT_DIR (AUDIT_HASH_KEY)
+	Retention time.Duration // How long records are kept (AUDIT_RETENTION)
+}
+
+// TOTP holds the authenticator app settings
+type TOTP struct {
+	EncryptionKey string        // Base64 of 32 random bytes sealing the secrets; empty disables TOTP (TOTP_ENCRYPTION_KEY)
+	Issuer        string        // Name shown in authenticator apps (TOTP_ISSUER; default OTP_APP_NAME)
+	Digits        int           // 6 or 8 (TOTP_DIGITS)
+	Period        time.Duration // Time step, in whole seconds (TOTP_PERIOD)
+	Skew          int           // Steps accepted either side of the current one (TOTP_SKEW)
+	EnrollmentTTL time.Duration // How long an enrollment waits for confirmation (TOTP_ENROLLMENT_TTL)
+}
+
+// Recovery holds the recovery code settings
+type Recovery struct {
+	HashKey string // Key the codes are hashed with; empty disables recovery codes (RECOVERY_HASH_KEY)
+	Count   int    // Codes per set, 1 to 100 (RECOVERY_CODE_COUNT)
+}
+
+// EmailLink holds the email verification link settings. The email is sent through the SMTP server
+type EmailLink struct {
+	Key         string        // Key the links are signed with; empty disables email links (EMAIL_LINK_KEY)
+	CallbackURL string        // Public URL of /email-verifications/callback the link points to (EMAIL_LINK_CALLBACK_URL)
+	RedirectURL string        // Page the browser is sent to after opening the link (EMAIL_LINK_REDIRECT_URL)
+	TTL         time.Duration // How long links and codes stay valid (EMAIL_LINK_TTL)
+	Subject     string        // Subject of the email (EMAIL_LINK_SUBJECT)
+}
+
+// Telemetry holds the metrics and tracing settings
+type Telemetry struct {
+	Metrics      bool   // Serve Prometheus metrics on /metrics (METRICS_ENABLED)
+	OTLPEndpoint string // OTLP/HTTP collector URL, e.g. http://localhost:4318; empty disables tracing (OTEL_EXPORTER_OTLP_ENDPOINT)
+	ServiceName  string // Service name reported with the spans (OTEL_SERVICE_NAME; default go-twilio-verify)
+}
+
+// Throttle holds the send limits
+type Throttle struct {
+	Cooldown            time.Duration // (THROTTLE_COOLDOWN)
+	MaxCooldown         time.Duration // (THROTTLE_MAX_COOLDOWN)
+	RecipientDailyLimit int           // (THROTTLE_RECIPIENT_DAILY_LIMIT)
+	IPDailyLimit        int           // (THROTTLE_IP_DAILY_LIMIT)
+	AllowedPrefixes     []string      // (OTP_ALLOWED_PREFIXES)
+	DeniedPrefixes      []string      // (OTP_DENIED_PREFIXES)
+}
+
+// Lockout holds the brute-force protection settings
+type Lockout struct {
+	MaxFailures        int           // (VERIFY_MAX_FAILURES)
+	MaxSessionFailures int           // (VERIFY_MAX_SESSION_FAILURES)
+	FailureWindow      time.Duration // (VERIFY_FAILURE_WINDOW)
+	Duration           time.Duration // (VERIFY_LOCKOUT_DURATION)
+}
+
+// Token holds the verification token settings
+type Token struct {
+	SigningKeyFile string        // PEM private key; a temporary key is generated when empty (TOKEN_SIGNING_KEY_FILE)
+	KeyID          string        // (TOKEN_KEY_ID)
+	PublicKeyFiles []string      // Extra public keys published during rotation (TOKEN_PUBLIC_KEY_FILES)
+	Issuer         string        // (TOKEN_ISSUER)
+	Audience       []string      // (TOKEN_AUDIENCE)
+	TTL            time.Duration // (TOKEN_TTL)
+}
+
+// Webhook holds the outbound webhook settings
+type Webhook struct {
+	URLs           []string      // Empty disables webhooks (WEBHOOK_URLS)
+	Secret         string        // Signing key; required with URLs (WEBHOOK_SECRET)
+	MaxAttempts    int           // (WEBHOOK_MAX_ATTEMPTS)
+	InitialBackoff time.Duration // (WEBHOOK_INITIAL_BACKOFF)
+	MaxBackoff     time.Duration // (WEBHOOK_MAX_BACKOFF)
+	Timeout        time.Duration // (WEBHOOK_TIMEOUT)
+}
+
+// Addr returns the address the HTTP server listens on
+func (c *Config) Addr() string { return ":" + strconv.Itoa(c.Port) }
+
+// Load builds the configuration from, in increasing order of precedence: built-in
+// defaults, the .env file, the process environment and the command-line flags in args.
+// Secrets can also be given as a file path in <NAME>_FILE, e.g. TWILIO_AUTHTOKEN_FILE,
+// for container secrets. Every problem found is reported in the returned error
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("go-twilio-verify", flag.ContinueOnError)
+	envFile := fs.String("env-file", ".env", "file to load settings from; a missing default file is ignored")
+	port := fs.Int("port", 0, "TCP port to listen on (PORT)")
+	verifier := fs.String("verifier", "", "OTP engine: twilio, local or fake (OTP_VERIFIER)")
+	logLevel := fs.String("log-level", "", "debug, info, warn or error (LOG_LEVEL)")
+	logFormat := fs.String("log-format", "", "json or text (LOG_FORMAT)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Variables already set in the environment win over the file
+	if err := godotenv.Load(*envFile); err != nil {
+		explicit := false
+		fs.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "env-file" })
+		if explicit || !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("load %s: %w", *envFile, err)
+		}
+	}
+
+	var l loader
+	cfg := &Config{
+		Port:           l.int("PORT"),
+		LogLevel:       l.string("LOG_LEVEL"),
+		LogFormat:      l.string("LOG_FORMAT"),
+		Verifier:       l.string("OTP_VERIFIER"),
+		Channels:       l.list("OTP_CHANNELS"),
+		PhoneRegion:    strings.ToUpper(l.string("PHONE_DEFAULT_REGION")),
+		TrustedProxies: l.list("TRUSTED_PROXIES"),
+		AdminToken:     l.secret("ADMIN_TOKEN"),
+		Server: Server{
+			ReadTimeout:   l.duration("SERVER_READ_TIMEOUT"),
+			WriteTimeout:  l.duration("SERVER_WRITE_TIMEOUT"),
+			IdleTimeout:   l.duration("SERVER_IDLE_TIMEOUT"),
+			DrainTimeout:  l.duration("SERVER_DRAIN_TIMEOUT"),
+			HealthTimeout: l.duration("HEALTH_CHECK_TIMEOUT"),
+		},
+		Twilio: Twilio{
+			AccountSID:        l.secret("TWILIO_ACCOUNT_SID"),
+			AuthToken:         l.secret("TWILIO_AUTHTOKEN"),
+			ServiceSID:        l.string("TWILIO_SERVICES_ID"),
+			FromNumber:        l.string("TWILIO_FROM_NUMBER"),
+			WhatsAppFrom:      l.string("TWILIO_WHATSAPP_FROM"),
+			StatusCallbackURL: l.string("TWILIO_STATUS_CALLBACK_URL"),
+		},
+		Provider: Provider{
+			Timeout:          l.duration("PROVIDER_TIMEOUT"),
+			MaxAttempts:      l.int("PROVIDER_MAX_ATTEMPTS"),
+			RetryBackoff:     l.duration("PROVIDER_RETRY_BACKOFF"),
+			BreakerThreshold: l.int("PROVIDER_BREAKER_THRESHOLD"),
+			BreakerCooldown:  l.duration("PROVIDER_BREAKER_COOLDOWN"),
+		},
+		SMTP: SMTP{
+			Addr:     l.string("SMTP_ADDR"),
+			Username: l.string("SMTP_USERNAME"),
+			Password: l.secret("SMTP_PASSWORD"),
+			From:     l.string("SMTP_FROM"),
+			Subject:  l.string("SMTP_SUBJECT"),
+		},
+		Redis: Redis{
+			Addr:     l.string("REDIS_ADDR"),
+			Password: l.secret("REDIS_PASSWORD"),
+			DB:       l.int("REDIS_DB"),
+		},
+		OTP: OTP{
+			Store:       l.string("OTP_STORE"),
+			HashKey:     l.secret("OTP_HASH_KEY"),
+			CodeLength:  l.int("OTP_CODE_LENGTH"),
+			TTL:         l.duration("OTP_TTL"),
+			MaxAttempts: l.int("OTP_MAX_ATTEMPTS"),
+			AppName:     l.string("OTP_APP_NAME"),
+		},
+		Messages: Messages{
+			TemplateDir:   l.string("TEMPLATE_DIR"),
+			DefaultLocale: l.string("DEFAULT_LOCALE"),
+		},
+		Throttle: Throttle{
+			Cooldown:            l.duration("THROTTLE_COOLDOWN"),
+			MaxCooldown:         l.duration("THROTTLE_MAX_COOLDOWN"),
+			RecipientDailyLimit: l.int("THROTTLE_RECIPIENT_DAILY_LIMIT"),
+			IPDailyLimit:        l.int("THROTTLE_IP_DAILY_LIMIT"),
+			AllowedPrefixes:     l.list("OTP_ALLOWED_PREFIXES"),
+			DeniedPrefixes:      l.list("OTP_DENIED_PREFIXES"),
+		},
+		Lockout: Lockout{
+			MaxFailures:        l.int("VERIFY_MAX_FAILURES"),
+			MaxSessionFailures: l.int("VERIFY_MAX_SESSION_FAILURES"),
+			FailureWindow:      l.duration("VERIFY_FAILURE_WINDOW"),
+			Duration:           l.duration("VERIFY_LOCKOUT_DURATION"),
+		},
+		Token: Token{
+			SigningKeyFile: l.string("TOKEN_SIGNING_KEY_FILE"),
+			KeyID:          l.string("TOKEN_KEY_ID"),
+			PublicKeyFiles: l.list("TOKEN_PUBLIC_KEY_FILES"),
+			Issuer:         l.string("TOKEN_ISSUER"),
+			Audience:       l.list("TOKEN_AUDIENCE"),
+			TTL:            l.duration("TOKEN_TTL"),
+		},
+		Webhook: Webhook{
+			URLs:           l.list("WEBHOOK_URLS"),
+			Secret:         l.secret("WEBHOOK_SECRET"),
+			MaxAttempts:    l.int("WEBHOOK_MAX_ATTEMPTS"),
+			InitialBackoff: l.duration("WEBHOOK_INITIAL_BACKOFF"),
+			MaxBackoff:     l.duration("WEBHOOK_MAX_BACKOFF"),
+			Timeout:        l.duration("WEBHOOK_TIMEOUT"),
+		},
+		Audit: Audit{
+			Dir:       l.string("AUDIT_DIR"),
+			HashKey:   l.secret("AUDIT_HASH_KEY"),
+			Retention: l.duration("AUDIT_RETENTION"),
+		},
+		TOTP: TOTP{
+			EncryptionKey: l.secret("TOTP_ENCRYPTION_KEY"),
+			Issuer:        l.string("TOTP_ISSUER"),
+			Digits:        l.int("TOTP_DIGITS"),
+			Period:        l.duration("TOTP_PERIOD"),
+			Skew:          l.int("TOTP_SKEW"),
+			EnrollmentTTL: l.duration("TOTP_ENROLLMENT_TTL"),
+		},
+		Recovery: Recovery{
+			HashKey: l.secret("RECOVERY_HASH_KEY"),
+			Count:   l.int("RECOVERY_CODE_COUNT"),
+		},
+		EmailLink: EmailLink{
+			Key:         l.secret("EMAIL_LINK_KEY"),
+			CallbackURL: l.string("EMAIL_LINK_CALLBACK_URL"),
+			RedirectURL: l.string("EMAIL_LINK_REDIRECT_URL"),
+			TTL:         l.duration("EMAIL_LINK_TTL"),
+			Subject:     l.string("EMAIL_LINK_SUBJECT"),
+		},
+		Telemetry: Telemetry{
+			Metrics:      l.bool("METRICS_ENABLED"),
+			OTLPEndpoint: l.string("OTEL_EXPORTER_OTLP_ENDPOINT"),
+			ServiceName:  l.string("OTEL_SERVICE_NAME"),
+		},
+		SessionRetention: l.duration("SESSION_RETENTION"),
+	}
+
+	// Flags override the environment
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Port = *port
+		case "verifier":
+			cfg.Verifier = *verifier
+		case "log-level":
+			cfg.LogLevel = *logLevel
+		case "log-format":
+			cfg.LogFormat = *logFormat
+		}
+	})
+
+	cfg.applyDefaults()
+	if err := errors.Join(append(l.errs, cfg.Validate())...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyDefaults fills in the settings whose default is not the zero value
+func (c *Config) applyDefaults() {
+	if c.Port == 0 {
+		c.Port = 8000
+	}
+	if c.LogLevel == "" {
+		c.LogLevel = "info"
+	}
+	if c.LogFormat == "" {
+		c.LogFormat = "json"
+	}
+	if c.Verifier == "" {
+		c.Verifier = "twilio"
+	}
+	if c.Server.ReadTimeout == 0 {
+		c.Server.ReadTimeout = 10 * time.Second
+	}
+	if c.Server.WriteTimeout == 0 {
+		c.Server.WriteTimeout = time.Minute
+	}
+	if c.Server.IdleTimeout == 0 {
+		c.Server.IdleTimeout = 2 * time.Minute
+	}
+	if c.Server.DrainTimeout == 0 {
+		c.Server.DrainTimeout = 30 * time.Second
+	}
+	if len(c.Channels) == 0 {
+		c.Channels = []string{"sms"}
+	}
+	for i, ch := range c.Channels {
+		c.Channels[i] = strings.ToLower(ch)
+	}
+	if c.OTP.Store == "" {
+		c.OTP.Store = "memory"
+	}
+	if c.Messages.DefaultLocale == "" {
+		c.Messages.DefaultLocale = "en"
+	}
+	if c.Twilio.WhatsAppFrom == "" {
+		c.Twilio.WhatsAppFrom = c.Twilio.FromNumber
+	}
+	if c.TOTP.Issuer == "" {
+		c.TOTP.Issuer = c.OTP.AppName
+	}
+	if c.Telemetry.ServiceName == "" {
+		c.Telemetry.ServiceName = "go-twilio-verify"
+	}
+}
+
+// Validate checks that the settings are complete and consistent and reports all problems at once
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+
+	if c.Port < 1 || c.Port > 65535 {
+		fail("PORT: must be between 1 and 65535, got %d", c.Port)
+	}
+	if !slices.Contains([]string{"debug", "info", "warn", "warning", "error"}, strings.ToLower(c.LogLevel)) {
+		fail("LOG_LEVEL: must be debug, info, warn or error, got %q", c.LogLevel)
+	}
+	if !slices.Contains([]string{"json", "text"}, strings.ToLower(c.LogFormat)) {
+		fail("LOG_FORMAT: must be json or text, got %q", c.LogFormat)
+	}
+	for _, ch := range c.Channels {
+		if !slices.Contains(channels, ch) {
+			fail("OTP_CHANNELS: unknown channel %q", ch)
+		}
+	}
+
+	if c.PhoneRegion != "" && !regionCode(c.PhoneRegion) {
+		fail("PHONE_DEFAULT_REGION: must be a two-letter region code such as US, got %q", c.PhoneRegion)
+	}
+
+	switch c.Verifier {
+	case "twilio":
+		c.requireTwilioAccount(fail)
+		if c.Twilio.ServiceSID == "" {
+			fail("TWILIO_SERVICES_ID: required by the twilio verifier")
+		} else if !strings.HasPrefix(c.Twilio.ServiceSID, "VA") {
+			fail("TWILIO_SERVICES_ID: must be a Verify service SID starting with VA")
+		}
+	case "local":
+		c.validateLocal(fail)
+	case "fake":
+	default:
+		fail("OTP_VERIFIER: must be twilio, local or fake, got %q", c.Verifier)
+	}
+
+	if c.Messages.TemplateDir != "" {
+		if info, err := os.Stat(c.Messages.TemplateDir); err != nil || !info.IsDir() {
+			fail("TEMPLATE_DIR: %q is not a directory", c.Messages.TemplateDir)
+		}
+	} else if c.Messages.DefaultLocale != "en" {
+		// Only English is built in
+		fail("TEMPLATE_DIR: required when DEFAULT_LOCALE is not en")
+	}
+	if c.Redis.DB < 0 {
+		fail("REDIS_DB: must not be negative")
+	}
+	if c.Twilio.StatusCallbackURL != "" {
+		if !httpURL(c.Twilio.StatusCallbackURL) {
+			fail("TWILIO_STATUS_CALLBACK_URL: must be an absolute http(s) URL")
+		}
+		if c.Twilio.AuthToken == "" {
+			fail("TWILIO_AUTHTOKEN: required to verify status callback signatures")
+		}
+		if c.Verifier == "twilio" {
+			// Twilio Verify sends its messages itself and offers no per-verification callbacks
+			fail("TWILIO_STATUS_CALLBACK_URL: only supported by OTP_VERIFIER=local, Twilio Verify does not report delivery")
+		}
+	}
+	for _, u := range c.Webhook.URLs {
+		if !httpURL(u) {
+			fail("WEBHOOK_URLS: %q is not an http(s) URL", u)
+		}
+	}
+	if len(c.Webhook.URLs) > 0 && c.Webhook.Secret == "" {
+		fail("WEBHOOK_SECRET: required with WEBHOOK_URLS")
+	}
+	if c.Telemetry.OTLPEndpoint != "" && !httpURL(c.Telemetry.OTLPEndpoint) {
+		fail("OTEL_EXPORTER_OTLP_ENDPOINT: must be an absolute http(s) URL")
+	}
+
+	if c.TOTP.EncryptionKey != "" {
+		if key, err := base64.StdEncoding.DecodeString(c.TOTP.EncryptionKey); err != nil || len(key) != 32 {
+			fail("TOTP_ENCRYPTION_KEY: must be 32 bytes, base64-encoded, e.g. from openssl rand -base64 32")
+		}
+	}
+	if c.TOTP.EncryptionKey != "" && c.AdminToken == "" {
+		fail("ADMIN_TOKEN: required with TOTP_ENCRYPTION_KEY, to authenticate enrollment")
+	}
+	if c.TOTP.Digits != 0 && c.TOTP.Digits != 6 && c.TOTP.Digits != 8 {
+		fail("TOTP_DIGITS: must be 6 or 8, got %d", c.TOTP.Digits)
+	}
+	if c.TOTP.Period%time.Second != 0 {
+		fail("TOTP_PERIOD: must be a whole number of seconds, got %s", c.TOTP.Period)
+	}
+
+	if c.Recovery.HashKey != "" && c.AdminToken == "" {
+		fail("ADMIN_TOKEN: required with RECOVERY_HASH_KEY, to authenticate code generation")
+	}
+	if c.Recovery.Count > 100 {
+		fail("RECOVERY_CODE_COUNT: must be at most 100, got %d", c.Recovery.Count)
+	}
+
+	if c.EmailLink.Key != "" {
+		if !httpURL(c.EmailLink.CallbackURL) {
+			fail("EMAIL_LINK_CALLBACK_URL: required with EMAIL_LINK_KEY, as an absolute http(s) URL")
+		}
+		if c.EmailLink.RedirectURL != "" && !httpURL(c.EmailLink.RedirectURL) {
+			fail("EMAIL_LINK_REDIRECT_URL: must be an absolute http(s) URL")
+		}
+		if c.SMTP.Addr == "" {
+			fail("SMTP_ADDR: required with EMAIL_LINK_KEY")
+		}
+		if c.SMTP.From == "" {
+			fail("SMTP_FROM: required with EMAIL_LINK_KEY")
+		}
+	}
+
+	// Recipient hashes must stay comparable across restarts
+	if c.Audit.Dir != "" && c.Audit.HashKey == "" {
+		fail("AUDIT_HASH_KEY: required with AUDIT_DIR")
+	}
+
+	for name, v := range map[string]int{
+		"THROTTLE_RECIPIENT_DAILY_LIMIT": c.Throttle.RecipientDailyLimit,
+		"THROTTLE_IP_DAILY_LIMIT":        c.Throttle.IPDailyLimit,
+		"VERIFY_MAX_FAILURES":            c.Lockout.MaxFailures,
+		"VERIFY_MAX_SESSION_FAILURES":    c.Lockout.MaxSessionFailures,
+		"OTP_MAX_ATTEMPTS":               c.OTP.MaxAttempts,
+		"WEBHOOK_MAX_ATTEMPTS":           c.Webhook.MaxAttempts,
+		"PROVIDER_MAX_ATTEMPTS":          c.Provider.MaxAttempts,
+		"PROVIDER_BREAKER_THRESHOLD":     c.Provider.BreakerThreshold,
+		"TOTP_SKEW":                      c.TOTP.Skew,
+		"RECOVERY_CODE_COUNT":            c.Recovery.Count,
+	} {
+		if v < 0 {
+			fail("%s: must not be negative, got %d", name, v)
+		}
+	}
+	for name, d := range map[string]time.Duration{
+		"OTP_TTL":                   c.OTP.TTL,
+		"SESSION_RETENTION":         c.SessionRetention,
+		"THROTTLE_COOLDOWN":         c.Throttle.Cooldown,
+		"THROTTLE_MAX_COOLDOWN":     c.Throttle.MaxCooldown,
+		"VERIFY_FAILURE_WINDOW":     c.Lockout.FailureWindow,
+		"VERIFY_LOCKOUT_DURATION":   c.Lockout.Duration,
+		"TOKEN_TTL":                 c.Token.TTL,
+		"WEBHOOK_INITIAL_BACKOFF":   c.Webhook.InitialBackoff,
+		"WEBHOOK_MAX_BACKOFF":       c.Webhook.MaxBackoff,
+		"WEBHOOK_TIMEOUT":           c.Webhook.Timeout,
+		"PROVIDER_TIMEOUT":          c.Provider.Timeout,
+		"PROVIDER_RETRY_BACKOFF":    c.Provider.RetryBackoff,
+		"PROVIDER_BREAKER_COOLDOWN": c.Provider.BreakerCooldown,
+		"AUDIT_RETENTION":           c.Audit.Retention,
+		"SERVER_READ_TIMEOUT":       c.Server.ReadTimeout,
+		"SERVER_WRITE_TIMEOUT":      c.Server.WriteTimeout,
+		"SERVER_IDLE_TIMEOUT":       c.Server.IdleTimeout,
+		"SERVER_DRAIN_TIMEOUT":      c.Server.DrainTimeout,
+		"HEALTH_CHECK_TIMEOUT":      c.Server.HealthTimeout,
+		"TOTP_PERIOD":               c.TOTP.Period,
+		"TOTP_ENROLLMENT_TTL":       c.TOTP.EnrollmentTTL,
+		"EMAIL_LINK_TTL":            c.EmailLink.TTL,
+	} {
+		if d < 0 {
+			fail("%s: must not be negative, got %s", name, d)
+		}
+	}
+
+	// Map iteration order is random; keep the report stable
+	slices.SortFunc(errs, func(a, b error) int { return strings.Compare(a.Error(), b.Error()) })
+	return errors.Join(errs...)
+}
+
+// validateLocal checks the settings of the self-hosted engine and its senders
+func (c *Config) validateLocal(fail func(string, ...any)) {
+	switch c.OTP.Store {
+	case "memory":
+	case "redis":
+		if c.Redis.Addr == "" {
+			fail("REDIS_ADDR: required with OTP_STORE=redis")
+		}
+		// Every instance must hash codes with the same key
+		if c.OTP.HashKey == "" {
+			fail("OTP_HASH_KEY: required with OTP_STORE=redis")
+		}
+	default:
+		fail("OTP_STORE: must be memory or redis, got %q", c.OTP.Store)
+	}
+	if c.OTP.CodeLength != 0 && (c.OTP.CodeLength < 4 || c.OTP.CodeLength > 10) {
+		fail("OTP_CODE_LENGTH: must be between 4 and 10, got %d", c.OTP.CodeLength)
+	}
+	if c.OTP.MaxAttempts < 0 {
+		fail("OTP_MAX_ATTEMPTS: must not be negative, got %d", c.OTP.MaxAttempts)
+	}
+
+	twilioChecked := false
+	for _, ch := range c.Channels {
+		switch ch {
+		case "sms", "call", "whatsapp":
+			if !twilioChecked {
+				c.requireTwilioAccount(fail)
+				twilioChecked = true
+			}
+			if ch == "whatsapp" && c.Twilio.WhatsAppFrom == "" {
+				fail("TWILIO_WHATSAPP_FROM: TWILIO_WHATSAPP_FROM or TWILIO_FROM_NUMBER is required for the whatsapp channel")
+			} else if ch != "whatsapp" && c.Twilio.FromNumber == "" {
+				fail("TWILIO_FROM_NUMBER: required for the %s channel", ch)
+			}
+		case "email":
+			if c.SMTP.Addr == "" {
+				fail("SMTP_ADDR: required for the email channel")
+			}
+			if c.SMTP.From == "" {
+				fail("SMTP_FROM: required for the email channel")
+			}
+		}
+	}
+}
+
+// requireTwilioAccount checks that Twilio credentials are set
+func (c *Config) requireTwilioAccount(fail func(string, ...any)) {
+	if c.Twilio.AccountSID == "" {
+		fail("TWILIO_ACCOUNT_SID: required")
+	} else if !strings.HasPrefix(c.Twilio.AccountSID, "AC") {
+		fail("TWILIO_ACCOUNT_SID: must be an account SID starting with AC")
+	}
+	if c.Twilio.AuthToken == "" {
+		fail("TWILIO_AUTHTOKEN: required")
+	}
+}
+
+// regionCode reports whether s looks like an ISO 3166-1 alpha-2 code; the phone parser checks it is known
+func regionCode(s string) bool {
+	return len(s) == 2 && strings.Trim(s, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") == ""
+}
+
+// httpURL reports whether s is an absolute http or https URL
+func httpURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+// loader reads typed settings from the environment, collecting parse errors
+type loader struct {
+	errs []error
+}
+
+// string returns a setting, or "" when it is unset
+func (l *loader) string(key string) string {
+	return strings.TrimSpace(os.Getenv(key))
+}
+
+// secret returns a setting, or the contents of the file named by <key>_FILE when the
+// setting itself is unset. Setting both is an error
+func (l *loader) secret(key string) string {
+	v, path := os.Getenv(key), os.Getenv(key+"_FILE")
+	if path == "" {
+		return v
+	}
+	if v != "" {
+		l.errs = append(l.errs, fmt.Errorf("%s: set either %s or %s_FILE, not both", key, key, key))
+		return ""
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s_FILE: %w", key, err))
+		return ""
+	}
+	return strings.TrimRight(string(b), "\r\n")
+}
+
+// int parses an optional integer setting; an unset setting yields 0
+func (l *loader) int(key string) int {
+	v := l.string(key)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: must be an integer, got %q", key, v))
+	}
+	return n
+}
+
+// bool parses an optional true/false setting; an unset setting yields false
+func (l *loader) bool(key string) bool {
+	v := l.string(key)
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: must be true or false, got %q", key, v))
+	}
+	return b
+}
+
+// duration parses an optional duration setting such as "10m"; an unset setting yields 0
+func (l *loader) duration(key string) time.Duration {
+	v := l.string(key)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: must be a duration such as \"10m\", got %q", key, v))
+	}
+	return d
+}
+
+// list splits a comma-separated setting, dropping blanks; an unset setting yields nil
+func (l *loader) list(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -1,16 +1,271 @@
 package data
 
+import "time"
+
+// Delivery channels an OTP can be sent through
+const (
+	ChannelSMS      = "sms"      // Text message to PhoneNumber
+	ChannelCall     = "call"     // Voice call to PhoneNumber reading out the code
+	ChannelEmail    = "email"    // Email to Email
+	ChannelWhatsApp = "whatsapp" // WhatsApp message to PhoneNumber
+)
+
+// Factors a user can verify with besides a delivered code
+const (
+	FactorTOTP         = "totp"          // Code generated by an authenticator app (RFC 6238)
+	FactorRecoveryCode = "recovery_code" // Single-use recovery code
+)
+
 // OTPData represents the data structure for sending an OTP
 type OTPData struct {
-	PhoneNumber string `json:"phoneNumber,omitempty" validate:"required"`
-	// PhoneNumber: the recipient's phone number. Marked as required and mapped to the JSON field "phoneNumber".
+	PhoneNumber string `json:"phoneNumber,omitempty" validate:"required_unless=Channel email,omitempty,max=32"`
+	// PhoneNumber: the recipient's phone number, in international format (e.g. +1 415 555 2671) or national format of the default region. Normalized to E.164; required for every channel except email and mapped to the JSON field "phoneNumber".
+
+	Email string `json:"email,omitempty" validate:"required_if=Channel email,omitempty,email"`
+	// Email: the recipient's email address. Required for the email channel and mapped to the JSON field "email".
+
+	Channel string `json:"channel,omitempty" validate:"omitempty,oneof=sms call email whatsapp"`
+	// Channel: how the code is delivered: sms (default), call, email or whatsapp. Mapped to the JSON field "channel".
+
+	Locale string `json:"locale,omitempty" validate:"omitempty,bcp47_language_tag"`
+	// Locale: the language the message is worded in as a BCP 47 tag (e.g. fr or pt-BR). Overrides the Accept-Language header and mapped to the JSON field "locale".
+}
+
+// DeliveryChannel returns the requested channel, defaulting to SMS
+func (d OTPData) DeliveryChannel() string {
+	if d.Channel == "" {
+		return ChannelSMS
+	}
+	return d.Channel
+}
+
+// Recipient returns the address the code is sent to: the email address for the
+// email channel and the phone number otherwise
+func (d OTPData) Recipient() string {
+	if d.DeliveryChannel() == ChannelEmail {
+		return d.Email
+	}
+	return d.PhoneNumber
 }
 
 // VerifyData represents the data structure for verifying an OTP
 type VerifyData struct {
-	User *OTPData `json:"user,omitempty" validate:"required"`
-	// User: a reference to OTPData that includes phone number details. Marked as required and mapped to the JSON field "user".
+	VerificationID string `json:"verificationId,omitempty" validate:"required_without=User"`
+	// VerificationID: the ID returned by the send request. Required unless User is given and mapped to the JSON field "verificationId".
+
+	User *OTPData `json:"user,omitempty" validate:"required_without=VerificationID"`
+	// User: the recipient the code was sent to, checked against their latest verification. Kept for clients that do not send VerificationID; mapped to the JSON field "user".
+
+	Code string `json:"code,omitempty" validate:"required,min=4,max=10,digits"`
+	// Code: the OTP code entered by the user for verification, 4 to 10 digits. Marked as required and mapped to the JSON field "code".
+}
+
+// Verification statuses
+const (
+	StatusPending  = "pending"  // A code was sent and can still be checked
+	StatusApproved = "approved" // The correct code was submitted
+	StatusExpired  = "expired"  // The code was not checked in time
+	StatusCanceled = "canceled" // The verification was canceled or replaced by a newer one
+)
+
+// Verification represents the state of one sent code as returned to clients
+type Verification struct {
+	ID string `json:"verificationId"`
+	// ID: opaque verification ID to submit with the code. Mapped to the JSON field "verificationId".
+
+	Channel string `json:"channel"`
+	// Channel: the channel the code was delivered over. Mapped to the JSON field "channel".
+
+	Status string `json:"status"`
+	// Status: pending, approved, expired or canceled. Mapped to the JSON field "status".
+
+	CreatedAt time.Time `json:"createdAt"`
+	// CreatedAt: when the code was sent. Mapped to the JSON field "createdAt".
+
+	ExpiresAt time.Time `json:"expiresAt"`
+	// ExpiresAt: when the code stops being accepted. Mapped to the JSON field "expiresAt".
+
+	Delivery *Delivery `json:"delivery,omitempty"`
+	// Delivery: latest delivery status reported by the provider, if any. Mapped to the JSON field "delivery".
+
+	CanResend bool `json:"canResend,omitempty"`
+	// CanResend: the code never reached the recipient, so a new one can be sent right away. Mapped to the JSON field "canResend".
+
+	Phone *Phone `json:"phone,omitempty"`
+	// Phone: country and line type of the recipient's phone number; absent for email. Mapped to the JSON field "phone".
+}
+
+// Phone is the metadata of a recipient's phone number
+type Phone struct {
+	Country string `json:"country"`
+	// Country: ISO 3166-1 alpha-2 code of the number's region, e.g. US. Mapped to the JSON field "country".
+
+	LineType string `json:"lineType"`
+	// LineType: mobile, fixed_line, fixed_line_or_mobile, toll_free, voip, ... or unknown. Mapped to the JSON field "lineType".
+}
+
+// Delivery is the delivery status of a sent code as reported by the provider's status callbacks
+type Delivery struct {
+	Status string `json:"status"`
+	// Status: provider status, e.g. sent, delivered, undelivered or failed for messages and completed, busy or no-answer for calls. Mapped to the JSON field "status".
+
+	ErrorCode string `json:"errorCode,omitempty"`
+	// ErrorCode: provider error code explaining a failed delivery. Mapped to the JSON field "errorCode".
+
+	UpdatedAt time.Time `json:"updatedAt"`
+	// UpdatedAt: when the status was reported. Mapped to the JSON field "updatedAt".
+}
+
+// Approval is returned when a code is approved: the verification plus a signed token
+// other services can validate against the JWKS endpoint
+type Approval struct {
+	Verification
+
+	Token string `json:"token,omitempty"`
+	// Token: signed JWT carrying the verified recipient and verification time. Mapped to the JSON field "token".
+
+	TokenExpiresAt *time.Time `json:"tokenExpiresAt,omitempty"`
+	// TokenExpiresAt: when the token stops being valid. Mapped to the JSON field "tokenExpiresAt".
+}
+
+// TOTPEnrollData represents the data structure for enrolling an authenticator app
+type TOTPEnrollData struct {
+	UserID string `json:"userId,omitempty" validate:"required,max=128"`
+	// UserID: the caller's stable identifier of the user the authenticator belongs to. Marked as required and mapped to the JSON field "userId".
+
+	AccountName string `json:"accountName,omitempty" validate:"omitempty,max=128"`
+	// AccountName: the label shown in the authenticator app, e.g. the user's email address; UserID when empty. Mapped to the JSON field "accountName".
+}
+
+// TOTPCodeData represents the data structure for confirming an enrollment or verifying an authenticator code
+type TOTPCodeData struct {
+	UserID string `json:"userId,omitempty" validate:"required,max=128"`
+	// UserID: the user the authenticator was enrolled for. Marked as required and mapped to the JSON field "userId".
+
+	Code string `json:"code,omitempty" validate:"required,min=6,max=8,digits"`
+	// Code: the code currently shown by the authenticator app, 6 or 8 digits. Marked as required and mapped to the JSON field "code".
+}
+
+// TOTPEnrollment is returned when an enrollment starts; it must be confirmed with a code before it is used
+type TOTPEnrollment struct {
+	UserID string `json:"userId"`
+	// UserID: the user the authenticator is enrolled for. Mapped to the JSON field "userId".
+
+	Secret string `json:"secret"`
+	// Secret: the base32 secret, for users who type it in instead of scanning the QR code. Mapped to the JSON field "secret".
+
+	URI string `json:"otpauthUri"`
+	// URI: the otpauth:// key URI the QR code encodes. Mapped to the JSON field "otpauthUri".
+
+	QRCode string `json:"qrCode"`
+	// QRCode: the key URI as a PNG QR code in a data: URI, ready for an img element. Mapped to the JSON field "qrCode".
+
+	ExpiresAt time.Time `json:"expiresAt"`
+	// ExpiresAt: when the enrollment is discarded unless confirmed. Mapped to the JSON field "expiresAt".
+}
+
+// FactorApproval is returned when a user proves a factor other than a delivered code, plus a
+// signed token other services can validate against the JWKS endpoint
+type FactorApproval struct {
+	UserID string `json:"userId"`
+	// UserID: the user who was verified. Mapped to the JSON field "userId".
+
+	Factor string `json:"factor"`
+	// Factor: how the user was verified: totp or recovery_code. Mapped to the JSON field "factor".
+
+	VerifiedAt time.Time `json:"verifiedAt"`
+	// VerifiedAt: when the code was accepted. Mapped to the JSON field "verifiedAt".
+
+	Token string `json:"token,omitempty"`
+	// Token: signed JWT whose subject is the user ID. Mapped to the JSON field "token".
+
+	TokenExpiresAt *time.Time `json:"tokenExpiresAt,omitempty"`
+	// TokenExpiresAt: when the token stops being valid. Mapped to the JSON field "tokenExpiresAt".
+
+	RemainingCodes *int `json:"remainingCodes,omitempty"`
+	// RemainingCodes: recovery codes the user has left, for recovery codes only. Mapped to the JSON field "remainingCodes".
+}
+
+// RecoveryUserData represents the data structure for generating recovery codes
+type RecoveryUserData struct {
+	UserID string `json:"userId,omitempty" validate:"required,max=128"`
+	// UserID: the caller's stable identifier of the user the codes belong to. Marked as required and mapped to the JSON field "userId".
+}
+
+// RecoveryCodeData represents the data structure for using a recovery code
+type RecoveryCodeData struct {
+	UserID string `json:"userId,omitempty" validate:"required,max=128"`
+	// UserID: the user the codes were generated for. Marked as required and mapped to the JSON field "userId".
+
+	Code string `json:"code,omitempty" validate:"required,max=32"`
+	// Code: one of the user's recovery codes; case, spaces and dashes are ignored. Marked as required and mapped to the JSON field "code".
+}
+
+// RecoveryCodes is returned when a set of recovery codes is generated. The codes are only shown once
+type RecoveryCodes struct {
+	UserID string `json:"userId"`
+	// UserID: the user the codes belong to. Mapped to the JSON field "userId".
+
+	Codes []string `json:"codes"`
+	// Codes: the new single-use codes, e.g. 7kq2m-x9hfd. Mapped to the JSON field "codes".
+
+	CreatedAt time.Time `json:"createdAt"`
+	// CreatedAt: when the set was generated; any earlier set stopped working then. Mapped to the JSON field "createdAt".
+}
+
+// EmailLinkData represents the data structure for sending an email verification link
+type EmailLinkData struct {
+	Email string `json:"email,omitempty" validate:"required,email,max=254"`
+	// Email: the address to verify. Marked as required and mapped to the JSON field "email".
+}
+
+// EmailCodeData represents the data structure for verifying an email address with the code from the email
+type EmailCodeData struct {
+	VerificationID string `json:"verificationId,omitempty" validate:"required,max=64"`
+	// VerificationID: the ID returned when the email was sent. Marked as required and mapped to the JSON field "verificationId".
+
+	Code string `json:"code,omitempty" validate:"required,len=6,digits"`
+	// Code: the six-digit code from the email. Marked as required and mapped to the JSON field "code".
+}
+
+// EmailVerification is returned when a verification email was sent
+type EmailVerification struct {
+	ID string `json:"verificationId"`
+	// ID: opaque verification ID to submit with the code. Mapped to the JSON field "verificationId".
+
+	Email string `json:"email"`
+	// Email: the address the link was sent to. Mapped to the JSON field "email".
+
+	ExpiresAt time.Time `json:"expiresAt"`
+	// ExpiresAt: when the link and code stop working. Mapped to the JSON field "expiresAt".
+}
+
+// EmailApproval is returned when an email address is verified by its link or code
+type EmailApproval struct {
+	VerificationID string `json:"verificationId"`
+	// VerificationID: the verification the link or code belonged to. Mapped to the JSON field "verificationId".
+
+	Email string `json:"email"`
+	// Email: the verified address. Mapped to the JSON field "email".
+
+	VerifiedAt time.Time `json:"verifiedAt"`
+	// VerifiedAt: when the link or code was accepted. Mapped to the JSON field "verifiedAt".
+
+	Token string `json:"token,omitempty"`
+	// Token: signed JWT carrying the verified email address and verification time. Mapped to the JSON field "token".
+
+	TokenExpiresAt *time.Time `json:"tokenExpiresAt,omitempty"`
+	// TokenExpiresAt: when the token stops being valid. Mapped to the JSON field "tokenExpiresAt".
+}
+
+// EmailStatus is returned when looking up whether an email address was verified
+type EmailStatus struct {
+	Email string `json:"email"`
+	// Email: the address looked up. Mapped to the JSON field "email".
+
+	Verified bool `json:"verified"`
+	// Verified: whether the address was ever verified by a link or code. Mapped to the JSON field "verified".
 
-	Code string `json:"code,omitempty" validate:"required"`
-	// Code: the OTP code entered by the user for verification. Marked as required and mapped to the JSON field "code".
+	VerifiedAt *time.Time `json:"verifiedAt,omitempty"`
+	// VerifiedAt: when it was last verified. Mapped to the JSON field "verifiedAt".
 }